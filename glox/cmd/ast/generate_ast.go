@@ -12,6 +12,17 @@ import (
 
 type generator struct {
 	buf bytes.Buffer
+	// Node names used by both an expression and a statement. Their visit
+	// methods are suffixed with the kind of node, eg VisitBadExpr, since
+	// visitors like the interpreter implement both visitor interfaces.
+	qualified map[string]bool
+}
+
+func (g *generator) visitMethod(name string, exprRepr string) string {
+	if g.qualified[name] {
+		return "Visit" + name + exprRepr
+	}
+	return "Visit" + name
 }
 
 func (g *generator) writeHeader() {
@@ -27,10 +38,12 @@ import (
 
 type Expr interface {
 	Accept(visitor ExprVisitor) (result interface{}, err error)
+	Span() token.Span
 }
 
 type Stmt interface {
 	Accept(visitor StmtVisitor) error
+	Span() token.Span
 }
 `))
 }
@@ -67,7 +80,7 @@ func (g *generator) writeTypes(types []string, kind exprType) {
 	g.linebreak()
 	for _, typestr := range types {
 		name := strings.TrimSpace(strings.Split(typestr, ":")[0])
-		fmt.Fprintf(&g.buf, "Visit%s(e *%s%s) %s", name, name, exprRepr, return_type)
+		fmt.Fprintf(&g.buf, "%s(e *%s%s) %s", g.visitMethod(name, exprRepr), name, exprRepr, return_type)
 		g.linebreak()
 	}
 	g.buf.Write([]byte("}\n"))
@@ -93,13 +106,51 @@ func (g *generator) writeTypes(types []string, kind exprType) {
 		// implement the Accept method:
 		fmt.Fprintf(&g.buf, "func (e *%s%s) Accept(visitor %sVisitor) %s {", name, exprRepr, exprRepr, return_type)
 		g.linebreak()
-		fmt.Fprintf(&g.buf, "return visitor.Visit%s(e)", name)
+		fmt.Fprintf(&g.buf, "return visitor.%s(e)", g.visitMethod(name, exprRepr))
 		g.linebreak()
 		g.buf.Write([]byte("}"))
 		g.linebreak()
+
+		g.writeSpan(name, exprRepr, fields)
 	}
 }
 
+// writeSpan implements the Span method, which joins the spans of every token
+// and child node of the node. Fields holding plain values, like a literal's
+// value, have no position and are skipped.
+func (g *generator) writeSpan(name string, exprRepr string, fields []string) {
+	g.linebreak()
+	fmt.Fprintf(&g.buf, "func (e *%s%s) Span() token.Span {", name, exprRepr)
+	g.linebreak()
+	g.buf.Write([]byte("var span token.Span"))
+	g.linebreak()
+	for _, field := range fields {
+		field := strings.TrimSpace(field)
+		fieldName := strings.Split(field, " ")[0]
+		fieldType := strings.Split(field, " ")[1]
+		switch {
+		case strings.HasPrefix(fieldType, "[]"):
+			fmt.Fprintf(&g.buf, "for _, item := range e.%s {", fieldName)
+			g.linebreak()
+			g.buf.Write([]byte("span = span.Join(item.Span())"))
+			g.linebreak()
+			g.buf.Write([]byte("}"))
+			g.linebreak()
+		case fieldType == "Expr" || fieldType == "Stmt" || strings.HasPrefix(fieldType, "*"):
+			fmt.Fprintf(&g.buf, "if e.%s != nil {", fieldName)
+			g.linebreak()
+			fmt.Fprintf(&g.buf, "span = span.Join(e.%s.Span())", fieldName)
+			g.linebreak()
+			g.buf.Write([]byte("}"))
+			g.linebreak()
+		}
+	}
+	g.buf.Write([]byte("return span"))
+	g.linebreak()
+	g.buf.Write([]byte("}"))
+	g.linebreak()
+}
+
 func (g *generator) format() (err error) {
 	formatted, err := format.Source(g.buf.Bytes())
 	if err != nil {
@@ -115,6 +166,21 @@ func (g *generator) writeTo(writer io.Writer) {
 	io.Copy(writer, &g.buf)
 }
 
+func sharedNames(exprTypes []string, stmtTypes []string) map[string]bool {
+	exprNames := map[string]bool{}
+	for _, typestr := range exprTypes {
+		exprNames[strings.TrimSpace(strings.Split(typestr, ":")[0])] = true
+	}
+	shared := map[string]bool{}
+	for _, typestr := range stmtTypes {
+		name := strings.TrimSpace(strings.Split(typestr, ":")[0])
+		if exprNames[name] {
+			shared[name] = true
+		}
+	}
+	return shared
+}
+
 func main() {
 	var output = flag.String("o", "pkg/ast/ast_generated.go", "Usage: go run generate_ast.go -o <output_file>")
 	flag.Parse()
@@ -129,30 +195,49 @@ func main() {
 
 	fmt.Println("starting!")
 
-	generator := generator{}
-	generator.writeHeader()
-
-	generator.writeTypes([]string{
+	exprTypes := []string{
 		"Assign : Name *token.Token, Value Expr",
 		"Binary : Left Expr, Operator *token.Token, Right Expr",
-		"Grouping : Expression Expr",
-		"Literal : Value interface{}",
+		"Grouping : LeftParen *token.Token, Expression Expr, RightParen *token.Token",
+		"Literal : Value interface{}, Token *token.Token",
 		"Unary : Operator *token.Token, Right Expr",
 		"Variable : Name *token.Token",
 		"Logical : Left Expr, Operator *token.Token, Right Expr",
 		"Call : Callee Expr, Paren *token.Token, Args []Expr",
-	}, expression)
+		"Get : Object Expr, Name *token.Token",
+		"Set : Object Expr, Name *token.Token, Value Expr",
+		"This : Keyword *token.Token",
+		"Super : Keyword *token.Token, Method *token.Token",
+		"Lambda : Keyword *token.Token, Params []*token.Token, Body []Stmt, RightBrace *token.Token",
+		"List : LeftBracket *token.Token, Elements []Expr, RightBracket *token.Token",
+		"Map : LeftBrace *token.Token, Keys []Expr, Values []Expr, RightBrace *token.Token",
+		"Index : Object Expr, Bracket *token.Token, Index Expr",
+		"IndexSet : Object Expr, Bracket *token.Token, Index Expr, Value Expr",
+		"Bad : From *token.Token, To *token.Token", // Stands in for an expression with syntax errors
+	}
 
-	generator.writeTypes([]string{
+	stmtTypes := []string{
 		"Expression : Expression Expr",
-		"Print : Expression Expr",
+		"Print : Keyword *token.Token, Expression Expr",
 		"Return : Keyword *token.Token, Value Expr",
-		"Var : Name *token.Token, Initializer Expr", // Declaration statement
-		"Block : Statements []Stmt",
-		"Function : Name *token.Token, Params []*token.Token, Body []Stmt",
-		"If : Condition Expr, ThenBranch Stmt, ElseBranch Stmt",
-		"While : Condition Expr, Body Stmt",
-	}, statement)
+		"Var : Keyword *token.Token, Name *token.Token, Initializer Expr", // Declaration statement
+		"Block : LeftBrace *token.Token, Statements []Stmt, RightBrace *token.Token",
+		"Function : Keyword *token.Token, Name *token.Token, Params []*token.Token, Body []Stmt, RightBrace *token.Token", // Keyword is nil for methods
+		"If : Keyword *token.Token, Condition Expr, ThenBranch Stmt, ElseBranch Stmt",
+		"While : Keyword *token.Token, Condition Expr, Body Stmt, Increment Expr", // Increment is only set by desugared for loops
+		"Break : Keyword *token.Token",
+		"Continue : Keyword *token.Token",
+		"Throw : Keyword *token.Token, Value Expr",
+		"Import : Keyword *token.Token, Path *token.Token, Names []*token.Token",                                                         // Names is empty when importing everything
+		"Try : Keyword *token.Token, Body []Stmt, CatchName *token.Token, CatchBody []Stmt, FinallyBody []Stmt, RightBrace *token.Token", // RightBrace closes the last clause
+		"Class : Keyword *token.Token, Name *token.Token, Superclass *VariableExpr, Methods []*FunctionStmt, RightBrace *token.Token",
+		"Bad : From *token.Token, To *token.Token", // Stands in for a statement with syntax errors
+	}
+
+	generator := generator{qualified: sharedNames(exprTypes, stmtTypes)}
+	generator.writeHeader()
+	generator.writeTypes(exprTypes, expression)
+	generator.writeTypes(stmtTypes, statement)
 
 	err = generator.format()
 	if err != nil {
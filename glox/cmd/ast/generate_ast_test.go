@@ -43,6 +43,26 @@ func TestASTGenerator(t *testing.T) {
 				}, statement)
 			},
 		},
+		{
+			name:    "test span of list and value fields",
+			fixture: "span-types.txt",
+			doTest: func(g *generator) {
+				g.writeTypes([]string{
+					"Literal : Value interface{}, Token *token.Token",
+					"Call : Callee Expr, Paren *token.Token, Args []Expr",
+				}, expression)
+			},
+		},
+		{
+			name:    "test names shared by expressions and statements",
+			fixture: "shared-types.txt",
+			doTest: func(g *generator) {
+				exprTypes := []string{"Bad : From *token.Token, To *token.Token"}
+				stmtTypes := []string{"Bad : From *token.Token, To *token.Token", "Print : Expression Expr"}
+				g.qualified = sharedNames(exprTypes, stmtTypes)
+				g.writeTypes(stmtTypes, statement)
+			},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/lox"
+)
+
+// compile precompiles a script into a .loxc file, which glox then runs without
+// parsing it again. It is written next to the script unless -o says otherwise.
+func compile(args []string, options []lox.Option, color bool) {
+	flags := newFlagSet("compile", "compile [-o output] script")
+	output := flags.String("o", "", "where to write the compiled script (default: the script's path with a .loxc extension)")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	path := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".loxc"
+	}
+
+	bytecode, err := lox.NewEngine(options...).Compile(path)
+	if err != nil {
+		diagnostic.NewPrinter(os.Stderr, color).Print(err)
+		os.Exit(exitCode(err))
+	}
+	err = os.WriteFile(*output, bytecode, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitIOErr)
+	}
+}
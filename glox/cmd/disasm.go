@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/lox"
+)
+
+// disasm prints the bytecode a script compiles to. With --trace, it then runs
+// the script on the VM, printing the stack before each instruction.
+func disasm(args []string, options []lox.Option, color bool) {
+	flags := newFlagSet("disasm", "disasm [--trace] script")
+	trace := flags.Bool("trace", false, "run the script, tracing each instruction")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	path := flags.Arg(0)
+
+	err := lox.NewEngine(options...).Disassemble(path)
+	if err == nil && *trace {
+		fmt.Println()
+		options = append(options, lox.WithBackend(lox.VM), lox.WithTrace(os.Stdout))
+		err = lox.NewEngine(options...).RunFile(path)
+	}
+	if err != nil {
+		diagnostic.NewPrinter(os.Stderr, color).Print(err)
+		os.Exit(exitCode(err))
+	}
+}
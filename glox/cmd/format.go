@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/diff"
+	"github.com/modulitos/glox/pkg/formatter"
+	"github.com/modulitos/glox/pkg/lox"
+)
+
+// format formats scripts. It prints them formatted, unless -w writes them back
+// to their files or -d prints how formatting changes them. Every script is
+// formatted even if some fail, and the exit code tells the worst failure.
+func format(args []string, options []lox.Option, color bool) {
+	flags := newFlagSet("fmt", "fmt [-w] [-d] script|-...")
+	write := flags.Bool("w", false, "write the formatted scripts back to their files")
+	showDiff := flags.Bool("d", false, "print the changes formatting makes as diffs")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	for _, path := range flags.Args() {
+		if path == "-" && *write {
+			fmt.Fprintln(os.Stderr, "glox: can't write the standard input back")
+			os.Exit(exitUsage)
+		}
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		code := formatScript(path, *write, *showDiff, color)
+		if code > status {
+			status = code
+		}
+	}
+	os.Exit(status)
+}
+
+// formatScript formats a script, and returns the exit code saying how it went.
+func formatScript(path string, write bool, showDiff bool, color bool) int {
+	printer := diagnostic.NewPrinter(os.Stderr, color)
+	name, source, err := readScript(path)
+	if err != nil {
+		printer.Print(err)
+		return exitCode(err)
+	}
+	formatted, err := formatter.Format(name, source)
+	if err != nil {
+		printer.AddSource(name, source)
+		printer.Print(err)
+		return exitCode(err)
+	}
+
+	changed := !bytes.Equal(source, formatted)
+	if showDiff && changed {
+		fmt.Print(diff.Unified(name+".orig", name, source, formatted))
+	}
+	if write && changed {
+		// Writing over the file keeps its permissions.
+		err = os.WriteFile(path, formatted, 0666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Writing %s: %v\n", path, err)
+			return exitIOErr
+		}
+	}
+	if !write && !showDiff {
+		_, err = os.Stdout.Write(formatted)
+		if err != nil {
+			return exitIOErr
+		}
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/lox"
+	"github.com/modulitos/glox/pkg/parser"
+	"github.com/modulitos/glox/pkg/scanner"
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// tokens prints the tokens the scanner splits a script into, one per line,
+// as their position, their type and their lexeme.
+func tokens(args []string, options []lox.Option, color bool) {
+	name, source := readInspectedScript("tokens", args, color)
+	scanned, err := scan(name, source)
+	for _, t := range scanned {
+		fmt.Printf("%d:%d\t%-12s %s\n", t.Line, t.Column, t.TokenType, t.Lexeme)
+	}
+	exitOnError(name, source, err, color)
+}
+
+// printAst prints the syntax tree the parser builds from a script, as
+// s-expressions.
+func printAst(args []string, options []lox.Option, color bool) {
+	name, source := readInspectedScript("ast", args, color)
+	scanned, err := scan(name, source)
+	exitOnError(name, source, err, color)
+
+	p := parser.Parser{Tokens: scanned}
+	statements, err := p.Parse()
+	printer := ast.AstPrint{}
+	tree, printErr := printer.PrintStmts(statements)
+	if printErr != nil {
+		fmt.Fprintln(os.Stderr, printErr)
+		os.Exit(exitSoftware)
+	}
+	fmt.Print(tree)
+	exitOnError(name, source, err, color)
+}
+
+// check scans, parses and resolves a script without running it, reporting its
+// errors and warnings.
+func check(args []string, options []lox.Option, color bool) {
+	name, source := readInspectedScript("check", args, color)
+	engine := lox.NewEngine(options...)
+	err := engine.Check(name, source)
+	if err != nil {
+		engine.Report(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// readInspectedScript reads the only argument of the commands that inspect a
+// script, which is a path or - for the standard input.
+func readInspectedScript(command string, args []string, color bool) (string, []byte) {
+	flags := newFlagSet(command, command+" script|-")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	name, source, err := readScript(flags.Arg(0))
+	if err != nil {
+		diagnostic.NewPrinter(os.Stderr, color).Print(err)
+		os.Exit(exitCode(err))
+	}
+	return name, source
+}
+
+func scan(name string, source []byte) ([]*token.Token, error) {
+	s := scanner.NewFileScanner(name, source)
+	return s.ScanTokens()
+}
+
+func exitOnError(name string, source []byte, err error, color bool) {
+	if err == nil {
+		return
+	}
+	printer := diagnostic.NewPrinter(os.Stderr, color)
+	printer.AddSource(name, source)
+	printer.Print(err)
+	os.Exit(exitCode(err))
+}
@@ -1,27 +1,152 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 
+	"github.com/modulitos/glox/pkg/diagnostic"
 	"github.com/modulitos/glox/pkg/lox"
+	"github.com/modulitos/glox/pkg/vm"
 )
 
+// Exit codes, from sysexits(3).
+const (
+	exitUsage    = 64 // the command was used incorrectly
+	exitDataErr  = 65 // the script doesn't scan, parse, resolve or compile
+	exitNoInput  = 66 // the script couldn't be read
+	exitSoftware = 70 // the script failed while running
+	exitIOErr    = 74 // an output couldn't be written
+)
+
+// A command takes the arguments following its name, and the options and the
+// color given to glox before it.
+type command func(args []string, options []lox.Option, color bool)
+
+var commands = map[string]command{
+	"run":     run,
+	"repl":    repl,
+	"tokens":  tokens,
+	"ast":     printAst,
+	"check":   check,
+	"fmt":     format,
+	"disasm":  disasm,
+	"compile": compile,
+}
+
+const usage = `Usage: glox [flags] <command> [arguments]
+
+Commands:
+  run [-e code] [script|-] [args...]   run a script, passing it args
+  repl                                 start an interactive prompt
+  tokens script|-                      print the tokens of a script
+  ast script|-                         print the syntax tree of a script
+  check script|-                       check a script for errors without running it
+  fmt [-w] [-d] script|-...            format scripts, printing them, writing them
+                                       back (-w) or printing the changes (-d)
+  disasm [--trace] script              print the bytecode of a script
+  compile [-o output] script           precompile a script into a .loxc file
+
+Without a command, glox runs the script given, or starts the prompt if there is
+none. A script of - is read from the standard input.
+
+Flags:
+`
+
 func main() {
-	if len(os.Args) > 2 {
-		fmt.Println("Usage: glox [script]")
-		os.Exit(64)
-	} else if len(os.Args) == 2 {
-		err := lox.RunFile(os.Args[1])
+	flags := flag.CommandLine
+	flags.Init("glox", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	color := flags.Bool("color", false, "highlight error messages with ANSI colors")
+	werror := flags.Bool("Werror", false, "treat warnings as errors")
+	engine := flags.String("engine", string(lox.TreeWalker), "run scripts with the tree-walking interpreter (tree), the bytecode VM (vm) or the tree compiled to closures (closures)")
+	code := flags.String("e", "", "run the given code instead of a script")
+	parseFlags(flags, os.Args[1:])
+	args := flags.Args()
+
+	backend := lox.Backend(*engine)
+	if backend != lox.TreeWalker && backend != lox.VM && backend != lox.Closures {
+		fmt.Fprintf(os.Stderr, "glox: unknown engine %q\n", *engine)
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	options := []lox.Option{lox.WithColor(*color), lox.WithWarningsAsErrors(*werror), lox.WithBackend(backend)}
+
+	switch {
+	case *code != "":
+		run(append([]string{"-e", *code}, args...), options, *color)
+	case len(args) == 0:
+		repl(args, options, *color)
+	case commands[args[0]] != nil:
+		commands[args[0]](args[1:], options, *color)
+	default:
+		run(args, options, *color)
+	}
+}
+
+// parseFlags parses the flags of glox or of one of its commands. Invalid flags
+// are a usage error, and asking for help isn't an error at all.
+func parseFlags(flags *flag.FlagSet, args []string) {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(exitUsage)
+	}
+}
+
+// newFlagSet returns the flags of a command, printing its usage line when they
+// are invalid.
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: glox %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// readScript reads the script at path, or the standard input if path is -.
+// It returns the name to label the positions in the script with.
+func readScript(path string) (name string, source []byte, err error) {
+	if path == "-" {
+		source, err = io.ReadAll(os.Stdin)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(65)
+			err = fmt.Errorf("Reading standard input: %w", err)
 		}
-	} else {
-		err := lox.RunPrompt()
-		if err != nil {
-			err = fmt.Errorf("exiting due to error: %w", err)
+		return "<stdin>", source, err
+	}
+	source, err = os.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("Reading script file: %w", err)
+	}
+	return path, source, err
+}
+
+// exitCode tells apart the scripts that couldn't be read, the ones that are
+// invalid, and the ones that failed while running.
+func exitCode(err error) int {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return exitNoInput
+	}
+	if errors.Is(err, vm.ErrInvalidBytecode) || errors.Is(err, vm.ErrStaleBytecode) {
+		return exitDataErr
+	}
+	diagnostics := diagnostic.From(err)
+	if diagnostics == nil {
+		return exitSoftware
+	}
+	for _, d := range diagnostics {
+		if d.Code.IsRuntime() {
+			return exitSoftware
 		}
-		os.Exit(65)
 	}
+	return exitDataErr
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/modulitos/glox/pkg/lox"
+)
+
+// run runs a script, the standard input or the code given with -e. The
+// arguments after the script are in the `args` global, as a list of strings.
+func run(args []string, options []lox.Option, color bool) {
+	flags := newFlagSet("run", "run [-e code] [script|-] [args...]")
+	code := flags.String("e", "", "run the given code instead of a script")
+	parseFlags(flags, args)
+	args = flags.Args()
+
+	var path string
+	if *code == "" {
+		if len(args) == 0 {
+			flags.Usage()
+			os.Exit(exitUsage)
+		}
+		path, args = args[0], args[1:]
+	}
+	if filepath.Ext(path) == ".loxc" {
+		// Precompiled scripts only run on the VM.
+		options = append(options, lox.WithBackend(lox.VM))
+	}
+	engine := lox.NewEngine(options...)
+
+	scriptArgs := make([]lox.Value, len(args))
+	for index, arg := range args {
+		scriptArgs[index] = arg
+	}
+	err := engine.SetGlobal("args", scriptArgs)
+	if err == nil {
+		switch path {
+		case "":
+			err = engine.Run("<command line>", []byte(*code))
+		case "-":
+			var name string
+			var source []byte
+			name, source, err = readScript(path)
+			if err == nil {
+				err = engine.Run(name, source)
+			}
+		default:
+			err = engine.RunFile(path)
+		}
+	}
+	if err != nil {
+		engine.Report(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// repl reads and runs statements from the standard input until it ends.
+func repl(args []string, options []lox.Option, color bool) {
+	flags := newFlagSet("repl", "repl")
+	parseFlags(flags, args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	engine := lox.NewEngine(options...)
+	err := engine.RunPrompt()
+	if err != nil {
+		engine.Report(err)
+		os.Exit(exitIOErr)
+	}
+}
@@ -9,10 +9,12 @@ import (
 
 type Expr interface {
 	Accept(visitor ExprVisitor) (result interface{}, err error)
+	Span() token.Span
 }
 
 type Stmt interface {
 	Accept(visitor StmtVisitor) error
+	Span() token.Span
 }
 
 type ExprVisitor interface {
@@ -24,6 +26,16 @@ type ExprVisitor interface {
 	VisitVariable(e *VariableExpr) (result interface{}, err error)
 	VisitLogical(e *LogicalExpr) (result interface{}, err error)
 	VisitCall(e *CallExpr) (result interface{}, err error)
+	VisitGet(e *GetExpr) (result interface{}, err error)
+	VisitSet(e *SetExpr) (result interface{}, err error)
+	VisitThis(e *ThisExpr) (result interface{}, err error)
+	VisitSuper(e *SuperExpr) (result interface{}, err error)
+	VisitLambda(e *LambdaExpr) (result interface{}, err error)
+	VisitList(e *ListExpr) (result interface{}, err error)
+	VisitMap(e *MapExpr) (result interface{}, err error)
+	VisitIndex(e *IndexExpr) (result interface{}, err error)
+	VisitIndexSet(e *IndexSetExpr) (result interface{}, err error)
+	VisitBadExpr(e *BadExpr) (result interface{}, err error)
 }
 
 type AssignExpr struct {
@@ -35,6 +47,17 @@ func (e *AssignExpr) Accept(visitor ExprVisitor) (result interface{}, err error)
 	return visitor.VisitAssign(e)
 }
 
+func (e *AssignExpr) Span() token.Span {
+	var span token.Span
+	if e.Name != nil {
+		span = span.Join(e.Name.Span())
+	}
+	if e.Value != nil {
+		span = span.Join(e.Value.Span())
+	}
+	return span
+}
+
 type BinaryExpr struct {
 	Left     Expr
 	Operator *token.Token
@@ -45,22 +68,61 @@ func (e *BinaryExpr) Accept(visitor ExprVisitor) (result interface{}, err error)
 	return visitor.VisitBinary(e)
 }
 
+func (e *BinaryExpr) Span() token.Span {
+	var span token.Span
+	if e.Left != nil {
+		span = span.Join(e.Left.Span())
+	}
+	if e.Operator != nil {
+		span = span.Join(e.Operator.Span())
+	}
+	if e.Right != nil {
+		span = span.Join(e.Right.Span())
+	}
+	return span
+}
+
 type GroupingExpr struct {
+	LeftParen  *token.Token
 	Expression Expr
+	RightParen *token.Token
 }
 
 func (e *GroupingExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
 	return visitor.VisitGrouping(e)
 }
 
+func (e *GroupingExpr) Span() token.Span {
+	var span token.Span
+	if e.LeftParen != nil {
+		span = span.Join(e.LeftParen.Span())
+	}
+	if e.Expression != nil {
+		span = span.Join(e.Expression.Span())
+	}
+	if e.RightParen != nil {
+		span = span.Join(e.RightParen.Span())
+	}
+	return span
+}
+
 type LiteralExpr struct {
 	Value interface{}
+	Token *token.Token
 }
 
 func (e *LiteralExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
 	return visitor.VisitLiteral(e)
 }
 
+func (e *LiteralExpr) Span() token.Span {
+	var span token.Span
+	if e.Token != nil {
+		span = span.Join(e.Token.Span())
+	}
+	return span
+}
+
 type UnaryExpr struct {
 	Operator *token.Token
 	Right    Expr
@@ -70,6 +132,17 @@ func (e *UnaryExpr) Accept(visitor ExprVisitor) (result interface{}, err error)
 	return visitor.VisitUnary(e)
 }
 
+func (e *UnaryExpr) Span() token.Span {
+	var span token.Span
+	if e.Operator != nil {
+		span = span.Join(e.Operator.Span())
+	}
+	if e.Right != nil {
+		span = span.Join(e.Right.Span())
+	}
+	return span
+}
+
 type VariableExpr struct {
 	Name *token.Token
 }
@@ -78,6 +151,14 @@ func (e *VariableExpr) Accept(visitor ExprVisitor) (result interface{}, err erro
 	return visitor.VisitVariable(e)
 }
 
+func (e *VariableExpr) Span() token.Span {
+	var span token.Span
+	if e.Name != nil {
+		span = span.Join(e.Name.Span())
+	}
+	return span
+}
+
 type LogicalExpr struct {
 	Left     Expr
 	Operator *token.Token
@@ -88,6 +169,20 @@ func (e *LogicalExpr) Accept(visitor ExprVisitor) (result interface{}, err error
 	return visitor.VisitLogical(e)
 }
 
+func (e *LogicalExpr) Span() token.Span {
+	var span token.Span
+	if e.Left != nil {
+		span = span.Join(e.Left.Span())
+	}
+	if e.Operator != nil {
+		span = span.Join(e.Operator.Span())
+	}
+	if e.Right != nil {
+		span = span.Join(e.Right.Span())
+	}
+	return span
+}
+
 type CallExpr struct {
 	Callee Expr
 	Paren  *token.Token
@@ -98,6 +193,252 @@ func (e *CallExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
 	return visitor.VisitCall(e)
 }
 
+func (e *CallExpr) Span() token.Span {
+	var span token.Span
+	if e.Callee != nil {
+		span = span.Join(e.Callee.Span())
+	}
+	if e.Paren != nil {
+		span = span.Join(e.Paren.Span())
+	}
+	for _, item := range e.Args {
+		span = span.Join(item.Span())
+	}
+	return span
+}
+
+type GetExpr struct {
+	Object Expr
+	Name   *token.Token
+}
+
+func (e *GetExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitGet(e)
+}
+
+func (e *GetExpr) Span() token.Span {
+	var span token.Span
+	if e.Object != nil {
+		span = span.Join(e.Object.Span())
+	}
+	if e.Name != nil {
+		span = span.Join(e.Name.Span())
+	}
+	return span
+}
+
+type SetExpr struct {
+	Object Expr
+	Name   *token.Token
+	Value  Expr
+}
+
+func (e *SetExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitSet(e)
+}
+
+func (e *SetExpr) Span() token.Span {
+	var span token.Span
+	if e.Object != nil {
+		span = span.Join(e.Object.Span())
+	}
+	if e.Name != nil {
+		span = span.Join(e.Name.Span())
+	}
+	if e.Value != nil {
+		span = span.Join(e.Value.Span())
+	}
+	return span
+}
+
+type ThisExpr struct {
+	Keyword *token.Token
+}
+
+func (e *ThisExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitThis(e)
+}
+
+func (e *ThisExpr) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	return span
+}
+
+type SuperExpr struct {
+	Keyword *token.Token
+	Method  *token.Token
+}
+
+func (e *SuperExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitSuper(e)
+}
+
+func (e *SuperExpr) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Method != nil {
+		span = span.Join(e.Method.Span())
+	}
+	return span
+}
+
+type LambdaExpr struct {
+	Keyword    *token.Token
+	Params     []*token.Token
+	Body       []Stmt
+	RightBrace *token.Token
+}
+
+func (e *LambdaExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitLambda(e)
+}
+
+func (e *LambdaExpr) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	for _, item := range e.Params {
+		span = span.Join(item.Span())
+	}
+	for _, item := range e.Body {
+		span = span.Join(item.Span())
+	}
+	if e.RightBrace != nil {
+		span = span.Join(e.RightBrace.Span())
+	}
+	return span
+}
+
+type ListExpr struct {
+	LeftBracket  *token.Token
+	Elements     []Expr
+	RightBracket *token.Token
+}
+
+func (e *ListExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitList(e)
+}
+
+func (e *ListExpr) Span() token.Span {
+	var span token.Span
+	if e.LeftBracket != nil {
+		span = span.Join(e.LeftBracket.Span())
+	}
+	for _, item := range e.Elements {
+		span = span.Join(item.Span())
+	}
+	if e.RightBracket != nil {
+		span = span.Join(e.RightBracket.Span())
+	}
+	return span
+}
+
+type MapExpr struct {
+	LeftBrace  *token.Token
+	Keys       []Expr
+	Values     []Expr
+	RightBrace *token.Token
+}
+
+func (e *MapExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitMap(e)
+}
+
+func (e *MapExpr) Span() token.Span {
+	var span token.Span
+	if e.LeftBrace != nil {
+		span = span.Join(e.LeftBrace.Span())
+	}
+	for _, item := range e.Keys {
+		span = span.Join(item.Span())
+	}
+	for _, item := range e.Values {
+		span = span.Join(item.Span())
+	}
+	if e.RightBrace != nil {
+		span = span.Join(e.RightBrace.Span())
+	}
+	return span
+}
+
+type IndexExpr struct {
+	Object  Expr
+	Bracket *token.Token
+	Index   Expr
+}
+
+func (e *IndexExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitIndex(e)
+}
+
+func (e *IndexExpr) Span() token.Span {
+	var span token.Span
+	if e.Object != nil {
+		span = span.Join(e.Object.Span())
+	}
+	if e.Bracket != nil {
+		span = span.Join(e.Bracket.Span())
+	}
+	if e.Index != nil {
+		span = span.Join(e.Index.Span())
+	}
+	return span
+}
+
+type IndexSetExpr struct {
+	Object  Expr
+	Bracket *token.Token
+	Index   Expr
+	Value   Expr
+}
+
+func (e *IndexSetExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitIndexSet(e)
+}
+
+func (e *IndexSetExpr) Span() token.Span {
+	var span token.Span
+	if e.Object != nil {
+		span = span.Join(e.Object.Span())
+	}
+	if e.Bracket != nil {
+		span = span.Join(e.Bracket.Span())
+	}
+	if e.Index != nil {
+		span = span.Join(e.Index.Span())
+	}
+	if e.Value != nil {
+		span = span.Join(e.Value.Span())
+	}
+	return span
+}
+
+type BadExpr struct {
+	From *token.Token
+	To   *token.Token
+}
+
+func (e *BadExpr) Accept(visitor ExprVisitor) (result interface{}, err error) {
+	return visitor.VisitBadExpr(e)
+}
+
+func (e *BadExpr) Span() token.Span {
+	var span token.Span
+	if e.From != nil {
+		span = span.Join(e.From.Span())
+	}
+	if e.To != nil {
+		span = span.Join(e.To.Span())
+	}
+	return span
+}
+
 type StmtVisitor interface {
 	VisitExpression(e *ExpressionStmt) error
 	VisitPrint(e *PrintStmt) error
@@ -107,6 +448,13 @@ type StmtVisitor interface {
 	VisitFunction(e *FunctionStmt) error
 	VisitIf(e *IfStmt) error
 	VisitWhile(e *WhileStmt) error
+	VisitBreak(e *BreakStmt) error
+	VisitContinue(e *ContinueStmt) error
+	VisitThrow(e *ThrowStmt) error
+	VisitImport(e *ImportStmt) error
+	VisitTry(e *TryStmt) error
+	VisitClass(e *ClassStmt) error
+	VisitBadStmt(e *BadStmt) error
 }
 
 type ExpressionStmt struct {
@@ -117,7 +465,16 @@ func (e *ExpressionStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitExpression(e)
 }
 
+func (e *ExpressionStmt) Span() token.Span {
+	var span token.Span
+	if e.Expression != nil {
+		span = span.Join(e.Expression.Span())
+	}
+	return span
+}
+
 type PrintStmt struct {
+	Keyword    *token.Token
 	Expression Expr
 }
 
@@ -125,6 +482,17 @@ func (e *PrintStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitPrint(e)
 }
 
+func (e *PrintStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Expression != nil {
+		span = span.Join(e.Expression.Span())
+	}
+	return span
+}
+
 type ReturnStmt struct {
 	Keyword *token.Token
 	Value   Expr
@@ -134,7 +502,19 @@ func (e *ReturnStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitReturn(e)
 }
 
+func (e *ReturnStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Value != nil {
+		span = span.Join(e.Value.Span())
+	}
+	return span
+}
+
 type VarStmt struct {
+	Keyword     *token.Token
 	Name        *token.Token
 	Initializer Expr
 }
@@ -143,25 +523,78 @@ func (e *VarStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitVar(e)
 }
 
+func (e *VarStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Name != nil {
+		span = span.Join(e.Name.Span())
+	}
+	if e.Initializer != nil {
+		span = span.Join(e.Initializer.Span())
+	}
+	return span
+}
+
 type BlockStmt struct {
+	LeftBrace  *token.Token
 	Statements []Stmt
+	RightBrace *token.Token
 }
 
 func (e *BlockStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitBlock(e)
 }
 
+func (e *BlockStmt) Span() token.Span {
+	var span token.Span
+	if e.LeftBrace != nil {
+		span = span.Join(e.LeftBrace.Span())
+	}
+	for _, item := range e.Statements {
+		span = span.Join(item.Span())
+	}
+	if e.RightBrace != nil {
+		span = span.Join(e.RightBrace.Span())
+	}
+	return span
+}
+
 type FunctionStmt struct {
-	Name   *token.Token
-	Params []*token.Token
-	Body   []Stmt
+	Keyword    *token.Token
+	Name       *token.Token
+	Params     []*token.Token
+	Body       []Stmt
+	RightBrace *token.Token
 }
 
 func (e *FunctionStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitFunction(e)
 }
 
+func (e *FunctionStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Name != nil {
+		span = span.Join(e.Name.Span())
+	}
+	for _, item := range e.Params {
+		span = span.Join(item.Span())
+	}
+	for _, item := range e.Body {
+		span = span.Join(item.Span())
+	}
+	if e.RightBrace != nil {
+		span = span.Join(e.RightBrace.Span())
+	}
+	return span
+}
+
 type IfStmt struct {
+	Keyword    *token.Token
 	Condition  Expr
 	ThenBranch Stmt
 	ElseBranch Stmt
@@ -171,11 +604,211 @@ func (e *IfStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitIf(e)
 }
 
+func (e *IfStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Condition != nil {
+		span = span.Join(e.Condition.Span())
+	}
+	if e.ThenBranch != nil {
+		span = span.Join(e.ThenBranch.Span())
+	}
+	if e.ElseBranch != nil {
+		span = span.Join(e.ElseBranch.Span())
+	}
+	return span
+}
+
 type WhileStmt struct {
+	Keyword   *token.Token
 	Condition Expr
 	Body      Stmt
+	Increment Expr
 }
 
 func (e *WhileStmt) Accept(visitor StmtVisitor) error {
 	return visitor.VisitWhile(e)
 }
+
+func (e *WhileStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Condition != nil {
+		span = span.Join(e.Condition.Span())
+	}
+	if e.Body != nil {
+		span = span.Join(e.Body.Span())
+	}
+	if e.Increment != nil {
+		span = span.Join(e.Increment.Span())
+	}
+	return span
+}
+
+type BreakStmt struct {
+	Keyword *token.Token
+}
+
+func (e *BreakStmt) Accept(visitor StmtVisitor) error {
+	return visitor.VisitBreak(e)
+}
+
+func (e *BreakStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	return span
+}
+
+type ContinueStmt struct {
+	Keyword *token.Token
+}
+
+func (e *ContinueStmt) Accept(visitor StmtVisitor) error {
+	return visitor.VisitContinue(e)
+}
+
+func (e *ContinueStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	return span
+}
+
+type ThrowStmt struct {
+	Keyword *token.Token
+	Value   Expr
+}
+
+func (e *ThrowStmt) Accept(visitor StmtVisitor) error {
+	return visitor.VisitThrow(e)
+}
+
+func (e *ThrowStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Value != nil {
+		span = span.Join(e.Value.Span())
+	}
+	return span
+}
+
+type ImportStmt struct {
+	Keyword *token.Token
+	Path    *token.Token
+	Names   []*token.Token
+}
+
+func (e *ImportStmt) Accept(visitor StmtVisitor) error {
+	return visitor.VisitImport(e)
+}
+
+func (e *ImportStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Path != nil {
+		span = span.Join(e.Path.Span())
+	}
+	for _, item := range e.Names {
+		span = span.Join(item.Span())
+	}
+	return span
+}
+
+type TryStmt struct {
+	Keyword     *token.Token
+	Body        []Stmt
+	CatchName   *token.Token
+	CatchBody   []Stmt
+	FinallyBody []Stmt
+	RightBrace  *token.Token
+}
+
+func (e *TryStmt) Accept(visitor StmtVisitor) error {
+	return visitor.VisitTry(e)
+}
+
+func (e *TryStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	for _, item := range e.Body {
+		span = span.Join(item.Span())
+	}
+	if e.CatchName != nil {
+		span = span.Join(e.CatchName.Span())
+	}
+	for _, item := range e.CatchBody {
+		span = span.Join(item.Span())
+	}
+	for _, item := range e.FinallyBody {
+		span = span.Join(item.Span())
+	}
+	if e.RightBrace != nil {
+		span = span.Join(e.RightBrace.Span())
+	}
+	return span
+}
+
+type ClassStmt struct {
+	Keyword    *token.Token
+	Name       *token.Token
+	Superclass *VariableExpr
+	Methods    []*FunctionStmt
+	RightBrace *token.Token
+}
+
+func (e *ClassStmt) Accept(visitor StmtVisitor) error {
+	return visitor.VisitClass(e)
+}
+
+func (e *ClassStmt) Span() token.Span {
+	var span token.Span
+	if e.Keyword != nil {
+		span = span.Join(e.Keyword.Span())
+	}
+	if e.Name != nil {
+		span = span.Join(e.Name.Span())
+	}
+	if e.Superclass != nil {
+		span = span.Join(e.Superclass.Span())
+	}
+	for _, item := range e.Methods {
+		span = span.Join(item.Span())
+	}
+	if e.RightBrace != nil {
+		span = span.Join(e.RightBrace.Span())
+	}
+	return span
+}
+
+type BadStmt struct {
+	From *token.Token
+	To   *token.Token
+}
+
+func (e *BadStmt) Accept(visitor StmtVisitor) error {
+	return visitor.VisitBadStmt(e)
+}
+
+func (e *BadStmt) Span() token.Span {
+	var span token.Span
+	if e.From != nil {
+		span = span.Join(e.From.Span())
+	}
+	if e.To != nil {
+		span = span.Join(e.To.Span())
+	}
+	return span
+}
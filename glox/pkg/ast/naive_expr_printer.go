@@ -6,6 +6,8 @@ import (
 )
 
 type AstPrint struct {
+	// What the last statement visited printed, see PrintStmts.
+	stmt string
 }
 
 func (a *AstPrint) Print(e Expr) (result interface{}, err error) {
@@ -31,11 +33,63 @@ func (a *AstPrint) VisitAssign(e *AssignExpr) (result interface{}, err error) {
 	if err != nil {
 		return "", fmt.Errorf("Failed to stringify expr: err: %w", err)
 	}
-	return fmt.Sprintf("(= %v %v", e.Name.Lexeme, exprStr.(string)), nil
+	return fmt.Sprintf("(= %v %v)", e.Name.Lexeme, exprStr.(string)), nil
+}
+
+func (a *AstPrint) VisitGet(e *GetExpr) (result interface{}, err error) {
+	return a.parenthesize("get "+e.Name.Lexeme, e.Object)
+}
+
+func (a *AstPrint) VisitSet(e *SetExpr) (result interface{}, err error) {
+	return a.parenthesize("set "+e.Name.Lexeme, e.Object, e.Value)
+}
+
+func (a *AstPrint) VisitThis(e *ThisExpr) (result interface{}, err error) {
+	return "this", nil
+}
+
+func (a *AstPrint) VisitSuper(e *SuperExpr) (result interface{}, err error) {
+	return fmt.Sprintf("(super %s)", e.Method.Lexeme), nil
+}
+
+func (a *AstPrint) VisitLambda(e *LambdaExpr) (result interface{}, err error) {
+	params := make([]string, 0, len(e.Params))
+	for _, param := range e.Params {
+		params = append(params, param.Lexeme)
+	}
+	body, err := a.printStmts(e.Body)
+	if err != nil {
+		return "", err
+	}
+	return nest(fmt.Sprintf("fun (%s)", strings.Join(params, " ")), body...), nil
+}
+
+func (a *AstPrint) VisitList(e *ListExpr) (result interface{}, err error) {
+	return a.parenthesize("list", e.Elements...)
+}
+
+func (a *AstPrint) VisitMap(e *MapExpr) (result interface{}, err error) {
+	entries := make([]Expr, 0, 2*len(e.Keys))
+	for index := range e.Keys {
+		entries = append(entries, e.Keys[index], e.Values[index])
+	}
+	return a.parenthesize("map", entries...)
+}
+
+func (a *AstPrint) VisitIndex(e *IndexExpr) (result interface{}, err error) {
+	return a.parenthesize("index", e.Object, e.Index)
+}
+
+func (a *AstPrint) VisitIndexSet(e *IndexSetExpr) (result interface{}, err error) {
+	return a.parenthesize("index=", e.Object, e.Index, e.Value)
+}
+
+func (a *AstPrint) VisitBadExpr(e *BadExpr) (result interface{}, err error) {
+	return "(bad)", nil
 }
 
 func (a *AstPrint) VisitVariable(e *VariableExpr) (interface{}, error) {
-	return fmt.Sprintf("%v", e.Name.Literal), nil
+	return e.Name.Lexeme, nil
 }
 
 func (a *AstPrint) VisitBinary(e *BinaryExpr) (result interface{}, err error) {
@@ -0,0 +1,212 @@
+package ast
+
+import (
+	"strings"
+)
+
+// PrintStmts prints statements as s-expressions, one per line. The statements
+// nested in blocks, functions, classes and the like go on lines of their own,
+// indented under the statement holding them:
+//
+//	(fun add (a b)
+//	  (return (+ a b)))
+func (a *AstPrint) PrintStmts(stmts []Stmt) (string, error) {
+	var b strings.Builder
+	for _, stmt := range stmts {
+		s, err := a.printStmt(stmt)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// Statement visitors only return an error, so they leave what they printed in
+// a.stmt.
+func (a *AstPrint) printStmt(stmt Stmt) (string, error) {
+	err := stmt.Accept(a)
+	return a.stmt, err
+}
+
+func (a *AstPrint) printStmts(stmts []Stmt) ([]string, error) {
+	printed := make([]string, len(stmts))
+	for index, stmt := range stmts {
+		s, err := a.printStmt(stmt)
+		if err != nil {
+			return nil, err
+		}
+		printed[index] = s
+	}
+	return printed, nil
+}
+
+func (a *AstPrint) printExpr(expr Expr) (string, error) {
+	s, err := expr.Accept(a)
+	if err != nil {
+		return "", err
+	}
+	return s.(string), nil
+}
+
+// nest puts each child on its own line, indented under the head.
+func nest(head string, children ...string) string {
+	var b strings.Builder
+	b.WriteString("(")
+	b.WriteString(head)
+	for _, child := range children {
+		b.WriteString("\n  ")
+		b.WriteString(strings.ReplaceAll(child, "\n", "\n  "))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// keyword prints a statement made of a keyword and an optional expression.
+func (a *AstPrint) keyword(name string, expr Expr) error {
+	if expr == nil {
+		a.stmt = "(" + name + ")"
+		return nil
+	}
+	s, err := a.printExpr(expr)
+	a.stmt = "(" + name + " " + s + ")"
+	return err
+}
+
+func (a *AstPrint) VisitExpression(e *ExpressionStmt) (err error) {
+	a.stmt, err = a.printExpr(e.Expression)
+	return
+}
+
+func (a *AstPrint) VisitPrint(e *PrintStmt) error {
+	return a.keyword("print", e.Expression)
+}
+
+func (a *AstPrint) VisitReturn(e *ReturnStmt) error {
+	return a.keyword("return", e.Value)
+}
+
+func (a *AstPrint) VisitThrow(e *ThrowStmt) error {
+	return a.keyword("throw", e.Value)
+}
+
+func (a *AstPrint) VisitBreak(e *BreakStmt) error {
+	return a.keyword("break", nil)
+}
+
+func (a *AstPrint) VisitContinue(e *ContinueStmt) error {
+	return a.keyword("continue", nil)
+}
+
+func (a *AstPrint) VisitVar(e *VarStmt) error {
+	return a.keyword("var "+e.Name.Lexeme, e.Initializer)
+}
+
+func (a *AstPrint) VisitBlock(e *BlockStmt) error {
+	body, err := a.printStmts(e.Statements)
+	a.stmt = nest("block", body...)
+	return err
+}
+
+func (a *AstPrint) VisitFunction(e *FunctionStmt) error {
+	params := make([]string, len(e.Params))
+	for index, param := range e.Params {
+		params[index] = param.Lexeme
+	}
+	body, err := a.printStmts(e.Body)
+	a.stmt = nest("fun "+e.Name.Lexeme+" ("+strings.Join(params, " ")+")", body...)
+	return err
+}
+
+func (a *AstPrint) VisitIf(e *IfStmt) error {
+	condition, err := a.printExpr(e.Condition)
+	if err != nil {
+		return err
+	}
+	branches := []Stmt{e.ThenBranch}
+	if e.ElseBranch != nil {
+		branches = append(branches, e.ElseBranch)
+	}
+	printed, err := a.printStmts(branches)
+	a.stmt = nest("if "+condition, printed...)
+	return err
+}
+
+// The increment of a desugared for loop is printed after its body, where it
+// runs.
+func (a *AstPrint) VisitWhile(e *WhileStmt) error {
+	condition, err := a.printExpr(e.Condition)
+	if err != nil {
+		return err
+	}
+	body, err := a.printStmt(e.Body)
+	if err != nil {
+		return err
+	}
+	children := []string{body}
+	if e.Increment != nil {
+		increment, err := a.printExpr(e.Increment)
+		if err != nil {
+			return err
+		}
+		children = append(children, "(increment "+increment+")")
+	}
+	a.stmt = nest("while "+condition, children...)
+	return nil
+}
+
+func (a *AstPrint) VisitImport(e *ImportStmt) error {
+	names := make([]string, 0, len(e.Names)+1)
+	names = append(names, e.Path.Lexeme)
+	for _, name := range e.Names {
+		names = append(names, name.Lexeme)
+	}
+	a.stmt = "(import " + strings.Join(names, " ") + ")"
+	return nil
+}
+
+func (a *AstPrint) VisitTry(e *TryStmt) error {
+	children, err := a.printStmts(e.Body)
+	if err != nil {
+		return err
+	}
+	if e.CatchName != nil {
+		catch, err := a.printStmts(e.CatchBody)
+		if err != nil {
+			return err
+		}
+		children = append(children, nest("catch "+e.CatchName.Lexeme, catch...))
+	}
+	if e.FinallyBody != nil {
+		finally, err := a.printStmts(e.FinallyBody)
+		if err != nil {
+			return err
+		}
+		children = append(children, nest("finally", finally...))
+	}
+	a.stmt = nest("try", children...)
+	return nil
+}
+
+func (a *AstPrint) VisitClass(e *ClassStmt) error {
+	head := "class " + e.Name.Lexeme
+	if e.Superclass != nil {
+		head += " < " + e.Superclass.Name.Lexeme
+	}
+	methods := make([]string, len(e.Methods))
+	for index, method := range e.Methods {
+		s, err := a.printStmt(method)
+		if err != nil {
+			return err
+		}
+		methods[index] = s
+	}
+	a.stmt = nest(head, methods...)
+	return nil
+}
+
+func (a *AstPrint) VisitBadStmt(e *BadStmt) error {
+	a.stmt = "(bad)"
+	return nil
+}
@@ -0,0 +1,86 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/modulitos/glox/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNaiveStmtPrinter(t *testing.T) {
+	name := func(lexeme string) *token.Token {
+		return &token.Token{TokenType: token.Identifier, Lexeme: lexeme}
+	}
+	plus := &token.Token{TokenType: token.Plus, Lexeme: "+"}
+
+	tests := []struct {
+		name     string
+		stmts    []Stmt
+		expected string
+	}{
+		{
+			name: "one statement per line",
+			stmts: []Stmt{
+				&VarStmt{Name: name("x"), Initializer: &LiteralExpr{Value: 1.0}},
+				&PrintStmt{Expression: &VariableExpr{Name: name("x")}},
+				&ExpressionStmt{Expression: &AssignExpr{Name: name("x"), Value: &LiteralExpr{Value: 2.0}}},
+			},
+			expected: "(var x 1)\n(print x)\n(= x 2)\n",
+		},
+		{
+			name: "nested statements are indented",
+			stmts: []Stmt{
+				&FunctionStmt{
+					Name:   name("add"),
+					Params: []*token.Token{name("a"), name("b")},
+					Body: []Stmt{
+						&IfStmt{
+							Condition:  &VariableExpr{Name: name("a")},
+							ThenBranch: &ReturnStmt{Value: &BinaryExpr{Left: &VariableExpr{Name: name("a")}, Operator: plus, Right: &VariableExpr{Name: name("b")}}},
+							ElseBranch: &BlockStmt{Statements: []Stmt{&ReturnStmt{}}},
+						},
+					},
+				},
+			},
+			expected: "(fun add (a b)\n  (if a\n    (return (+ a b))\n    (block\n      (return))))\n",
+		},
+		{
+			name: "classes, loops and try",
+			stmts: []Stmt{
+				&ClassStmt{
+					Name:       name("B"),
+					Superclass: &VariableExpr{Name: name("A")},
+					Methods:    []*FunctionStmt{{Name: name("m")}},
+				},
+				&WhileStmt{
+					Condition: &LiteralExpr{Value: true},
+					Body:      &BreakStmt{},
+					Increment: &AssignExpr{Name: name("i"), Value: &LiteralExpr{Value: 1.0}},
+				},
+				&TryStmt{
+					Body:        []Stmt{&ThrowStmt{Value: &LiteralExpr{Value: "oops"}}},
+					CatchName:   name("e"),
+					CatchBody:   []Stmt{&ContinueStmt{}},
+					FinallyBody: []Stmt{},
+				},
+			},
+			expected: "(class B < A\n  (fun m ()))\n" +
+				"(while true\n  (break)\n  (increment (= i 1)))\n" +
+				"(try\n  (throw oops)\n  (catch e\n    (continue))\n  (finally))\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given:
+			p := AstPrint{}
+
+			// When:
+			actual, err := p.PrintStmts(tc.stmts)
+
+			// Then:
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
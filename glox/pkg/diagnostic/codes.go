@@ -0,0 +1,82 @@
+package diagnostic
+
+import "strings"
+
+// A Code identifies a kind of diagnostic. Codes are stable, so that they can be
+// searched for and documented, so never renumber or reuse one.
+type Code string
+
+// Scanner errors.
+const (
+	UnexpectedCharacter Code = "E0001"
+	UnterminatedString  Code = "E0002"
+	InvalidNumber       Code = "E0003"
+)
+
+// Parser errors.
+const (
+	ExpectedToken           Code = "E0100"
+	ExpectedExpression      Code = "E0101"
+	InvalidAssignmentTarget Code = "E0102"
+	TooManyParameters       Code = "E0103"
+	TooManyArguments        Code = "E0104"
+	MissingCatchOrFinally   Code = "E0105"
+)
+
+// Resolver errors.
+const (
+	AlreadyDeclared        Code = "E0200"
+	ReadInOwnInitializer   Code = "E0201"
+	InheritFromSelf        Code = "E0202"
+	ReturnFromInitializer  Code = "E0203"
+	ImportOutsideTopLevel  Code = "E0204"
+	BreakOutsideLoop       Code = "E0205"
+	ContinueOutsideLoop    Code = "E0206"
+	ThisOutsideClass       Code = "E0207"
+	SuperOutsideClass      Code = "E0208"
+	SuperWithoutSuperclass Code = "E0209"
+)
+
+// Runtime errors.
+const (
+	RuntimeError      Code = "E0300"
+	UndefinedVariable Code = "E0301"
+	UndefinedProperty Code = "E0302"
+	InvalidOperand    Code = "E0303"
+	NotCallable       Code = "E0304"
+	ArityMismatch     Code = "E0305"
+	IndexOutOfRange   Code = "E0306"
+	ImportFailed      Code = "E0307"
+	UncaughtException Code = "E0308"
+	StackOverflow     Code = "E0309"
+	// A Go panic inside the interpreter, which is a bug in glox rather than in
+	// the program.
+	InternalError Code = "E0310"
+)
+
+// IsRuntime reports whether the code is one of the runtime errors, raised while
+// the program runs rather than before it does.
+func (c Code) IsRuntime() bool {
+	return strings.HasPrefix(string(c), "E03")
+}
+
+// Bytecode compiler errors, for programs exceeding the limits of the VM.
+const (
+	TooManyLocals    Code = "E0400"
+	TooManyUpvalues  Code = "E0401"
+	TooManyConstants Code = "E0402"
+	JumpTooLarge     Code = "E0403"
+	// Retired: the VM used to reject imports with this code, until it
+	// supported them. Kept so that the code is never reused.
+	UnsupportedByVM Code = "E0404"
+)
+
+// Resolver warnings. They don't stop a program from running, unless warnings
+// are treated as errors.
+const (
+	UnusedVariable    Code = "W0001"
+	UnusedParameter   Code = "W0002"
+	ShadowedParameter Code = "W0003"
+	UnreachableCode   Code = "W0004"
+	TopLevelReturn    Code = "W0005"
+)
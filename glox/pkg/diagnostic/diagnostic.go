@@ -0,0 +1,211 @@
+// Package diagnostic describes problems found in Lox source, from syntax errors
+// to uncaught runtime errors, and renders them against the offending source
+// the way rustc and clang do.
+package diagnostic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/modulitos/glox/pkg/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// A Label points at a span of source, with an optional message printed next to
+// its underline.
+type Label struct {
+	Span    token.Span
+	Message string
+}
+
+// A Diagnostic is a single problem in the source. The primary label is where
+// the problem is, and is underlined with carets. Secondary labels add context,
+// like where a conflicting declaration is, and are underlined with dashes.
+//
+// Diagnostics are errors, so they can be returned and wrapped like any other.
+type Diagnostic struct {
+	Severity  Severity
+	Code      Code
+	Message   string
+	Primary   Label
+	Secondary []Label
+	// Notes are printed after the source excerpt, like a stack trace.
+	Notes []string
+}
+
+// Errorf returns an error diagnostic pointing at span.
+func Errorf(code Code, span token.Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Primary:  Label{Span: span},
+	}
+}
+
+// Warningf returns a warning diagnostic pointing at span.
+func Warningf(code Code, span token.Span, format string, args ...interface{}) *Diagnostic {
+	d := Errorf(code, span, format, args...)
+	d.Severity = Warning
+	return d
+}
+
+// WithLabel sets the message printed next to the primary underline.
+func (d *Diagnostic) WithLabel(message string) *Diagnostic {
+	d.Primary.Message = message
+	return d
+}
+
+// WithSecondary adds a secondary label.
+func (d *Diagnostic) WithSecondary(span token.Span, message string) *Diagnostic {
+	d.Secondary = append(d.Secondary, Label{Span: span, Message: message})
+	return d
+}
+
+// WithNote adds a note. Notes can span several lines.
+func (d *Diagnostic) WithNote(note string) *Diagnostic {
+	d.Notes = append(d.Notes, note)
+	return d
+}
+
+// WithStackTrace adds a stack trace as a note, with one line per call in
+// progress, innermost first. Repeated lines, left by deep recursion, are folded
+// like Python does.
+func (d *Diagnostic) WithStackTrace(trace []string) *Diagnostic {
+	if len(trace) == 0 {
+		return d
+	}
+	const maxRepeats = 3
+	var b strings.Builder
+	b.WriteString("stack trace:")
+	for index := 0; index < len(trace); {
+		repeats := 1
+		for index+repeats < len(trace) && trace[index+repeats] == trace[index] {
+			repeats++
+		}
+		shown := repeats
+		if repeats > maxRepeats+1 {
+			shown = maxRepeats
+		}
+		for line := 0; line < shown; line++ {
+			fmt.Fprintf(&b, "\n  %s", trace[index])
+		}
+		if shown < repeats {
+			fmt.Fprintf(&b, "\n  [previous line repeated %d more times]", repeats-maxRepeats)
+		}
+		index += repeats
+	}
+	return d.WithNote(b.String())
+}
+
+// Span returns the primary span of the diagnostic.
+func (d *Diagnostic) Span() token.Span {
+	return d.Primary.Span
+}
+
+// Error formats the diagnostic on a single line, for when there is no source
+// to render it against.
+func (d *Diagnostic) Error() string {
+	var b strings.Builder
+	if d.Primary.Span.IsValid() {
+		fmt.Fprintf(&b, "%s: ", d.Primary.Span)
+	}
+	fmt.Fprintf(&b, "%s[%s]: %s", d.Severity, d.Code, d.Message)
+	for _, note := range d.Notes {
+		fmt.Fprintf(&b, "\nnote: %s", note)
+	}
+	return b.String()
+}
+
+// Errors that know more than a Diagnostic holds, like the interpreter's
+// runtime errors, implement Diagnoser so that they can still be rendered.
+type Diagnoser interface {
+	Diagnostic() *Diagnostic
+}
+
+// From extracts the diagnostics from err, looking through wrapped errors. It
+// returns nil if err holds none.
+func From(err error) List {
+	var list List
+	if errors.As(err, &list) {
+		return list
+	}
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return List{d}
+	}
+	var diagnoser Diagnoser
+	if errors.As(err, &diagnoser) {
+		return List{diagnoser.Diagnostic()}
+	}
+	return nil
+}
+
+// A List collects the diagnostics of a whole pass, like go/scanner.ErrorList.
+type List []*Diagnostic
+
+// Add appends d to the list.
+func (l *List) Add(d *Diagnostic) {
+	*l = append(*l, d)
+}
+
+// Sort orders the list by file and position.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].Primary.Span, l[j].Primary.Span
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Start < b.Start
+	})
+}
+
+// AsErrors returns a copy of the list where every diagnostic is an error, for
+// when warnings should fail the build.
+func (l List) AsErrors() List {
+	errs := make(List, len(l))
+	for index, d := range l {
+		promoted := *d
+		promoted.Severity = Error
+		errs[index] = &promoted
+	}
+	return errs
+}
+
+// Err returns the list as an error, or nil if it is empty.
+func (l List) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Error lists every diagnostic, one per line.
+func (l List) Error() string {
+	messages := make([]string, len(l))
+	for index, d := range l {
+		messages[index] = d.Error()
+	}
+	return strings.Join(messages, "\n")
+}
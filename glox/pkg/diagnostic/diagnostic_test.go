@@ -0,0 +1,187 @@
+package diagnostic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/modulitos/glox/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func span(line, column, start, end int) token.Span {
+	return token.Span{File: "test.lox", Line: line, Column: column, Start: start, End: end}
+}
+
+func TestPrinter_Print(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    error
+		color  bool
+		want   string
+	}{
+		{
+			name:   "caret under the primary span",
+			source: "var x = 1;\nprint x +;\n",
+			err:    Errorf(ExpectedExpression, span(2, 10, 20, 21), "Expect expression.").WithLabel("found ';'"),
+			want: "error[E0101]: Expect expression.\n" +
+				" --> test.lox:2:10\n" +
+				"  |\n" +
+				"2 | print x +;\n" +
+				"  |          ^ found ';'\n" +
+				"\n",
+		},
+		{
+			name:   "secondary labels are underlined with dashes",
+			source: "print f(1, 2;\n",
+			err: Errorf(ExpectedToken, span(1, 13, 12, 13), "Expect ')' after arguments.").
+				WithSecondary(span(1, 8, 7, 8), "to match this '('"),
+			want: "error[E0100]: Expect ')' after arguments.\n" +
+				" --> test.lox:1:13\n" +
+				"  |\n" +
+				"1 | print f(1, 2;\n" +
+				"  |        - to match this '('\n" +
+				"  |             ^\n" +
+				"\n",
+		},
+		{
+			name:   "labels on other lines widen the gutter",
+			source: "class A {}\n\n\n\n\n\n\n\n\nclass A {}\n",
+			err: Errorf(AlreadyDeclared, span(10, 7, 25, 26), "Already a variable named A in this scope.").
+				WithSecondary(span(1, 7, 6, 7), "first declared here"),
+			want: "error[E0200]: Already a variable named A in this scope.\n" +
+				"  --> test.lox:10:7\n" +
+				"   |\n" +
+				" 1 | class A {}\n" +
+				"   |       - first declared here\n" +
+				"10 | class A {}\n" +
+				"   |       ^\n" +
+				"\n",
+		},
+		{
+			name:   "columns count runes and tabs are kept",
+			source: "\tprint \"é\" @;\n",
+			err:    Errorf(UnexpectedCharacter, span(1, 11, 12, 13), "Unexpected character: @"),
+			want: "error[E0001]: Unexpected character: @\n" +
+				" --> test.lox:1:11\n" +
+				"  |\n" +
+				"1 | \tprint \"é\" @;\n" +
+				"  | \t          ^\n" +
+				"\n",
+		},
+		{
+			name:   "multi-line spans are underlined to the end of their first line",
+			source: "print \"abc\ndef\";\n",
+			err:    Errorf(RuntimeError, span(1, 7, 6, 15), "Operand must be a number"),
+			want: "error[E0300]: Operand must be a number\n" +
+				" --> test.lox:1:7\n" +
+				"  |\n" +
+				"1 | print \"abc\n" +
+				"  |       ^^^^\n" +
+				"\n",
+		},
+		{
+			name:   "end of file gets a single caret",
+			source: "print 1",
+			err:    Errorf(ExpectedToken, span(1, 8, 7, 7), "Expect ';' after value.").WithLabel("found end of file"),
+			want: "error[E0100]: Expect ';' after value.\n" +
+				" --> test.lox:1:8\n" +
+				"  |\n" +
+				"1 | print 1\n" +
+				"  |        ^ found end of file\n" +
+				"\n",
+		},
+		{
+			name:   "warnings",
+			source: "var unused;\n",
+			err:    Warningf("W0000", span(1, 5, 4, 10), "Unused."),
+			want: "warning[W0000]: Unused.\n" +
+				" --> test.lox:1:5\n" +
+				"  |\n" +
+				"1 | var unused;\n" +
+				"  |     ^^^^^^\n" +
+				"\n",
+		},
+		{
+			name:   "notes are printed below the excerpt",
+			source: "fun f() {\n  return nil + 1;\n}\nf();\n",
+			err: Errorf(InvalidOperand, span(2, 14, 23, 24), "Operands must be numbers.").
+				WithNote("stack trace:\n  at f (test.lox:2:14)\n  at <script> (test.lox:4:3)"),
+			want: "error[E0303]: Operands must be numbers.\n" +
+				" --> test.lox:2:14\n" +
+				"  |\n" +
+				"2 |   return nil + 1;\n" +
+				"  |              ^\n" +
+				"  = note: stack trace:\n" +
+				"            at f (test.lox:2:14)\n" +
+				"            at <script> (test.lox:4:3)\n" +
+				"\n",
+		},
+		{
+			name:   "color",
+			source: "print x;\n",
+			err:    Errorf(UndefinedVariable, span(1, 7, 6, 7), "Undefined variable: x."),
+			color:  true,
+			want: "\x1b[1;31merror[E0301]\x1b[0m\x1b[1m: Undefined variable: x.\x1b[0m\n" +
+				" \x1b[1;34m-->\x1b[0m test.lox:1:7\n" +
+				"  \x1b[1;34m|\x1b[0m\n" +
+				"\x1b[1;34m1 |\x1b[0m print x;\n" +
+				"  \x1b[1;34m|\x1b[0m       \x1b[1;31m^\x1b[0m\n" +
+				"\n",
+		},
+		{
+			name:   "every diagnostic of a list, through wrapping",
+			source: "@\n#\n",
+			err: fmt.Errorf("Scanning: %w", List{
+				Errorf(UnexpectedCharacter, span(1, 1, 0, 1), "Unexpected character: @"),
+				Errorf(UnexpectedCharacter, span(2, 1, 2, 3), "Unexpected character: #"),
+			}),
+			want: "error[E0001]: Unexpected character: @\n" +
+				" --> test.lox:1:1\n" +
+				"  |\n" +
+				"1 | @\n" +
+				"  | ^\n" +
+				"\n" +
+				"error[E0001]: Unexpected character: #\n" +
+				" --> test.lox:2:1\n" +
+				"  |\n" +
+				"2 | #\n" +
+				"  | ^\n" +
+				"\n",
+		},
+		{
+			name:   "errors without a position",
+			source: "",
+			err:    Errorf(RuntimeError, token.Span{}, "Can't pop from an empty list."),
+			want:   "error[E0300]: Can't pop from an empty list.\n\n",
+		},
+		{
+			name:   "errors that aren't diagnostics",
+			source: "",
+			err:    errors.New("Reading script file: no such file"),
+			want:   "error: Reading script file: no such file\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			printer := NewPrinter(buf, tc.color)
+			printer.AddSource("test.lox", []byte(tc.source))
+
+			printer.Print(tc.err)
+
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
+
+func TestDiagnostic_Error(t *testing.T) {
+	d := Errorf(UndefinedVariable, span(3, 7, 20, 24), "Undefined variable: %s.", "nope")
+	assert.Equal(t, "test.lox:3:7: error[E0301]: Undefined variable: nope.", d.Error())
+
+	list := List{d, Warningf("W0000", token.Span{}, "No position.")}
+	assert.Equal(t, "test.lox:3:7: error[E0301]: Undefined variable: nope.\nwarning[W0000]: No position.", list.Error())
+	assert.Nil(t, List{}.Err())
+}
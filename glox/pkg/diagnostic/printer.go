@@ -0,0 +1,233 @@
+package diagnostic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// ANSI escape codes used in color mode.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[1;31m"
+	colorYellow = "\x1b[1;33m"
+	colorCyan   = "\x1b[1;36m"
+	colorBlue   = "\x1b[1;34m"
+)
+
+// A Printer renders diagnostics with an excerpt of the source they point into,
+// underlining the offending code:
+//
+//	error[E0100]: Expect ';' after value.
+//	 --> script.lox:1:9
+//	  |
+//	1 | print 1 2;
+//	  |         ^ found '2'
+type Printer struct {
+	w       io.Writer
+	color   bool
+	sources map[string][]byte
+}
+
+// NewPrinter returns a printer writing to w. In color mode, severities,
+// underlines and the gutter are highlighted with ANSI escape codes.
+func NewPrinter(w io.Writer, color bool) *Printer {
+	return &Printer{
+		w:       w,
+		color:   color,
+		sources: map[string][]byte{},
+	}
+}
+
+// AddSource registers the source of a file. Source that wasn't read from a
+// file is registered under the empty name. Files that weren't registered are
+// read from disk when a diagnostic points into them.
+func (p *Printer) AddSource(file string, source []byte) {
+	p.sources[file] = source
+}
+
+// Print renders every diagnostic held by err. Other errors are printed as they
+// are.
+func (p *Printer) Print(err error) {
+	diagnostics := From(err)
+	if diagnostics == nil {
+		fmt.Fprintf(p.w, "%s: %v\n", p.paint(colorRed, "error"), err)
+		return
+	}
+	for _, d := range diagnostics {
+		p.print(d)
+	}
+}
+
+func (p *Printer) print(d *Diagnostic) {
+	severityColor := colorRed
+	switch d.Severity {
+	case Warning:
+		severityColor = colorYellow
+	case Note:
+		severityColor = colorCyan
+	}
+	fmt.Fprintf(p.w, "%s%s\n",
+		p.paint(severityColor, fmt.Sprintf("%s[%s]", d.Severity, d.Code)),
+		p.paint(colorBold, ": "+d.Message))
+
+	primary := d.Primary.Span
+	if !primary.IsValid() {
+		p.printNotes(d.Notes, "")
+		fmt.Fprintln(p.w)
+		return
+	}
+
+	marks := []mark{{Label: d.Primary, primary: true}}
+	for _, label := range d.Secondary {
+		if label.Span.IsValid() {
+			marks = append(marks, mark{Label: label})
+		}
+	}
+	gutter := 0
+	for _, m := range marks {
+		if width := len(strconv.Itoa(m.Span.Line)); width > gutter {
+			gutter = width
+		}
+	}
+	pad := strings.Repeat(" ", gutter)
+
+	// Marks are grouped by file, starting with the primary one, and then
+	// printed in source order.
+	var files []string
+	byFile := map[string][]mark{}
+	for _, m := range marks {
+		if _, ok := byFile[m.Span.File]; !ok {
+			files = append(files, m.Span.File)
+		}
+		byFile[m.Span.File] = append(byFile[m.Span.File], m)
+	}
+	for index, file := range files {
+		fileMarks := byFile[file]
+		sort.SliceStable(fileMarks, func(i, j int) bool {
+			a, b := fileMarks[i].Span, fileMarks[j].Span
+			return a.Line < b.Line || a.Line == b.Line && a.Start < b.Start
+		})
+		arrow := "-->"
+		location := primary
+		if index > 0 {
+			arrow = ":::"
+			location = fileMarks[0].Span
+		}
+		fmt.Fprintf(p.w, "%s%s %s\n", pad, p.paint(colorBlue, arrow), location)
+
+		source, ok := p.source(file)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(p.w, "%s %s\n", pad, p.paint(colorBlue, "|"))
+		for i, m := range fileMarks {
+			text, prefix, width, ok := excerpt(source, m.Span)
+			if !ok {
+				continue
+			}
+			if i == 0 || fileMarks[i-1].Span.Line != m.Span.Line {
+				lineNumber := fmt.Sprintf("%*d |", gutter, m.Span.Line)
+				fmt.Fprintf(p.w, "%s %s\n", p.paint(colorBlue, lineNumber), text)
+			}
+			underline, underlineColor := strings.Repeat("-", width), colorBlue
+			if m.primary {
+				underline, underlineColor = strings.Repeat("^", width), severityColor
+			}
+			if m.Message != "" {
+				underline += " " + m.Message
+			}
+			fmt.Fprintf(p.w, "%s %s %s%s\n", pad, p.paint(colorBlue, "|"), prefix, p.paint(underlineColor, underline))
+		}
+	}
+	p.printNotes(d.Notes, pad)
+	fmt.Fprintln(p.w)
+}
+
+// printNotes prints each note below the gutter, with its following lines
+// lined up under its first one:
+//
+//	= note: stack trace:
+//	          at fib (script.lox:2:12)
+func (p *Printer) printNotes(notes []string, pad string) {
+	for _, note := range notes {
+		lines := strings.Split(note, "\n")
+		fmt.Fprintf(p.w, "%s %s %s %s\n", pad, p.paint(colorBlue, "="), p.paint(colorBold, "note:"), lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(p.w, "%s         %s\n", pad, line)
+		}
+	}
+}
+
+type mark struct {
+	Label
+	primary bool
+}
+
+func (p *Printer) paint(color string, text string) string {
+	if !p.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+func (p *Printer) source(file string) ([]byte, bool) {
+	if source, ok := p.sources[file]; ok {
+		return source, true
+	}
+	if file == "" {
+		return nil, false
+	}
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	p.sources[file] = source
+	return source, true
+}
+
+// excerpt returns the source line the span starts on, the whitespace to print
+// before its underline, and how many columns to underline. Spans running past
+// the end of the line are only underlined up to it, and empty spans, like the
+// one of the end of file, still get a single caret.
+func excerpt(source []byte, span token.Span) (text string, prefix string, width int, ok bool) {
+	if span.Start < 0 || span.Start > len(source) {
+		return "", "", 0, false
+	}
+	lineStart := bytes.LastIndexByte(source[:span.Start], '\n') + 1
+	lineEnd := len(source)
+	if index := bytes.IndexByte(source[span.Start:], '\n'); index >= 0 {
+		lineEnd = span.Start + index
+	}
+	text = strings.TrimRight(string(source[lineStart:lineEnd]), "\r")
+
+	// Tabs are kept so that the underline lines up however wide they are
+	// displayed.
+	var b strings.Builder
+	for _, r := range string(source[lineStart:span.Start]) {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	end := span.End
+	if end > lineEnd {
+		end = lineEnd
+	}
+	if end > span.Start {
+		width = utf8.RuneCount(source[span.Start:end])
+	}
+	if width == 0 {
+		width = 1
+	}
+	return text, b.String(), width, true
+}
@@ -0,0 +1,174 @@
+// Package diff compares texts line by line, and prints their differences in
+// the unified format of diff -u, which patch can apply.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The unchanged lines printed around each change.
+const context = 3
+
+// An edit is a line that is in both texts, only in the old one, or only in the
+// new one, as told by its prefix in a unified diff: ' ', '-' or '+'.
+type edit struct {
+	kind byte
+	line string
+}
+
+// Unified returns the unified diff turning old into new, under headers naming
+// them. It is empty if they are the same.
+func Unified(oldName, newName string, old, new []byte) string {
+	edits := diffLines(splitLines(string(old)), splitLines(string(new)))
+	var b strings.Builder
+	// The edits already printed, and the lines of each text they cover.
+	printed, oldLine, newLine := 0, 0, 0
+	for {
+		start := printed
+		for start < len(edits) && edits[start].kind == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		// Changes close enough to share their context go in the same hunk.
+		end := start
+		for {
+			for end < len(edits) && edits[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		from := start - context
+		if from < printed {
+			from = printed
+		}
+		to := end + context
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		for _, e := range edits[printed:from] {
+			oldLine, newLine = advance(e, oldLine, newLine)
+		}
+		oldCount, newCount := 0, 0
+		for _, e := range edits[from:to] {
+			oldCount, newCount = advance(e, oldCount, newCount)
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, e := range edits[from:to] {
+			b.WriteByte(e.kind)
+			b.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		oldLine += oldCount
+		newLine += newCount
+		printed = to
+	}
+	return b.String()
+}
+
+// advance counts the line of an edit in the texts it is in.
+func advance(e edit, oldLine, newLine int) (int, int) {
+	if e.kind != '+' {
+		oldLine++
+	}
+	if e.kind != '-' {
+		newLine++
+	}
+	return oldLine, newLine
+}
+
+// hunkRange formats the lines of a text that a hunk covers, the way diff does:
+// from the line after the ones before it, and only their count if it's one. An
+// empty range is said to start at the line before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// splitLines splits text after each newline, so that a last line without one
+// is told apart from a line with one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines finds the edits turning a into b with as few changes as possible,
+// from their longest common subsequence of lines. Its table is quadratic in the
+// lines between the common start and end of the texts, which is fine for
+// scripts.
+func diffLines(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// common[i][j] is the length of the longest common subsequence of
+	// middleA[i:] and middleB[j:].
+	common := make([][]int, len(middleA)+1)
+	for i := range common {
+		common[i] = make([]int, len(middleB)+1)
+	}
+	for i := len(middleA) - 1; i >= 0; i-- {
+		for j := len(middleB) - 1; j >= 0; j-- {
+			if middleA[i] == middleB[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(middleA) || j < len(middleB) {
+		switch {
+		case i < len(middleA) && j < len(middleB) && middleA[i] == middleB[j]:
+			edits = append(edits, edit{' ', middleA[i]})
+			i++
+			j++
+		case j == len(middleB) || i < len(middleA) && common[i+1][j] >= common[i][j+1]:
+			edits = append(edits, edit{'-', middleA[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', middleB[j]})
+			j++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', line})
+	}
+	return edits
+}
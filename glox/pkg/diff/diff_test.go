@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnified(t *testing.T) {
+	lines := func(from, to int) string {
+		var b strings.Builder
+		for line := from; line <= to; line++ {
+			b.WriteString(strings.Repeat("x", line) + "\n")
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name     string
+		old      string
+		new      string
+		expected string
+	}{
+		{
+			name:     "same texts",
+			old:      "a\nb\n",
+			new:      "a\nb\n",
+			expected: "",
+		},
+		{
+			name: "changed line with its context",
+			old:  "a\nb\nc\nd\ne\nf\n",
+			new:  "a\nb\nc\nD\ne\nf\n",
+			expected: `--- old
++++ new
+@@ -1,6 +1,6 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+`,
+		},
+		{
+			name: "added and removed lines",
+			old:  "a\nb\n",
+			new:  "b\nc\n",
+			expected: `--- old
++++ new
+@@ -1,2 +1,2 @@
+-a
+ b
++c
+`,
+		},
+		{
+			name: "distant changes go in separate hunks",
+			old:  "first\n" + lines(1, 10) + "last\n",
+			new:  "FIRST\n" + lines(1, 10) + "LAST\n",
+			expected: `--- old
++++ new
+@@ -1,4 +1,4 @@
+-first
++FIRST
+ x
+ xx
+ xxx
+@@ -9,4 +9,4 @@
+ xxxxxxxx
+ xxxxxxxxx
+ xxxxxxxxxx
+-last
++LAST
+`,
+		},
+		{
+			name: "lines added to an empty text",
+			old:  "",
+			new:  "a\n",
+			expected: `--- old
++++ new
+@@ -0,0 +1 @@
++a
+`,
+		},
+		{
+			name: "missing newline at the end",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			expected: `--- old
++++ new
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Unified("old", "new", []byte(tc.old), []byte(tc.new))
+
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
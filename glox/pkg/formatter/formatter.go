@@ -0,0 +1,651 @@
+// Package formatter prints Lox code in its one canonical layout: statements on
+// lines of their own, indented by two spaces in each block, opening braces on
+// the line of the statement they belong to, and single spaces between the
+// operators and their operands.
+//
+// Comments are kept where they are. A comment that ended a line still ends the
+// line of the code it followed, which breaks a statement in two if it was in
+// the middle of one: the rest of the statement goes on the next line, indented
+// one level more. The other comments go on lines of their own before the code
+// they preceded. One blank line is kept wherever the code had some between
+// statements.
+package formatter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/parser"
+	"github.com/modulitos/glox/pkg/scanner"
+	"github.com/modulitos/glox/pkg/token"
+)
+
+const indent = "  "
+
+// Format returns the source formatted. The source has to scan and parse
+// without errors, and these are returned otherwise. Formatting the result
+// again doesn't change it, and it parses to the same syntax tree as the
+// source.
+func Format(file string, source []byte) ([]byte, error) {
+	s := scanner.NewFileScanner(file, source)
+	tokens, err := s.ScanTokens()
+	if err != nil {
+		return nil, err
+	}
+	p := parser.Parser{Tokens: tokens}
+	statements, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return newPrinter(tokens).file(statements)
+}
+
+// The printer walks the syntax tree to lay it out, but takes the text of the
+// tokens, and their comments, from the tokens the tree was parsed from. A
+// formatted program has the same tokens as the source, in the same order, so
+// the printer goes through them one after the other as it prints them.
+type printer struct {
+	tokens []*token.Token
+	// The next token to print, and how many of its comments were printed
+	// already.
+	next             int
+	commentsConsumed int
+
+	out    bytes.Buffer
+	indent int
+	// The indentation of the statement being printed, and whether a comment
+	// broke it over several lines.
+	stmtIndent int
+	continued  bool
+	line       strings.Builder
+	// The source line that the last token or comment printed ends on.
+	lastLine int
+	// Whether a blank line in the source is kept before the next line printed.
+	// It isn't at the start of the file, and at the start and end of blocks.
+	blankAllowed bool
+
+	// The first error met. Printing stops there.
+	err error
+}
+
+func newPrinter(tokens []*token.Token) *printer {
+	return &printer{tokens: tokens}
+}
+
+func (p *printer) file(statements []ast.Stmt) ([]byte, error) {
+	p.statements(statements)
+	// The comments at the end of the file come with the end of file token.
+	p.leadingComments()
+	if p.err == nil && p.tokens[p.next].TokenType != token.Eof {
+		p.err = fmt.Errorf("%s: formatter skipped %q", p.tokens[p.next].Span(), p.tokens[p.next].Lexeme)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.out.Bytes(), nil
+}
+
+// token prints the next token, which has to be of the given type. Its comments
+// are printed on lines of their own before it, except for one ending the line
+// of the previous token, which stays there and breaks the line.
+func (p *printer) token(tokenType token.Type) {
+	if p.err != nil {
+		return
+	}
+	t := p.tokens[p.next]
+	if t.TokenType != tokenType {
+		p.err = fmt.Errorf("%s: formatter expected %s, found %q", t.Span(), tokenType, t.Lexeme)
+		return
+	}
+	for p.commentsConsumed < len(t.Comments) {
+		if p.line.Len() > 0 {
+			// The clauses following a block start the line like the
+			// statement does.
+			continues := t.TokenType == token.Else || t.TokenType == token.Catch || t.TokenType == token.Finally
+			p.breakLine(!continues)
+			continue
+		}
+		p.commentLine(t.Comments[p.commentsConsumed])
+		p.commentsConsumed++
+	}
+	if p.line.Len() == 0 {
+		p.keepBlankLine(t.Line)
+	}
+	p.line.WriteString(t.Lexeme)
+	// Strings can span several lines.
+	p.lastLine = t.Line + strings.Count(t.Lexeme, "\n")
+	p.next++
+	p.commentsConsumed = 0
+}
+
+func (p *printer) space() {
+	p.line.WriteByte(' ')
+}
+
+// newline ends the line being printed.
+func (p *printer) newline() {
+	if p.err != nil {
+		return
+	}
+	p.endLine()
+	p.blankAllowed = true
+}
+
+// breakLine ends the line being printed in the middle of a statement, which
+// goes on on the next line, indented one level more than the statement if
+// continuation is set.
+func (p *printer) breakLine(continuation bool) {
+	if p.err != nil {
+		return
+	}
+	p.endLine()
+	p.indent = p.stmtIndent
+	if continuation {
+		p.indent++
+	}
+	p.continued = true
+	p.blankAllowed = false
+}
+
+// endLine prints the line being printed, with the comment of the next token at
+// its end if that comment is on the same source line as its last token.
+func (p *printer) endLine() {
+	line := strings.TrimRight(p.line.String(), " ")
+	next := p.tokens[p.next]
+	if p.commentsConsumed < len(next.Comments) && next.Comments[p.commentsConsumed].Span.Line == p.lastLine {
+		line += " " + commentText(next.Comments[p.commentsConsumed])
+		p.commentsConsumed++
+	}
+	p.writeLine(line)
+	p.line.Reset()
+}
+
+// leadingComments prints the comments left before the next token on lines of
+// their own, when the next token isn't printed at the start of a line, like
+// a closing brace.
+func (p *printer) leadingComments() {
+	if p.err != nil {
+		return
+	}
+	next := p.tokens[p.next]
+	for _, comment := range next.Comments[p.commentsConsumed:] {
+		p.commentLine(comment)
+	}
+	p.commentsConsumed = len(next.Comments)
+}
+
+func (p *printer) hasLeadingComments() bool {
+	return p.err == nil && p.commentsConsumed < len(p.tokens[p.next].Comments)
+}
+
+func (p *printer) commentLine(comment token.Comment) {
+	p.keepBlankLine(comment.Span.Line)
+	p.writeLine(commentText(comment))
+	p.lastLine = comment.Span.Line
+	p.blankAllowed = true
+}
+
+// keepBlankLine prints a blank line before a line starting at the given source
+// line, if there were some blank lines before it in the source.
+func (p *printer) keepBlankLine(line int) {
+	if p.blankAllowed && line > p.lastLine+1 {
+		p.out.WriteByte('\n')
+	}
+}
+
+func (p *printer) writeLine(line string) {
+	p.out.WriteString(strings.Repeat(indent, p.indent))
+	p.out.WriteString(line)
+	p.out.WriteByte('\n')
+}
+
+// The trailing blanks of comments go, like the ones of the code.
+func commentText(comment token.Comment) string {
+	return strings.TrimRight(comment.Text, " \t\r")
+}
+
+// braces prints a pair of braces, and what inside prints between them on lines
+// of their own, indented. Empty braces, with not even a comment between them,
+// stay on the line.
+func (p *printer) braces(empty bool, inside func()) {
+	p.token(token.LeftBrace)
+	if empty && !p.hasLeadingComments() {
+		p.token(token.RightBrace)
+		return
+	}
+	p.newline()
+	p.indent++
+	p.blankAllowed = false
+	inside()
+	p.leadingComments()
+	p.indent--
+	p.blankAllowed = false
+	p.token(token.RightBrace)
+}
+
+func (p *printer) block(statements []ast.Stmt) {
+	p.braces(len(statements) == 0, func() {
+		p.statements(statements)
+	})
+}
+
+func (p *printer) statements(statements []ast.Stmt) {
+	for _, statement := range statements {
+		p.statement(statement)
+	}
+}
+
+// statement prints a statement on lines of its own, and puts the indentation
+// back if a comment broke it over several lines.
+func (p *printer) statement(stmt ast.Stmt) {
+	stmtIndent, continued := p.stmtIndent, p.continued
+	p.stmtIndent, p.continued = p.indent, false
+	p.stmt(stmt)
+	p.newline()
+	p.indent = p.stmtIndent
+	p.stmtIndent, p.continued = stmtIndent, continued
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	if p.err != nil {
+		return
+	}
+	if err := stmt.Accept(p); err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *printer) expr(expr ast.Expr) {
+	if p.err != nil {
+		return
+	}
+	if _, err := expr.Accept(p); err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+// body prints the body of an if, a while or a for on the line of its head, a
+// block opening there.
+func (p *printer) body(body ast.Stmt) {
+	p.space()
+	p.stmt(body)
+}
+
+// function prints the parameters and the body of a function, a method or a
+// lambda.
+func (p *printer) function(params []*token.Token, body []ast.Stmt) {
+	p.token(token.LeftParen)
+	for index := range params {
+		if index > 0 {
+			p.token(token.Comma)
+			p.space()
+		}
+		p.token(token.Identifier)
+	}
+	p.token(token.RightParen)
+	p.space()
+	p.block(body)
+}
+
+// forLoop prints back the for loop that the parser desugared into a while
+// loop, in a block along with the initializer if there is one.
+func (p *printer) forLoop(initializer ast.Stmt, loop *ast.WhileStmt) {
+	p.token(token.For)
+	p.space()
+	p.token(token.LeftParen)
+	if initializer == nil {
+		p.token(token.Semicolon)
+	} else {
+		p.stmt(initializer)
+	}
+	// A loop with no condition got a true literal, which isn't in the source.
+	if literal, ok := loop.Condition.(*ast.LiteralExpr); !ok || literal.Token != nil {
+		p.space()
+		p.expr(loop.Condition)
+	}
+	p.token(token.Semicolon)
+	if loop.Increment != nil {
+		p.space()
+		p.expr(loop.Increment)
+	}
+	p.token(token.RightParen)
+	p.body(loop.Body)
+}
+
+// keyword prints a statement made of a keyword and an optional expression.
+func (p *printer) keyword(keyword token.Type, expr ast.Expr) error {
+	p.token(keyword)
+	if expr != nil {
+		p.space()
+		p.expr(expr)
+	}
+	p.token(token.Semicolon)
+	return nil
+}
+
+func (p *printer) binary(left ast.Expr, operator *token.Token, right ast.Expr) (interface{}, error) {
+	p.expr(left)
+	p.space()
+	p.token(operator.TokenType)
+	p.space()
+	p.expr(right)
+	return nil, nil
+}
+
+// assign prints the equal sign and the value of an assignment.
+func (p *printer) assign(value ast.Expr) (interface{}, error) {
+	p.space()
+	p.token(token.Equal)
+	p.space()
+	p.expr(value)
+	return nil, nil
+}
+
+func (p *printer) list(exprs []ast.Expr) {
+	for index, expr := range exprs {
+		if index > 0 {
+			p.token(token.Comma)
+			p.space()
+		}
+		p.expr(expr)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+func (p *printer) VisitExpression(e *ast.ExpressionStmt) error {
+	p.expr(e.Expression)
+	p.token(token.Semicolon)
+	return nil
+}
+
+func (p *printer) VisitPrint(e *ast.PrintStmt) error {
+	return p.keyword(token.Print, e.Expression)
+}
+
+func (p *printer) VisitReturn(e *ast.ReturnStmt) error {
+	return p.keyword(token.Return, e.Value)
+}
+
+func (p *printer) VisitThrow(e *ast.ThrowStmt) error {
+	return p.keyword(token.Throw, e.Value)
+}
+
+func (p *printer) VisitBreak(e *ast.BreakStmt) error {
+	return p.keyword(token.Break, nil)
+}
+
+func (p *printer) VisitContinue(e *ast.ContinueStmt) error {
+	return p.keyword(token.Continue, nil)
+}
+
+func (p *printer) VisitVar(e *ast.VarStmt) error {
+	p.token(token.Var)
+	p.space()
+	p.token(token.Identifier)
+	if e.Initializer != nil {
+		p.assign(e.Initializer)
+	}
+	p.token(token.Semicolon)
+	return nil
+}
+
+// A block without braces is a for loop with an initializer.
+func (p *printer) VisitBlock(e *ast.BlockStmt) error {
+	if e.LeftBrace == nil && len(e.Statements) == 2 {
+		if loop, ok := e.Statements[1].(*ast.WhileStmt); ok {
+			p.forLoop(e.Statements[0], loop)
+			return nil
+		}
+	}
+	p.block(e.Statements)
+	return nil
+}
+
+// Methods have no fun keyword.
+func (p *printer) VisitFunction(e *ast.FunctionStmt) error {
+	if e.Keyword != nil {
+		p.token(token.Fun)
+		p.space()
+	}
+	p.token(token.Identifier)
+	p.function(e.Params, e.Body)
+	return nil
+}
+
+func (p *printer) VisitIf(e *ast.IfStmt) error {
+	p.token(token.If)
+	p.space()
+	p.token(token.LeftParen)
+	p.expr(e.Condition)
+	p.token(token.RightParen)
+	p.body(e.ThenBranch)
+	if e.ElseBranch != nil {
+		// An else after a broken then branch starts a line of its own,
+		// rather than following the branch.
+		if p.continued {
+			p.breakLine(false)
+		} else {
+			p.space()
+		}
+		p.token(token.Else)
+		p.body(e.ElseBranch)
+	}
+	return nil
+}
+
+func (p *printer) VisitWhile(e *ast.WhileStmt) error {
+	if e.Keyword != nil && e.Keyword.TokenType == token.For {
+		p.forLoop(nil, e)
+		return nil
+	}
+	p.token(token.While)
+	p.space()
+	p.token(token.LeftParen)
+	p.expr(e.Condition)
+	p.token(token.RightParen)
+	p.body(e.Body)
+	return nil
+}
+
+func (p *printer) VisitImport(e *ast.ImportStmt) error {
+	p.token(token.Import)
+	p.space()
+	if len(e.Names) > 0 {
+		p.token(token.LeftBrace)
+		p.space()
+		for index := range e.Names {
+			if index > 0 {
+				p.token(token.Comma)
+				p.space()
+			}
+			p.token(token.Identifier)
+		}
+		p.space()
+		p.token(token.RightBrace)
+		p.space()
+		// from
+		p.token(token.Identifier)
+		p.space()
+	}
+	p.token(token.String)
+	p.token(token.Semicolon)
+	return nil
+}
+
+func (p *printer) VisitTry(e *ast.TryStmt) error {
+	p.token(token.Try)
+	p.space()
+	p.block(e.Body)
+	if e.CatchName != nil {
+		p.space()
+		p.token(token.Catch)
+		p.space()
+		p.token(token.LeftParen)
+		p.token(token.Identifier)
+		p.token(token.RightParen)
+		p.space()
+		p.block(e.CatchBody)
+	}
+	if e.FinallyBody != nil {
+		p.space()
+		p.token(token.Finally)
+		p.space()
+		p.block(e.FinallyBody)
+	}
+	return nil
+}
+
+func (p *printer) VisitClass(e *ast.ClassStmt) error {
+	p.token(token.Class)
+	p.space()
+	p.token(token.Identifier)
+	if e.Superclass != nil {
+		p.space()
+		p.token(token.Less)
+		p.space()
+		p.token(token.Identifier)
+	}
+	p.space()
+	p.braces(len(e.Methods) == 0, func() {
+		for _, method := range e.Methods {
+			p.statement(method)
+		}
+	})
+	return nil
+}
+
+func (p *printer) VisitBadStmt(e *ast.BadStmt) error {
+	return fmt.Errorf("%s: can't format a statement that doesn't parse", e.Span())
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+func (p *printer) VisitAssign(e *ast.AssignExpr) (interface{}, error) {
+	p.token(token.Identifier)
+	return p.assign(e.Value)
+}
+
+func (p *printer) VisitBinary(e *ast.BinaryExpr) (interface{}, error) {
+	return p.binary(e.Left, e.Operator, e.Right)
+}
+
+func (p *printer) VisitLogical(e *ast.LogicalExpr) (interface{}, error) {
+	return p.binary(e.Left, e.Operator, e.Right)
+}
+
+func (p *printer) VisitGrouping(e *ast.GroupingExpr) (interface{}, error) {
+	p.token(token.LeftParen)
+	p.expr(e.Expression)
+	p.token(token.RightParen)
+	return nil, nil
+}
+
+// Literals are printed as they were written, so that numbers keep their
+// digits.
+func (p *printer) VisitLiteral(e *ast.LiteralExpr) (interface{}, error) {
+	if e.Token == nil {
+		return nil, fmt.Errorf("formatter can't print the literal %v, which isn't in the source", e.Value)
+	}
+	p.token(e.Token.TokenType)
+	return nil, nil
+}
+
+func (p *printer) VisitUnary(e *ast.UnaryExpr) (interface{}, error) {
+	p.token(e.Operator.TokenType)
+	p.expr(e.Right)
+	return nil, nil
+}
+
+func (p *printer) VisitVariable(e *ast.VariableExpr) (interface{}, error) {
+	p.token(token.Identifier)
+	return nil, nil
+}
+
+func (p *printer) VisitCall(e *ast.CallExpr) (interface{}, error) {
+	p.expr(e.Callee)
+	p.token(token.LeftParen)
+	p.list(e.Args)
+	p.token(token.RightParen)
+	return nil, nil
+}
+
+func (p *printer) VisitGet(e *ast.GetExpr) (interface{}, error) {
+	p.expr(e.Object)
+	p.token(token.Dot)
+	p.token(token.Identifier)
+	return nil, nil
+}
+
+func (p *printer) VisitSet(e *ast.SetExpr) (interface{}, error) {
+	p.expr(e.Object)
+	p.token(token.Dot)
+	p.token(token.Identifier)
+	return p.assign(e.Value)
+}
+
+func (p *printer) VisitThis(e *ast.ThisExpr) (interface{}, error) {
+	p.token(token.This)
+	return nil, nil
+}
+
+func (p *printer) VisitSuper(e *ast.SuperExpr) (interface{}, error) {
+	p.token(token.Super)
+	p.token(token.Dot)
+	p.token(token.Identifier)
+	return nil, nil
+}
+
+func (p *printer) VisitLambda(e *ast.LambdaExpr) (interface{}, error) {
+	p.token(token.Fun)
+	p.space()
+	p.function(e.Params, e.Body)
+	return nil, nil
+}
+
+func (p *printer) VisitList(e *ast.ListExpr) (interface{}, error) {
+	p.token(token.LeftBracket)
+	p.list(e.Elements)
+	p.token(token.RightBracket)
+	return nil, nil
+}
+
+func (p *printer) VisitMap(e *ast.MapExpr) (interface{}, error) {
+	p.token(token.LeftBrace)
+	for index := range e.Keys {
+		if index > 0 {
+			p.token(token.Comma)
+			p.space()
+		}
+		p.expr(e.Keys[index])
+		p.token(token.Colon)
+		p.space()
+		p.expr(e.Values[index])
+	}
+	p.token(token.RightBrace)
+	return nil, nil
+}
+
+func (p *printer) VisitIndex(e *ast.IndexExpr) (interface{}, error) {
+	p.expr(e.Object)
+	p.token(token.LeftBracket)
+	p.expr(e.Index)
+	p.token(token.RightBracket)
+	return nil, nil
+}
+
+func (p *printer) VisitIndexSet(e *ast.IndexSetExpr) (interface{}, error) {
+	p.expr(e.Object)
+	p.token(token.LeftBracket)
+	p.expr(e.Index)
+	p.token(token.RightBracket)
+	return p.assign(e.Value)
+}
+
+func (p *printer) VisitBadExpr(e *ast.BadExpr) (interface{}, error) {
+	return nil, fmt.Errorf("%s: can't format an expression that doesn't parse", e.Span())
+}
@@ -0,0 +1,212 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/parser"
+	"github.com/modulitos/glox/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "spaces and indents",
+			source:   "var   a=1+2*-b;\nfun add(a,b){return a+b;}\n  print add( a ,b ) ;",
+			expected: "var a = 1 + 2 * -b;\nfun add(a, b) {\n  return a + b;\n}\nprint add(a, b);\n",
+		},
+		{
+			name: "braces go on the line of their statement",
+			source: `if (a)
+{
+  print 1;
+}
+else
+{
+  print 2;
+}`,
+			expected: "if (a) {\n  print 1;\n} else {\n  print 2;\n}\n",
+		},
+		{
+			name:     "bodies without braces stay on the line",
+			source:   "if (a)\n  print 1;\nelse if (b)\n  print 2;\nwhile (c)\n  c = c - 1;",
+			expected: "if (a) print 1; else if (b) print 2;\nwhile (c) c = c - 1;\n",
+		},
+		{
+			name:     "for loops are printed back as for loops",
+			source:   "for (var i=0;i<3;i=i+1) print i;\nfor(;;){break;}\nfor (i = 0; i < 3;) {}",
+			expected: "for (var i = 0; i < 3; i = i + 1) print i;\nfor (;;) {\n  break;\n}\nfor (i = 0; i < 3;) {}\n",
+		},
+		{
+			name: "classes, lambdas and collections",
+			source: `class B<A{init(x){this.x=x;}
+get(){return super.get()[0];}}
+var f=fun(a){return {"a":a,"b":[1,2.50]};};
+l[0]=f(1)["a"];`,
+			expected: `class B < A {
+  init(x) {
+    this.x = x;
+  }
+  get() {
+    return super.get()[0];
+  }
+}
+var f = fun (a) {
+  return {"a": a, "b": [1, 2.50]};
+};
+l[0] = f(1)["a"];
+`,
+		},
+		{
+			name:     "imports and exceptions",
+			source:   "import {a,b} from \"lib.lox\";\nimport \"other.lox\";\ntry{throw 1;}catch(e){print e;}finally{}",
+			expected: "import { a, b } from \"lib.lox\";\nimport \"other.lox\";\ntry {\n  throw 1;\n} catch (e) {\n  print e;\n} finally {}\n",
+		},
+		{
+			name: "one blank line is kept between statements",
+			source: `
+
+print 1;
+
+
+
+print 2;
+{
+
+  print 3;
+
+}
+`,
+			expected: "print 1;\n\nprint 2;\n{\n  print 3;\n}\n",
+		},
+		{
+			name: "comments on lines of their own",
+			source: `// Adds numbers.
+fun add(a, b) {
+    // The sum.
+    return a + b;
+    // Unreachable.
+}
+
+// The end.
+`,
+			expected: "// Adds numbers.\nfun add(a, b) {\n  // The sum.\n  return a + b;\n  // Unreachable.\n}\n\n// The end.\n",
+		},
+		{
+			name: "comments at the end of lines",
+			source: `var a = 1;   // one
+if (a) { // when a
+  print a;
+} // if
+class A { // nothing
+}`,
+			expected: "var a = 1; // one\nif (a) { // when a\n  print a;\n} // if\nclass A { // nothing\n}\n",
+		},
+		{
+			name: "comments in the middle of a statement break it where they are",
+			source: `var l = [
+  1, // one
+  2
+];
+print add(
+  // first
+  1,
+  // second
+  2);`,
+			expected: "var l = [1, // one\n  2];\nprint add(\n  // first\n  1,\n  // second\n  2);\n",
+		},
+		{
+			name: "comments after a condition and an else stay there",
+			source: `if (x) // cond
+  print 1;
+else // else
+  print 2;
+print f(1, // one
+  2);`,
+			expected: "if (x) // cond\n  print 1;\nelse // else\n  print 2;\nprint f(1, // one\n  2);\n",
+		},
+		{
+			name: "comments after a block in the middle of a line stay after it",
+			source: `f(fun () {
+  a;
+}, // x
+// y
+1);
+class A
+// z
+{ // w
+}`,
+			expected: "f(fun () {\n  a;\n}, // x\n  // y\n  1);\nclass A\n  // z\n  { // w\n  }\n",
+		},
+		{
+			name:     "strings keep their lines",
+			source:   "print \"a\n  b\"; // c",
+			expected: "print \"a\n  b\"; // c\n",
+		},
+		{
+			name:     "only comments",
+			source:   "// a\n\n\n// b  ",
+			expected: "// a\n\n// b\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// When:
+			actual, err := Format("test.lox", []byte(tc.source))
+
+			// Then:
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(actual))
+			assertSameProgram(t, tc.source, string(actual))
+
+			again, err := Format("test.lox", actual)
+			assert.NoError(t, err)
+			assert.Equal(t, string(actual), string(again), "formatting again changed the code")
+		})
+	}
+
+	t.Run("code that doesn't parse isn't formatted", func(t *testing.T) {
+		_, err := Format("test.lox", []byte("print 1"))
+
+		assert.EqualError(t, err, "test.lox:1:8: error[E0100]: Expect ';' after value.")
+	})
+}
+
+// assertSameProgram checks that the formatted code has the same tokens as the
+// source, and so the same syntax tree.
+func assertSameProgram(t *testing.T, source, formatted string) {
+	t.Helper()
+	assert.Equal(t, tokenTexts(t, source), tokenTexts(t, formatted))
+	assert.Equal(t, printTree(t, source), printTree(t, formatted))
+}
+
+func tokenTexts(t *testing.T, source string) []string {
+	s := scanner.NewScanner([]byte(source))
+	tokens, err := s.ScanTokens()
+	assert.NoError(t, err)
+	texts := make([]string, len(tokens))
+	for index, tok := range tokens {
+		texts[index] = tok.TokenType.String() + " " + tok.Lexeme
+	}
+	return texts
+}
+
+func printTree(t *testing.T, source string) string {
+	s := scanner.NewScanner([]byte(source))
+	tokens, err := s.ScanTokens()
+	assert.NoError(t, err)
+	p := parser.Parser{Tokens: tokens}
+	statements, err := p.Parse()
+	assert.NoError(t, err)
+	printer := ast.AstPrint{}
+	tree, err := printer.PrintStmts(statements)
+	assert.NoError(t, err)
+	return strings.TrimSpace(tree)
+}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/token"
+	"github.com/modulitos/glox/pkg/typename"
 )
 
 // ////////////////////////////////////////////////////////////////////////////
@@ -16,6 +18,10 @@ func (f *nativeFuncClock) String() string {
 	return "<native fn>"
 }
 
+func (f *nativeFuncClock) LoxType() string {
+	return typename.Function
+}
+
 func (f *nativeFuncClock) arity() int {
 	return 0
 }
@@ -24,6 +30,50 @@ func (f *nativeFuncClock) call(interpreter *Interpreter, args []interface{}) (re
 	return
 }
 
+// A native function backed by a plain Go function. Errors it returns without a
+// token are reported against the call's closing paren.
+type nativeFunction struct {
+	name       string
+	paramCount int
+	fn         func(interpreter *Interpreter, args []interface{}) (interface{}, error)
+}
+
+func (f *nativeFunction) String() string {
+	return "<native fn>"
+}
+
+func (f *nativeFunction) LoxType() string {
+	return typename.Function
+}
+
+func (f *nativeFunction) arity() int {
+	return f.paramCount
+}
+
+func (f *nativeFunction) call(interpreter *Interpreter, args []interface{}) (result interface{}, err error) {
+	return f.fn(interpreter, args)
+}
+
+// NewNativeFunction wraps a Go function so that it can be called from Lox.
+// Lox checks that it is called with exactly arity arguments.
+func NewNativeFunction(name string, arity int, fn func(args []interface{}) (interface{}, error)) Callable {
+	return &nativeFunction{
+		name:       name,
+		paramCount: arity,
+		fn: func(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+			result, err := fn(args)
+			if err != nil {
+				// Report host errors like any other runtime error, so that
+				// they can be caught and point at the call site.
+				if _, ok := err.(*RuntimeError); !ok {
+					err = &RuntimeError{msg: err.Error()}
+				}
+			}
+			return result, err
+		},
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // Lox Callable Function
 //////////////////////////////////////////////////////////////////////////////
@@ -31,47 +81,164 @@ func (f *nativeFuncClock) call(interpreter *Interpreter, args []interface{}) (re
 // We don’t want the runtime phase of the interpreter to bleed into the front
 // end’s syntax classes so we don’t want ast.FunctionStmt itself to implement that.
 // Instead, we wrap it in a new class.
+//
+// Both named function declarations and anonymous function expressions become
+// a loxFunction, so we only keep the parts of the syntax they have in common.
 type loxFunction struct {
-	declaration *ast.FunctionStmt
+	name   string
+	params []*token.Token
+	body   []ast.Stmt
+	// The environment that was active when the function was declared, not the
+	// one active when it is called. This is what makes closures work.
+	closure *environment
+	// The globals of the module the function was declared in. Unresolved
+	// variables in the body are looked up there, even when the function is
+	// called from another module.
+	globals       *environment
+	isInitializer bool
+	// The body compiled to closures, when closure compilation is enabled.
+	compiled compiledStmt
+}
+
+func newLoxFunction(declaration *ast.FunctionStmt, closure *environment, globals *environment, isInitializer bool) *loxFunction {
+	return &loxFunction{
+		name:          declaration.Name.Lexeme,
+		params:        declaration.Params,
+		body:          declaration.Body,
+		closure:       closure,
+		globals:       globals,
+		isInitializer: isInitializer,
+	}
+}
+
+// Anonymous functions have no name to print, so we name them after where they
+// were written instead.
+func newLoxLambda(expr *ast.LambdaExpr, closure *environment, globals *environment) *loxFunction {
+	return &loxFunction{
+		name:    fmt.Sprintf("anonymous@line %d", expr.Keyword.Line),
+		params:  expr.Params,
+		body:    expr.Body,
+		closure: closure,
+		globals: globals,
+	}
 }
 
 func (f *loxFunction) String() string {
-	return fmt.Sprintf("<fn %s>", f.declaration.Name.Lexeme)
+	return fmt.Sprintf("<fn %s>", f.name)
+}
+
+func (f *loxFunction) LoxType() string {
+	return typename.Function
 }
 
 func (f *loxFunction) arity() int {
-	return len(f.declaration.Params)
+	return len(f.params)
+}
+
+// bind creates a new environment nestled inside the method's original closure,
+// declares `this` as a variable in it, and returns a new function closing over
+// it. The resolver opens a matching scope around every method body.
+func (f *loxFunction) bind(instance *loxInstance) *loxFunction {
+	environment := newEnvironment(f.closure)
+	environment.define("this", instance)
+	return &loxFunction{
+		name:          f.name,
+		params:        f.params,
+		body:          f.body,
+		closure:       environment,
+		globals:       f.globals,
+		isInitializer: f.isInitializer,
+		compiled:      f.compiled,
+	}
+}
+
+// An initializer always returns `this`, which bind put in the closure.
+func (f *loxFunction) boundThis() (interface{}, error) {
+	return f.closure.getAt(0, 0), nil
 }
 
 func (f *loxFunction) call(interpreter *Interpreter, args []interface{}) (result interface{}, err error) {
 	// To support recursion, we create a new environment at each _call_, not at
-	// the function declaration.
-	environment := newEnvironment(interpreter.environment)
-	for i := 0; i < len(f.declaration.Params); i++ {
-		environment.define(f.declaration.Params[i].Lexeme, args[i])
+	// the function declaration. Its parent is the closure, so the body sees
+	// the variables surrounding the declaration rather than the call site.
+	environment := newEnvironment(f.closure)
+	environment.slots = make([]interface{}, 0, len(f.params))
+	for i := 0; i < len(f.params); i++ {
+		environment.define(f.params[i].Lexeme, args[i])
 	}
 
+	previousGlobals := interpreter.globals
+	interpreter.globals = f.globals
 	defer func() {
-		//  Inside a heavily recursive tree-walk interpreter, using exceptions
-		//  for flow control is the way to go. Since our own syntax tree
-		//  evaluation is so heavily tied to the call stack, we’re pressed to do
-		//  some heavyweight call stack manipulation occasionally, and
-		//  exceptions are a handy tool for that.
-		panicReason := recover()
-		if panicReason == nil {
-			return
-		}
-		returnValue, ok := panicReason.(*returnPayload)
-		if !ok {
-			panic(panicReason)
-		}
-		result = returnValue.Value
+		interpreter.globals = previousGlobals
 	}()
 
-	err = interpreter.executeBlock(f.declaration.Body, environment)
+	if f.compiled != nil {
+		err = f.compiled(environment)
+	} else {
+		err = interpreter.executeBlock(f.body, environment)
+	}
+	if c, ok := err.(*completion); ok && c.kind == completionReturn {
+		result, err = c.value, nil
+	}
+	if err != nil {
+		return
+	}
+	// An empty `return;` is the only kind the resolver allows inside an
+	// initializer, and it still hands back the instance.
+	if f.isInitializer {
+		return f.boundThis()
+	}
 	return
 }
 
-type returnPayload struct {
-	Value interface{}
+//////////////////////////////////////////////////////////////////////////////
+// Lox Callable Class
+//////////////////////////////////////////////////////////////////////////////
+
+// Calling a class creates a new instance of it, so classes are callables too.
+type loxClass struct {
+	name       string
+	superclass *loxClass
+	methods    map[string]*loxFunction
+}
+
+func (c *loxClass) String() string {
+	return c.name
+}
+
+func (c *loxClass) LoxType() string {
+	return typename.Class
+}
+
+// Methods are inherited, so if we don't find one on this class we walk up the
+// superclass chain.
+func (c *loxClass) findMethod(name string) *loxFunction {
+	if method, ok := c.methods[name]; ok {
+		return method
+	}
+	if c.superclass != nil {
+		return c.superclass.findMethod(name)
+	}
+	return nil
+}
+
+// If there is an initializer, its arity determines how many arguments the user
+// must pass when calling the class itself.
+func (c *loxClass) arity() int {
+	if initializer := c.findMethod("init"); initializer != nil {
+		return initializer.arity()
+	}
+	return 0
+}
+
+func (c *loxClass) call(interpreter *Interpreter, args []interface{}) (result interface{}, err error) {
+	instance := newLoxInstance(c)
+	if initializer := c.findMethod("init"); initializer != nil {
+		_, err = initializer.bind(instance).call(interpreter, args)
+		if err != nil {
+			return
+		}
+	}
+	return instance, nil
 }
@@ -0,0 +1,720 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// Closure compilation turns each node of a resolved program, once, into a Go
+// closure doing the node's work. Running the program then calls the closures
+// rather than dispatching through Accept at every step: the operator of each
+// binary expression is picked at compile time, constants are captured as
+// they are, and variables are bound to the depth and slot the resolver found
+// for them.
+//
+// The closures take the environment they run in, the frame of the function or
+// block being run, instead of reading it from the interpreter. They create
+// environments at the same points the visitor does, since closures over them
+// and the resolver's slots depend on it.
+type compiledExpr func(env *environment) (interface{}, error)
+
+type compiledStmt func(env *environment) error
+
+// SetClosureCompilation makes Evaluate, and the modules it imports, compile the
+// statements to closures before running them, rather than visiting them.
+func (i *Interpreter) SetClosureCompilation(enabled bool) {
+	i.closures = enabled
+}
+
+// runCompiled runs the top-level statements of a script or module, in the
+// globals of the module, and returns the value of the last one if it is an
+// expression statement.
+func (i *Interpreter) runCompiled(stmts []ast.Stmt) (interface{}, error) {
+	c := closureCompiler{interpreter: i}
+	var last compiledExpr
+	if len(stmts) == 0 {
+		return nil, nil
+	}
+	if exprStmt, ok := stmts[len(stmts)-1].(*ast.ExpressionStmt); ok {
+		last = c.guardExpr(exprStmt, c.expr(exprStmt.Expression))
+		stmts = stmts[:len(stmts)-1]
+	}
+	for _, stmt := range c.stmts(stmts) {
+		err := stmt(i.globals)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	return last(i.globals)
+}
+
+type closureCompiler struct {
+	interpreter *Interpreter
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// stmts compiles a list of statements, each of which reports a Go panic as an
+// internal error pointing at itself, like execute does.
+func (c *closureCompiler) stmts(stmts []ast.Stmt) []compiledStmt {
+	compiled := make([]compiledStmt, len(stmts))
+	for index, stmt := range stmts {
+		compiled[index] = c.guardStmt(stmt, c.stmt(stmt))
+	}
+	return compiled
+}
+
+func (c *closureCompiler) guardStmt(stmt ast.Stmt, run compiledStmt) compiledStmt {
+	i := c.interpreter
+	return func(env *environment) (err error) {
+		defer i.recoverInternalError(stmt, &err)
+		return run(env)
+	}
+}
+
+func (c *closureCompiler) guardExpr(stmt ast.Stmt, run compiledExpr) compiledExpr {
+	i := c.interpreter
+	return func(env *environment) (result interface{}, err error) {
+		defer i.recoverInternalError(stmt, &err)
+		return run(env)
+	}
+}
+
+// block compiles statements that run in a new environment, enclosed by the one
+// the block runs in.
+func (c *closureCompiler) block(stmts []ast.Stmt) compiledStmt {
+	body := c.body(stmts)
+	return func(env *environment) error {
+		return body(newEnvironment(env))
+	}
+}
+
+// body compiles statements that run in the environment they are given, like
+// the body of a function in the environment holding its parameters.
+func (c *closureCompiler) body(stmts []ast.Stmt) compiledStmt {
+	compiled := c.stmts(stmts)
+	return func(env *environment) error {
+		for _, stmt := range compiled {
+			err := stmt(env)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (c *closureCompiler) stmt(stmt ast.Stmt) compiledStmt {
+	i := c.interpreter
+	switch s := stmt.(type) {
+	case *ast.ExpressionStmt:
+		expr := c.expr(s.Expression)
+		return func(env *environment) error {
+			_, err := expr(env)
+			return err
+		}
+	case *ast.PrintStmt:
+		expr := c.expr(s.Expression)
+		return func(env *environment) error {
+			value, err := expr(env)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(i.writer, i.stringify(value))
+			return nil
+		}
+	case *ast.VarStmt:
+		name := s.Name.Lexeme
+		if s.Initializer == nil {
+			return func(env *environment) error {
+				env.define(name, nil)
+				return nil
+			}
+		}
+		initializer := c.expr(s.Initializer)
+		return func(env *environment) error {
+			value, err := initializer(env)
+			env.define(name, value)
+			return err
+		}
+	case *ast.BlockStmt:
+		return c.block(s.Statements)
+	case *ast.IfStmt:
+		return c.ifStmt(s)
+	case *ast.WhileStmt:
+		return c.while(s)
+	case *ast.BreakStmt:
+		return func(env *environment) error {
+			return errBreak
+		}
+	case *ast.ContinueStmt:
+		return func(env *environment) error {
+			return errContinue
+		}
+	case *ast.ReturnStmt:
+		if s.Value == nil {
+			return func(env *environment) error {
+				return &completion{kind: completionReturn}
+			}
+		}
+		value := c.expr(s.Value)
+		return func(env *environment) error {
+			result, err := value(env)
+			if err != nil {
+				return err
+			}
+			return &completion{kind: completionReturn, value: result}
+		}
+	case *ast.ThrowStmt:
+		value := c.expr(s.Value)
+		return func(env *environment) error {
+			result, err := value(env)
+			if err != nil {
+				return err
+			}
+			return &thrownError{value: result, keyword: s.Keyword}
+		}
+	case *ast.FunctionStmt:
+		body := c.body(s.Body)
+		return func(env *environment) error {
+			function := newLoxFunction(s, env, i.globals, false)
+			function.compiled = body
+			env.define(s.Name.Lexeme, function)
+			return nil
+		}
+	case *ast.ClassStmt:
+		return c.class(s)
+	case *ast.TryStmt:
+		return c.try(s)
+	case *ast.ImportStmt:
+		// Imports only run at the top level, where the interpreter's
+		// environment is the one the compiled code runs in.
+		return func(env *environment) error {
+			return i.VisitImport(s)
+		}
+	case *ast.BadStmt:
+		return func(env *environment) error {
+			return i.VisitBadStmt(s)
+		}
+	}
+	panic(fmt.Sprintf("Implementation error: can't compile statement of type %T.", stmt))
+}
+
+func (c *closureCompiler) ifStmt(s *ast.IfStmt) compiledStmt {
+	i := c.interpreter
+	condition := c.expr(s.Condition)
+	thenBranch := c.stmt(s.ThenBranch)
+	if s.ElseBranch == nil {
+		return func(env *environment) error {
+			value, err := condition(env)
+			if err != nil {
+				return err
+			}
+			if i.isTruthy(value) {
+				return thenBranch(env)
+			}
+			return nil
+		}
+	}
+	elseBranch := c.stmt(s.ElseBranch)
+	return func(env *environment) error {
+		value, err := condition(env)
+		if err != nil {
+			return err
+		}
+		if i.isTruthy(value) {
+			return thenBranch(env)
+		}
+		return elseBranch(env)
+	}
+}
+
+func (c *closureCompiler) while(s *ast.WhileStmt) compiledStmt {
+	i := c.interpreter
+	condition := c.expr(s.Condition)
+	body := c.stmt(s.Body)
+	var increment compiledExpr
+	if s.Increment != nil {
+		increment = c.expr(s.Increment)
+	}
+	return func(env *environment) error {
+		for {
+			value, err := condition(env)
+			if err != nil {
+				return err
+			}
+			if !i.isTruthy(value) {
+				return nil
+			}
+			err = body(env)
+			if err == errBreak {
+				return nil
+			} else if err != nil && err != errContinue {
+				return err
+			}
+			if increment != nil {
+				_, err = increment(env)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (c *closureCompiler) class(s *ast.ClassStmt) compiledStmt {
+	i := c.interpreter
+	var superclassExpr compiledExpr
+	if s.Superclass != nil {
+		superclassExpr = c.expr(s.Superclass)
+	}
+	bodies := make([]compiledStmt, len(s.Methods))
+	for index, method := range s.Methods {
+		bodies[index] = c.body(method.Body)
+	}
+
+	return func(env *environment) error {
+		var superclass *loxClass
+		if superclassExpr != nil {
+			value, err := superclassExpr(env)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			superclass, ok = value.(*loxClass)
+			if !ok {
+				return &RuntimeError{
+					msg:   "Superclass must be a class.",
+					token: s.Superclass.Name,
+				}
+			}
+		}
+
+		closure := env
+		if superclass != nil {
+			closure = newEnvironment(closure)
+			closure.define("super", superclass)
+		}
+		methods := make(map[string]*loxFunction, len(s.Methods))
+		for index, method := range s.Methods {
+			function := newLoxFunction(method, closure, i.globals, method.Name.Lexeme == "init")
+			function.compiled = bodies[index]
+			methods[method.Name.Lexeme] = function
+		}
+		env.define(s.Name.Lexeme, &loxClass{
+			name:       s.Name.Lexeme,
+			superclass: superclass,
+			methods:    methods,
+		})
+		return nil
+	}
+}
+
+func (c *closureCompiler) try(s *ast.TryStmt) compiledStmt {
+	body := c.block(s.Body)
+	var catchBody, finallyBody compiledStmt
+	if s.CatchName != nil {
+		catchBody = c.body(s.CatchBody)
+	}
+	if s.FinallyBody != nil {
+		finallyBody = c.block(s.FinallyBody)
+	}
+
+	return func(env *environment) error {
+		err := body(env)
+		if err != nil && catchBody != nil {
+			if value, ok := caughtValue(err); ok {
+				environment := newEnvironment(env)
+				environment.define(s.CatchName.Lexeme, value)
+				err = catchBody(environment)
+			}
+		}
+		if finallyBody != nil {
+			// Like in VisitTry, a finally block completing abruptly wins.
+			finallyErr := finallyBody(env)
+			if finallyErr != nil {
+				err = finallyErr
+			}
+		}
+		return err
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+func (c *closureCompiler) expr(expr ast.Expr) compiledExpr {
+	i := c.interpreter
+	switch e := expr.(type) {
+	case *ast.LiteralExpr:
+		value := e.Value
+		return func(env *environment) (interface{}, error) {
+			return value, nil
+		}
+	case *ast.GroupingExpr:
+		return c.expr(e.Expression)
+	case *ast.UnaryExpr:
+		return c.unary(e)
+	case *ast.BinaryExpr:
+		return c.binary(e)
+	case *ast.LogicalExpr:
+		return c.logical(e)
+	case *ast.VariableExpr:
+		return c.variable(e, e.Name)
+	case *ast.ThisExpr:
+		return c.variable(e, e.Keyword)
+	case *ast.AssignExpr:
+		return c.assign(e)
+	case *ast.CallExpr:
+		return c.call(e)
+	case *ast.GetExpr:
+		object := c.expr(e.Object)
+		return func(env *environment) (interface{}, error) {
+			value, err := object(env)
+			if err != nil {
+				return nil, err
+			}
+			return getProperty(value, e.Name)
+		}
+	case *ast.SetExpr:
+		return c.set(e)
+	case *ast.SuperExpr:
+		depth := i.locals[e].depth
+		return func(env *environment) (interface{}, error) {
+			return superMethod(env, depth, e.Method)
+		}
+	case *ast.LambdaExpr:
+		body := c.body(e.Body)
+		return func(env *environment) (interface{}, error) {
+			function := newLoxLambda(e, env, i.globals)
+			function.compiled = body
+			return function, nil
+		}
+	case *ast.ListExpr:
+		elements := c.exprs(e.Elements)
+		return func(env *environment) (interface{}, error) {
+			values, err := evaluateAll(env, elements)
+			if err != nil {
+				return nil, err
+			}
+			return newLoxList(values), nil
+		}
+	case *ast.MapExpr:
+		return c.mapExpr(e)
+	case *ast.IndexExpr:
+		object := c.expr(e.Object)
+		index := c.expr(e.Index)
+		return func(env *environment) (interface{}, error) {
+			collection, err := object(env)
+			if err != nil {
+				return nil, err
+			}
+			key, err := index(env)
+			if err != nil {
+				return nil, err
+			}
+			return getIndex(collection, key, e.Bracket)
+		}
+	case *ast.IndexSetExpr:
+		return c.indexSet(e)
+	case *ast.BadExpr:
+		return func(env *environment) (interface{}, error) {
+			return i.VisitBadExpr(e)
+		}
+	}
+	panic(fmt.Sprintf("Implementation error: can't compile expression of type %T.", expr))
+}
+
+func (c *closureCompiler) exprs(exprs []ast.Expr) []compiledExpr {
+	compiled := make([]compiledExpr, len(exprs))
+	for index, expr := range exprs {
+		compiled[index] = c.expr(expr)
+	}
+	return compiled
+}
+
+func evaluateAll(env *environment, exprs []compiledExpr) ([]interface{}, error) {
+	values := make([]interface{}, len(exprs))
+	for index, expr := range exprs {
+		value, err := expr(env)
+		if err != nil {
+			return nil, err
+		}
+		values[index] = value
+	}
+	return values, nil
+}
+
+// variable binds a variable to where the resolver found it: a slot of the
+// environment at its depth, or a global looked up by name.
+func (c *closureCompiler) variable(expr ast.Expr, name *token.Token) compiledExpr {
+	i := c.interpreter
+	local, ok := i.locals[expr]
+	switch {
+	case !ok:
+		return func(env *environment) (interface{}, error) {
+			return i.globals.get(name)
+		}
+	case local.depth == 0:
+		index := local.index
+		return func(env *environment) (interface{}, error) {
+			return env.slots[index], nil
+		}
+	}
+	return func(env *environment) (interface{}, error) {
+		return env.getAt(local.depth, local.index), nil
+	}
+}
+
+func (c *closureCompiler) assign(e *ast.AssignExpr) compiledExpr {
+	i := c.interpreter
+	value := c.expr(e.Value)
+	local, ok := i.locals[e]
+	if !ok {
+		return func(env *environment) (interface{}, error) {
+			result, err := value(env)
+			if err != nil {
+				return nil, err
+			}
+			return result, i.globals.assign(e.Name, result)
+		}
+	}
+	return func(env *environment) (interface{}, error) {
+		result, err := value(env)
+		if err != nil {
+			return nil, err
+		}
+		env.assignAt(local.depth, local.index, result)
+		return result, nil
+	}
+}
+
+func (c *closureCompiler) unary(e *ast.UnaryExpr) compiledExpr {
+	i := c.interpreter
+	right := c.expr(e.Right)
+	if e.Operator.TokenType == token.Bang {
+		return func(env *environment) (interface{}, error) {
+			value, err := right(env)
+			if err != nil {
+				return nil, err
+			}
+			return !i.isTruthy(value), nil
+		}
+	}
+	return func(env *environment) (interface{}, error) {
+		value, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		num, ok := value.(float64)
+		if !ok {
+			return nil, &RuntimeError{code: diagnostic.InvalidOperand, token: e.Operator, msg: "Operand must be a number"}
+		}
+		return -num, nil
+	}
+}
+
+// The arithmetic and comparison operators, which only take numbers.
+var numberOperators = map[token.Type]func(a, b float64) interface{}{
+	token.Minus:        func(a, b float64) interface{} { return a - b },
+	token.Star:         func(a, b float64) interface{} { return a * b },
+	token.Greater:      func(a, b float64) interface{} { return a > b },
+	token.GreaterEqual: func(a, b float64) interface{} { return a >= b },
+	token.Less:         func(a, b float64) interface{} { return a < b },
+	token.LessEqual:    func(a, b float64) interface{} { return a <= b },
+}
+
+func (c *closureCompiler) binary(e *ast.BinaryExpr) compiledExpr {
+	i := c.interpreter
+	left := c.expr(e.Left)
+	right := c.expr(e.Right)
+	operands := func(env *environment) (interface{}, interface{}, error) {
+		a, err := left(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := right(env)
+		return a, b, err
+	}
+	numbers := func(env *environment) (float64, float64, error) {
+		a, b, err := operands(env)
+		if err != nil {
+			return 0, 0, err
+		}
+		x, ok := a.(float64)
+		y, ok2 := b.(float64)
+		if !ok || !ok2 {
+			return 0, 0, &RuntimeError{code: diagnostic.InvalidOperand, token: e.Operator, msg: "Operand must be a number"}
+		}
+		return x, y, nil
+	}
+
+	switch e.Operator.TokenType {
+	case token.Plus:
+		return func(env *environment) (interface{}, error) {
+			a, b, err := operands(env)
+			if err != nil {
+				return nil, err
+			}
+			if x, ok := a.(float64); ok {
+				if y, ok := b.(float64); ok {
+					return x + y, nil
+				}
+			}
+			return i.add(e.Operator, a, b)
+		}
+	case token.Slash:
+		return func(env *environment) (interface{}, error) {
+			x, y, err := numbers(env)
+			if err != nil {
+				return nil, err
+			}
+			if y == 0 {
+				if x == 0 {
+					return math.NaN(), nil
+				}
+				return nil, &RuntimeError{msg: "Cannot divide by zero.", token: e.Operator}
+			}
+			return x / y, nil
+		}
+	case token.EqualEqual:
+		return func(env *environment) (interface{}, error) {
+			a, b, err := operands(env)
+			if err != nil {
+				return nil, err
+			}
+			return i.isEqual(a, b), nil
+		}
+	case token.BangEqual:
+		return func(env *environment) (interface{}, error) {
+			a, b, err := operands(env)
+			if err != nil {
+				return nil, err
+			}
+			return !i.isEqual(a, b), nil
+		}
+	}
+	operator, ok := numberOperators[e.Operator.TokenType]
+	if !ok {
+		panic(fmt.Sprintf("Implementation error: unknown binary operator %s.", e.Operator.Lexeme))
+	}
+	return func(env *environment) (interface{}, error) {
+		x, y, err := numbers(env)
+		if err != nil {
+			return nil, err
+		}
+		return operator(x, y), nil
+	}
+}
+
+func (c *closureCompiler) logical(e *ast.LogicalExpr) compiledExpr {
+	i := c.interpreter
+	left := c.expr(e.Left)
+	right := c.expr(e.Right)
+	// `or` returns its left operand when it is truthy, and `and` when it is
+	// falsey.
+	shortCircuit := e.Operator.TokenType == token.Or
+	return func(env *environment) (interface{}, error) {
+		value, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		if i.isTruthy(value) == shortCircuit {
+			return value, nil
+		}
+		return right(env)
+	}
+}
+
+func (c *closureCompiler) call(e *ast.CallExpr) compiledExpr {
+	i := c.interpreter
+	callee := c.expr(e.Callee)
+	args := c.exprs(e.Args)
+	return func(env *environment) (interface{}, error) {
+		function, err := callee(env)
+		if err != nil {
+			return nil, err
+		}
+		values, err := evaluateAll(env, args)
+		if err != nil {
+			return nil, err
+		}
+		return i.callValue(function, values, e.Paren)
+	}
+}
+
+func (c *closureCompiler) set(e *ast.SetExpr) compiledExpr {
+	object := c.expr(e.Object)
+	value := c.expr(e.Value)
+	return func(env *environment) (interface{}, error) {
+		target, err := object(env)
+		if err != nil {
+			return nil, err
+		}
+		instance, ok := target.(*loxInstance)
+		if !ok {
+			return nil, &RuntimeError{
+				msg:   "Only instances have fields.",
+				token: e.Name,
+			}
+		}
+		result, err := value(env)
+		if err != nil {
+			return nil, err
+		}
+		instance.set(e.Name, result)
+		return result, nil
+	}
+}
+
+func (c *closureCompiler) mapExpr(e *ast.MapExpr) compiledExpr {
+	keys := c.exprs(e.Keys)
+	values := c.exprs(e.Values)
+	return func(env *environment) (interface{}, error) {
+		m := newLoxMap()
+		for index := range keys {
+			key, err := keys[index](env)
+			if err != nil {
+				return nil, err
+			}
+			value, err := values[index](env)
+			if err != nil {
+				return nil, err
+			}
+			err = m.set(e.RightBrace, key, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+}
+
+func (c *closureCompiler) indexSet(e *ast.IndexSetExpr) compiledExpr {
+	object := c.expr(e.Object)
+	index := c.expr(e.Index)
+	value := c.expr(e.Value)
+	return func(env *environment) (interface{}, error) {
+		collection, err := object(env)
+		if err != nil {
+			return nil, err
+		}
+		key, err := index(env)
+		if err != nil {
+			return nil, err
+		}
+		result, err := value(env)
+		if err != nil {
+			return nil, err
+		}
+		return result, setIndex(collection, key, result, e.Bracket)
+	}
+}
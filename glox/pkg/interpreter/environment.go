@@ -3,31 +3,67 @@ package interpreter
 import (
 	"fmt"
 
+	"github.com/modulitos/glox/pkg/diagnostic"
 	"github.com/modulitos/glox/pkg/token"
 )
 
+// An environment holds the variables of a scope. Local variables are kept in
+// slots, in the order they are declared, which is the order the resolver
+// numbered them in, so that they are found without hashing their name. Globals
+// are kept by name instead, since the REPL can redefine them, and since code
+// may refer to a global before it is declared.
 type environment struct {
+	slots []interface{}
+	// Only set for the environments of globals and builtins.
 	values map[string]interface{}
+	// The enclosing environment. It is fixed when the environment is created,
+	// since closures rely on the chain staying the way it was at declaration.
 	parent *environment
 }
 
 func newEnvironment(parent *environment) *environment {
 	return &environment{
-		values: make(map[string]interface{}),
 		parent: parent,
 	}
 }
 
+// Each module's globals sit on top of their own environment of builtins, so
+// that the globals environment only holds the names the module declared.
 func newGlobalEnvironment() *environment {
-	env := newEnvironment(nil)
+	return newNamedEnvironment(newBuiltinEnvironment())
+}
+
+func newNamedEnvironment(parent *environment) *environment {
+	return &environment{
+		values: make(map[string]interface{}),
+		parent: parent,
+	}
+}
+
+func newBuiltinEnvironment() *environment {
+	env := newNamedEnvironment(nil)
 	env.define("clock", &nativeFuncClock{})
+	env.define("len", &nativeFunction{name: "len", paramCount: 1, fn: nativeLen})
+	env.define("push", &nativeFunction{name: "push", paramCount: 2, fn: nativePush})
+	env.define("pop", &nativeFunction{name: "pop", paramCount: 1, fn: nativePop})
+	env.define("slice", &nativeFunction{name: "slice", paramCount: 3, fn: nativeSlice})
+	env.define("keys", &nativeFunction{name: "keys", paramCount: 1, fn: nativeKeys})
+	env.define("values", &nativeFunction{name: "values", paramCount: 1, fn: nativeValues})
+	env.define("has", &nativeFunction{name: "has", paramCount: 2, fn: nativeHas})
+	env.define("delete", &nativeFunction{name: "delete", paramCount: 2, fn: nativeDelete})
 
 	return env
 }
 
 // api
 
+// define adds a variable to the environment. The name only matters to globals,
+// since a local variable takes the next slot.
 func (e *environment) define(name string, value interface{}) {
+	if e.values == nil {
+		e.slots = append(e.slots, value)
+		return
+	}
 	// We have made one interesting semantic choice: When we add the key to the
 	// map, we don’t check to see if it’s already present.
 	//
@@ -47,7 +83,11 @@ func (e *environment) assign(name *token.Token, value interface{}) (err error) {
 			return e.parent.assign(name, value)
 		}
 
-		err = fmt.Errorf("Cannot assign undeclared variable: '%s'.", name.Lexeme)
+		err = &RuntimeError{
+			code:  diagnostic.UndefinedVariable,
+			msg:   fmt.Sprintf("Cannot assign undeclared variable: '%s'.", name.Lexeme),
+			token: name,
+		}
 	}
 	return
 }
@@ -64,33 +104,39 @@ func (e *environment) get(name *token.Token) (result interface{}, err error) {
 		// difficult, we'll defer the error to runtime. It's OK to refer to a
 		// variable before it's defined as long as you don't evaluate the
 		// reference.
-		return nil, fmt.Errorf("Undefined variable: %s.\n", name.Lexeme)
+		return nil, &RuntimeError{
+			code:  diagnostic.UndefinedVariable,
+			msg:   fmt.Sprintf("Undefined variable: %s.", name.Lexeme),
+			token: name,
+		}
 	}
 }
 
-func (e *environment) getAt(distance int, name string) (interface{}, error) {
+// ancestor returns the environment distance steps up the chain, where the
+// resolver found the variable.
+func (e *environment) ancestor(distance int) *environment {
 	current := e
 	for i := 0; i < distance; i++ {
 		current = current.parent
 		if current == nil {
-			return nil, fmt.Errorf("non-existed env parent, searching for variable %q, want distance %d, current distance %d", name, distance, i)
+			panic(fmt.Sprintf("Resolver/environment mismatch: no environment at distance %d", distance))
 		}
 	}
-	if value, ok := current.values[name]; ok {
-		return value, nil
-	} else {
-		panic(fmt.Sprintf("Resolver/environment mismatch: unable to find variable %s in environment at distance %d", name, distance))
+	return current
+}
+
+func (e *environment) getAt(distance int, slot int) interface{} {
+	current := e.ancestor(distance)
+	if slot >= len(current.slots) {
+		panic(fmt.Sprintf("Resolver/environment mismatch: unable to find slot %d in environment at distance %d", slot, distance))
 	}
+	return current.slots[slot]
 }
 
-func (e *environment) assignAt(distance int, name string, value interface{}) error {
-	current := e
-	for i := 0; i < distance; i++ {
-		current = current.parent
-		if current == nil {
-			return fmt.Errorf("non-existed env parent, searching for variable %q, want distance %d, current distance %d", name, distance, i)
-		}
+func (e *environment) assignAt(distance int, slot int, value interface{}) {
+	current := e.ancestor(distance)
+	if slot >= len(current.slots) {
+		panic(fmt.Sprintf("Resolver/environment mismatch: unable to find slot %d in environment at distance %d", slot, distance))
 	}
-	current.values[name] = value
-	return nil
+	current.slots[slot] = value
 }
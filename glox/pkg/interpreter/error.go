@@ -3,15 +3,167 @@ package interpreter
 import (
 	"fmt"
 
+	"github.com/modulitos/glox/pkg/diagnostic"
 	"github.com/modulitos/glox/pkg/token"
 )
 
+// RuntimeError is an error raised while running a program. Its code defaults
+// to diagnostic.RuntimeError when left empty.
 type RuntimeError struct {
+	code  diagnostic.Code
 	msg   string
 	token *token.Token
+	// The Lox calls in progress when the error was raised, innermost first.
+	// Errors raised outside of any call have none.
+	trace []string
 }
 
 func (e *RuntimeError) Error() string {
-	// TODO: don't print the token if it's nil?
-	return fmt.Sprintf("Interpreter Runtime Error: %s\nToken: %v\n", e.msg, e.token)
+	return e.Diagnostic().Error()
+}
+
+// Diagnostic reports the error on the token it was raised at, if it has one.
+func (e *RuntimeError) Diagnostic() *diagnostic.Diagnostic {
+	code := e.code
+	if code == "" {
+		code = diagnostic.RuntimeError
+	}
+	var span token.Span
+	if e.token != nil {
+		span = e.token.Span()
+	}
+	return diagnostic.Errorf(code, span, "%s", e.msg).WithStackTrace(e.trace)
+}
+
+// arityError reports a call passing argCount arguments to a callable taking
+// arity of them, against the call's closing paren.
+func arityError(arity, argCount int, paren *token.Token) *RuntimeError {
+	msg := fmt.Sprintf("Expected %d arguments but got %d.", arity, argCount)
+	if arity == 1 {
+		msg = fmt.Sprintf("Expected 1 argument but got %d.", argCount)
+	}
+	return &RuntimeError{code: diagnostic.ArityMismatch, msg: msg, token: paren}
+}
+
+// Statements report how they completed through the usual error returns: nil
+// when they completed normally, a thrownError or a RuntimeError when they
+// threw, and a completion when they returned, broke out of a loop or continued
+// it. A completion unwinds the enclosing statements up to the loop or the call
+// handling it. The resolver only allows break and continue inside a loop of
+// the same function, and calls stop returns, so completions never cross a
+// call.
+type completionKind int
+
+const (
+	completionReturn = completionKind(iota)
+	completionBreak
+	completionContinue
+)
+
+type completion struct {
+	kind completionKind
+	// The value of a return.
+	value interface{}
+}
+
+func (c *completion) Error() string {
+	switch c.kind {
+	case completionBreak:
+		return "'break' outside of a loop."
+	case completionContinue:
+		return "'continue' outside of a loop."
+	}
+	return "'return' outside of a function."
+}
+
+var (
+	errBreak    = &completion{kind: completionBreak}
+	errContinue = &completion{kind: completionContinue}
+)
+
+// An internalError is a Go panic raised while running the program, which is a
+// bug in glox rather than in the program. Lox code can't catch it.
+type internalError struct {
+	reason interface{}
+	span   token.Span
+	trace  []string
+}
+
+func (e *internalError) Error() string {
+	return e.Diagnostic().Error()
+}
+
+func (e *internalError) Diagnostic() *diagnostic.Diagnostic {
+	return diagnostic.Errorf(diagnostic.InternalError, e.span, "Internal error: %v", e.reason).
+		WithNote("this is a bug in glox, not in the script").
+		WithStackTrace(e.trace)
+}
+
+// recoverInternalError turns a panic into an internalError pointing at the
+// node being run, if any, which is returned through err. It has to be
+// deferred.
+func (i *Interpreter) recoverInternalError(node interface{ Span() token.Span }, err *error) {
+	reason := recover()
+	if reason == nil {
+		return
+	}
+	e := &internalError{reason: reason}
+	if node != nil {
+		e.span = node.Span()
+	}
+	// The frames of the calls the panic unwound are still on the stack.
+	if len(i.frames) > 0 {
+		e.trace = i.stackTrace(e.span)
+	}
+	*err = e
+}
+
+// A value thrown by a Lox `throw` statement. Like runtime errors, it unwinds
+// through the usual error returns until a `catch` clause handles it.
+type thrownError struct {
+	value   interface{}
+	keyword *token.Token
+	trace   []string
+}
+
+func (e *thrownError) Error() string {
+	return e.Diagnostic().Error()
+}
+
+// Diagnostic reports a value that no catch clause handled on the throw
+// statement that raised it.
+func (e *thrownError) Diagnostic() *diagnostic.Diagnostic {
+	d := diagnostic.Errorf(diagnostic.UncaughtException, e.keyword.Span(), "Uncaught exception: %v", e.value)
+	return d.WithStackTrace(e.trace)
+}
+
+// Runtime errors raised by the interpreter itself are caught as instances of
+// this class, with `message` and `line` fields.
+var runtimeErrorClass = &loxClass{
+	name:    "RuntimeError",
+	methods: map[string]*loxFunction{},
+}
+
+// caughtValue converts an error unwinding through a `try` block into the value
+// bound by its `catch` clause. Completions and internal errors aren't
+// exceptions, so they report false and keep unwinding.
+func caughtValue(err error) (value interface{}, ok bool) {
+	switch e := err.(type) {
+	case *completion, *internalError:
+		return nil, false
+	case *thrownError:
+		return e.value, true
+	case *RuntimeError:
+		instance := newLoxInstance(runtimeErrorClass)
+		instance.fields["message"] = e.msg
+		instance.fields["line"] = nil
+		if e.token != nil {
+			instance.fields["line"] = float64(e.token.Line)
+		}
+		return instance, true
+	}
+	instance := newLoxInstance(runtimeErrorClass)
+	instance.fields["message"] = err.Error()
+	instance.fields["line"] = nil
+	return instance, true
 }
@@ -0,0 +1,52 @@
+package interpreter
+
+import (
+	"fmt"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/token"
+	"github.com/modulitos/glox/pkg/typename"
+)
+
+// The runtime representation of an instance of a Lox class. Fields are created
+// on first assignment, so each instance carries its own map of them.
+type loxInstance struct {
+	class  *loxClass
+	fields map[string]interface{}
+}
+
+func newLoxInstance(class *loxClass) *loxInstance {
+	return &loxInstance{
+		class:  class,
+		fields: make(map[string]interface{}),
+	}
+}
+
+func (i *loxInstance) String() string {
+	return fmt.Sprintf("%s instance", i.class.name)
+}
+
+func (i *loxInstance) LoxType() string {
+	return typename.Instance
+}
+
+// Fields shadow methods, so we look for a field first. Methods are bound to
+// the instance on access so that `this` keeps working if the method is stored
+// and called later.
+func (i *loxInstance) get(name *token.Token) (interface{}, error) {
+	if value, ok := i.fields[name.Lexeme]; ok {
+		return value, nil
+	}
+	if method := i.class.findMethod(name.Lexeme); method != nil {
+		return method.bind(i), nil
+	}
+	return nil, &RuntimeError{
+		code:  diagnostic.UndefinedProperty,
+		msg:   fmt.Sprintf("Undefined property '%s'.", name.Lexeme),
+		token: name,
+	}
+}
+
+func (i *loxInstance) set(name *token.Token, value interface{}) {
+	i.fields[name.Lexeme] = value
+}
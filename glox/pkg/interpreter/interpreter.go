@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
 	"github.com/modulitos/glox/pkg/token"
+	"github.com/modulitos/glox/pkg/typename"
 )
 
 // ----------------------------------------------------------------------------
@@ -17,7 +21,57 @@ type Interpreter struct {
 	writer      io.Writer
 	environment *environment // should this be a pointer?
 	globals     *environment
-	locals      map[ast.Expr]int
+	// Where the resolver found each local variable, keyed by the expression
+	// using it. Expressions using a global have no entry.
+	locals map[ast.Expr]slot
+	// The path of the script or module being executed, which imports are
+	// resolved relative to. Empty when running code from the prompt.
+	scriptPath string
+	modules    *moduleRegistry
+	// Reports the warnings found in imported modules, see SetWarningHandler.
+	warningHandler func(warnings diagnostic.List) error
+	// The Lox functions being called, outermost first.
+	frames []frame
+	// Whether to compile statements to closures, see SetClosureCompilation.
+	closures bool
+}
+
+// Deep enough for any reasonable recursion, while staying far below the Go
+// stack limit, whose overflow would crash the whole process.
+const maxCallDepth = 10000
+
+// A frame records a call to a Lox function, so that runtime errors can tell
+// how they were reached.
+type frame struct {
+	function string
+	// The closing paren of the call.
+	site *token.Token
+}
+
+// stackTrace describes the calls in progress, innermost first, with lines like
+// "at fib (script.lox:12:5)". at is where the innermost one is running.
+func (i *Interpreter) stackTrace(at token.Span) []string {
+	trace := make([]string, 0, len(i.frames)+1)
+	for index := len(i.frames) - 1; index >= 0; index-- {
+		trace = append(trace, fmt.Sprintf("at %s (%s)", i.frames[index].function, at))
+		at = i.frames[index].site.Span()
+	}
+	return append(trace, fmt.Sprintf("at <script> (%s)", at))
+}
+
+// traceError attaches the stack trace to an error unwinding out of the
+// innermost call, while its frame is still on the stack.
+func (i *Interpreter) traceError(err error) {
+	switch e := err.(type) {
+	case *RuntimeError:
+		if e.trace == nil && e.token != nil {
+			e.trace = i.stackTrace(e.token.Span())
+		}
+	case *thrownError:
+		if e.trace == nil {
+			e.trace = i.stackTrace(e.keyword.Span())
+		}
+	}
 }
 
 func NewInterpreter(writer io.Writer) *Interpreter {
@@ -26,23 +80,109 @@ func NewInterpreter(writer io.Writer) *Interpreter {
 		writer: writer,
 		// Pointer to the current env, which can change as we traverse blocks:
 		environment: globals,
-		// Pointer to the global env:
+		// Pointer to the global env of the module being executed:
 		globals: globals,
-		locals:  make(map[ast.Expr]int),
+		locals:  make(map[ast.Expr]slot),
+		modules: newModuleRegistry(),
 	}
 }
 
 func (i *Interpreter) Interpret(stmts []ast.Stmt) error {
+	_, err := i.Evaluate(stmts)
+	return err
+}
 
-	for _, stmt := range stmts {
-		err := i.execute(stmt)
+// Evaluate executes the statements like Interpret does, and returns the value
+// of the last statement if it is an expression statement, or nil otherwise.
+func (i *Interpreter) Evaluate(stmts []ast.Stmt) (result interface{}, err error) {
+	if i.closures {
+		result, err = i.runCompiled(stmts)
+		if isTopLevelReturn(err) {
+			return nil, nil
+		}
+		return
+	}
+	for index, stmt := range stmts {
+		if exprStmt, ok := stmt.(*ast.ExpressionStmt); ok && index == len(stmts)-1 {
+			result, err = i.evaluateStmt(exprStmt)
+		} else {
+			err = i.execute(stmt)
+		}
+		if isTopLevelReturn(err) {
+			return nil, nil
+		}
 		if err != nil {
-			return &RuntimeError{
-				msg: fmt.Sprintf("Interpreter failed exception: %v\n", err),
-			}
+			return nil, err
 		}
 	}
-	return nil
+	return
+}
+
+// A `return` outside of any function ends the script or module running it
+// early.
+func isTopLevelReturn(err error) bool {
+	c, ok := err.(*completion)
+	return ok && c.kind == completionReturn
+}
+
+// Call calls a Lox callable, such as a function fetched with GetGlobal, from
+// Go.
+func (i *Interpreter) Call(callee interface{}, args []interface{}) (result interface{}, err error) {
+	defer i.recoverInternalError(nil, &err)
+	function, ok := callee.(Callable)
+	if !ok {
+		return nil, &RuntimeError{
+			code: diagnostic.NotCallable,
+			msg:  fmt.Sprintf("Can only call functions and classes. Callee is unexpected type: %s.", typename.Of(callee)),
+		}
+	}
+	if len(args) != function.arity() {
+		return nil, arityError(function.arity(), len(args), nil)
+	}
+	return function.call(i, args)
+}
+
+// DefineGlobal defines, or redefines, a global variable.
+func (i *Interpreter) DefineGlobal(name string, value interface{}) {
+	i.globals.define(name, value)
+}
+
+// GetGlobal looks up a global variable, including the builtin natives.
+func (i *Interpreter) GetGlobal(name string) (value interface{}, ok bool) {
+	for env := i.globals; env != nil; env = env.parent {
+		if value, ok = env.values[name]; ok {
+			return
+		}
+	}
+	return nil, false
+}
+
+// GlobalNames returns the sorted names of the globals the scripts and the host
+// defined, leaving out the builtins.
+func (i *Interpreter) GlobalNames() []string {
+	names := make([]string, 0, len(i.globals.values))
+	for name := range i.globals.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuiltinNames returns the sorted names of the builtin natives.
+func (i *Interpreter) BuiltinNames() []string {
+	builtins := i.globals.parent
+	names := make([]string, 0, len(builtins.values))
+	for name := range builtins.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Inspect formats a value the way lists print their elements, with strings
+// quoted, so that it reads as the Lox literal it would be written as.
+func (i *Interpreter) Inspect(value interface{}) string {
+	return i.stringifyElement(value)
 }
 
 // ----------------------------------------------------------------------------
@@ -90,15 +230,48 @@ func (i *Interpreter) isEqual(a interface{}, b interface{}) bool {
 			return false
 		}
 		return ta == tb
+	case Callable, *loxInstance, *loxList, *loxMap:
+		// Functions, classes, instances and collections are only equal to
+		// themselves. Comparing collections by identity keeps equality cheap,
+		// and well-defined for collections that contain themselves.
+		return a == b
+	}
+	panic("Implementation error: Interpreter.isEqual encountered a type that is not a string, float, bool, callable, instance, list or map.")
+}
+
+// add implements +. Many languages define + such that if either operand is a
+// string, the other is converted to a string and the results are then
+// concatenated.
+func (i *Interpreter) add(operator *token.Token, left, right interface{}) (interface{}, error) {
+	switch tl := left.(type) {
+	case float64:
+		switch tr := right.(type) {
+		case float64:
+			return tl + tr, nil
+		case string:
+			return fmt.Sprintf("%s%s", i.stringify(left), right), nil
+		}
+	case string:
+		switch tr := right.(type) {
+		case float64:
+			return fmt.Sprintf("%s%s", left, i.stringify(right)), nil
+		case string:
+			return tl + tr, nil
+		}
+	}
+
+	return nil, &RuntimeError{
+		code:  diagnostic.InvalidOperand,
+		msg:   fmt.Sprintf("operands must be both numbers, both strings, or at least one number and a string. Got %s and %s.", typename.Of(left), typename.Of(right)),
+		token: operator,
 	}
-	panic("Implementation error: Interpreter.isEqual encountered a type that is not a string, float, or bool.")
 }
 
 func (i *Interpreter) checkNumberOperand(operator *token.Token, operand interface{}) (num *float64, err error) {
 	if num, ok := operand.(float64); ok {
 		return &num, nil
 	} else {
-		err = &RuntimeError{token: operator, msg: "Operand must be a number"}
+		err = &RuntimeError{code: diagnostic.InvalidOperand, token: operator, msg: "Operand must be a number"}
 		return nil, err
 	}
 }
@@ -107,25 +280,87 @@ func (i *Interpreter) checkNumberOperand(operator *token.Token, operand interfac
 // membrane between the user’s view of Lox objects and their internal
 // representation in Java
 func (i *Interpreter) stringify(val interface{}) string {
+	return i.stringifyValue(val, false, map[interface{}]bool{})
+}
+
+// Strings inside collections are quoted, so that ["a, b"] and ["a", "b"] print
+// differently.
+func (i *Interpreter) stringifyElement(val interface{}) string {
+	return i.stringifyValue(val, true, map[interface{}]bool{})
+}
+
+// stringifyValue keeps track of the collections being printed, so that a list
+// or a map that contains itself prints as [...] or {...} there rather than
+// forever.
+func (i *Interpreter) stringifyValue(val interface{}, quote bool, printing map[interface{}]bool) string {
 	if val == nil {
 		return "nil"
 	}
 
+	if strVal, ok := val.(string); ok && quote {
+		return strconv.Quote(strVal)
+	}
+
 	if numVal, ok := val.(float64); ok {
 		return strconv.FormatFloat(numVal, 'f', -1, 64)
 	}
 
-	return fmt.Sprintf("%s", val)
+	if listVal, ok := val.(*loxList); ok {
+		if printing[listVal] {
+			return "[...]"
+		}
+		printing[listVal] = true
+		defer delete(printing, listVal)
+		builder := strings.Builder{}
+		builder.WriteString("[")
+		for index, element := range listVal.elements {
+			if index > 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteString(i.stringifyValue(element, true, printing))
+		}
+		builder.WriteString("]")
+		return builder.String()
+	}
+
+	if mapVal, ok := val.(*loxMap); ok {
+		if printing[mapVal] {
+			return "{...}"
+		}
+		printing[mapVal] = true
+		defer delete(printing, mapVal)
+		builder := strings.Builder{}
+		builder.WriteString("{")
+		for index, key := range mapVal.keys {
+			if index > 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteString(i.stringifyValue(key, true, printing))
+			builder.WriteString(": ")
+			builder.WriteString(i.stringifyValue(mapVal.values[index], true, printing))
+		}
+		builder.WriteString("}")
+		return builder.String()
+	}
+
+	return fmt.Sprintf("%v", val)
+
+}
 
+// A slot locates a local variable: depth environments up from the current one,
+// at index among the variables of that environment.
+type slot struct {
+	depth int
+	index int
 }
 
-func (i *Interpreter) resolve(expr ast.Expr, depth int) {
-	i.locals[expr] = depth
+func (i *Interpreter) resolve(expr ast.Expr, depth int, index int) {
+	i.locals[expr] = slot{depth: depth, index: index}
 }
 
 func (i *Interpreter) lookupVariable(name *token.Token, expr ast.Expr) (interface{}, error) {
-	if distance, ok := i.locals[expr]; ok {
-		return i.environment.getAt(distance, name.Lexeme)
+	if local, ok := i.locals[expr]; ok {
+		return i.environment.getAt(local.depth, local.index), nil
 	} else {
 		return i.globals.get(name)
 	}
@@ -135,10 +370,20 @@ func (i *Interpreter) lookupVariable(name *token.Token, expr ast.Expr) (interfac
 // ----------------------------------------------------------------------------
 // Interpreter visitor
 
-func (i *Interpreter) execute(stmt ast.Stmt) error {
+// execute runs the statement, and reports how it completed. A Go panic is
+// reported as an internal error at the innermost statement it went through.
+func (i *Interpreter) execute(stmt ast.Stmt) (err error) {
+	defer i.recoverInternalError(stmt, &err)
 	return stmt.Accept(i)
 }
 
+// evaluateStmt runs an expression statement like execute does, and returns the
+// value of its expression.
+func (i *Interpreter) evaluateStmt(stmt *ast.ExpressionStmt) (result interface{}, err error) {
+	defer i.recoverInternalError(stmt, &err)
+	return i.evaluate(stmt.Expression)
+}
+
 func (i *Interpreter) evaluate(expr ast.Expr) (result interface{}, err error) {
 	return expr.Accept(i)
 }
@@ -146,9 +391,7 @@ func (i *Interpreter) evaluate(expr ast.Expr) (result interface{}, err error) {
 func (i *Interpreter) executeBlock(stmts []ast.Stmt, env *environment) (err error) {
 	previous := i.environment
 	i.environment = env
-	i.environment.parent = previous
 	defer func() {
-		i.environment.parent = nil
 		i.environment = previous
 	}()
 
@@ -251,34 +494,7 @@ func (i *Interpreter) VisitBinary(expr *ast.BinaryExpr) (result interface{}, err
 		result = (*leftNum) * (*rightNum)
 		return
 	case token.Plus:
-		// Many languages define + such that if either operand is a string, the
-		// other is converted to a string and the results are then concatenated.
-		switch tl := left.(type) {
-		case float64:
-			switch tr := right.(type) {
-			case float64:
-				result = tl + tr
-				return
-			case string:
-				result = fmt.Sprintf("%s%s", i.stringify(left), right)
-				return
-			}
-		case string:
-			switch tr := right.(type) {
-			case float64:
-				result = fmt.Sprintf("%s%s", left, i.stringify(right))
-				return
-			case string:
-				result = tl + tr
-				return
-			}
-		}
-
-		err = &RuntimeError{
-			msg:   fmt.Sprintf("operands must be both numbers, both strings, or at least one number and a string. Got %v(%T) and %v(%T)", left, left, right, right),
-			token: expr.Operator,
-		}
-		return
+		return i.add(expr.Operator, left, right)
 	case token.Greater:
 		var leftNum *float64
 		var rightNum *float64
@@ -361,33 +577,243 @@ func (i *Interpreter) VisitCall(expr *ast.CallExpr) (result interface{}, err err
 		}
 		args = append(args, arg)
 	}
+	return i.callValue(callee, args, expr.Paren)
+}
+
+// callValue calls the callee from the call whose closing paren is given, which
+// runtime errors point at.
+func (i *Interpreter) callValue(callee interface{}, args []interface{}, paren *token.Token) (result interface{}, err error) {
 	function, ok := callee.(Callable)
 	if !ok {
 		err = &RuntimeError{
-			msg:   fmt.Sprintf("Can only call functions and classes. Callee is unexpected type: %T", callee),
-			token: expr.Paren,
+			code:  diagnostic.NotCallable,
+			msg:   fmt.Sprintf("Can only call functions and classes. Callee is unexpected type: %s.", typename.Of(callee)),
+			token: paren,
 		}
 		return
 	}
 	if len(args) != function.arity() {
+		err = arityError(function.arity(), len(args), paren)
+		return
+	}
+	// Natives don't get a frame, since they have no source of their own to
+	// point into.
+	name, ok := frameName(function)
+	if !ok {
+		return i.callNative(function, args, paren)
+	}
+	if len(i.frames) >= maxCallDepth {
 		err = &RuntimeError{
-			msg:   fmt.Sprintf("Expected %d arguments but got %d.", function.arity(), len(args)),
-			token: expr.Paren,
+			code:  diagnostic.StackOverflow,
+			msg:   "Stack overflow.",
+			token: paren,
 		}
 		return
 	}
+	i.frames = append(i.frames, frame{function: name, site: paren})
 	result, err = function.call(i, args)
+	if err != nil {
+		i.traceError(err)
+	}
+	i.frames = i.frames[:len(i.frames)-1]
+	return
+}
+
+// callNative calls a native function. Natives don't know where they were
+// called from, so we point their errors at the call site, and so do the
+// panics of the Go code behind them, like in the VM.
+func (i *Interpreter) callNative(function Callable, args []interface{}, paren *token.Token) (result interface{}, err error) {
+	defer i.recoverInternalError(paren, &err)
+	result, err = function.call(i, args)
+	if runtimeErr, ok := err.(*RuntimeError); ok && runtimeErr.token == nil {
+		runtimeErr.token = paren
+	}
+	return
+}
+
+// frameName names the frame of a call to a function or class defined in Lox.
+func frameName(callee Callable) (string, bool) {
+	switch c := callee.(type) {
+	case *loxFunction:
+		return c.name, true
+	case *loxClass:
+		return c.name, true
+	}
+	return "", false
+}
+
+func (i *Interpreter) VisitGet(expr *ast.GetExpr) (result interface{}, err error) {
+	object, err := i.evaluate(expr.Object)
 	if err != nil {
 		return
 	}
+	return getProperty(object, expr.Name)
+}
+
+func getProperty(object interface{}, name *token.Token) (interface{}, error) {
+	instance, ok := object.(*loxInstance)
+	if !ok {
+		return nil, &RuntimeError{
+			msg:   "Only instances have properties.",
+			token: name,
+		}
+	}
+	return instance.get(name)
+}
+
+func (i *Interpreter) VisitSet(expr *ast.SetExpr) (result interface{}, err error) {
+	object, err := i.evaluate(expr.Object)
+	if err != nil {
+		return
+	}
+	instance, ok := object.(*loxInstance)
+	if !ok {
+		err = &RuntimeError{
+			msg:   "Only instances have fields.",
+			token: expr.Name,
+		}
+		return
+	}
+	result, err = i.evaluate(expr.Value)
+	if err != nil {
+		return
+	}
+	instance.set(expr.Name, result)
 	return
 }
 
+func (i *Interpreter) VisitThis(expr *ast.ThisExpr) (result interface{}, err error) {
+	return i.lookupVariable(expr.Keyword, expr)
+}
+
+// The resolver put `super` in the scope right outside the one holding `this`,
+// so we find the instance one environment closer than the superclass. Both are
+// alone in their scope.
+func (i *Interpreter) VisitSuper(expr *ast.SuperExpr) (result interface{}, err error) {
+	return superMethod(i.environment, i.locals[expr].depth, expr.Method)
+}
+
+func superMethod(env *environment, depth int, name *token.Token) (interface{}, error) {
+	superclass := env.getAt(depth, 0).(*loxClass)
+	object := env.getAt(depth-1, 0).(*loxInstance)
+
+	method := superclass.findMethod(name.Lexeme)
+	if method == nil {
+		return nil, &RuntimeError{
+			code:  diagnostic.UndefinedProperty,
+			msg:   fmt.Sprintf("Undefined property '%s'.", name.Lexeme),
+			token: name,
+		}
+	}
+	return method.bind(object), nil
+}
+
+func (i *Interpreter) VisitLambda(expr *ast.LambdaExpr) (result interface{}, err error) {
+	return newLoxLambda(expr, i.environment, i.globals), nil
+}
+
+func (i *Interpreter) VisitList(expr *ast.ListExpr) (result interface{}, err error) {
+	elements := make([]interface{}, 0, len(expr.Elements))
+	for _, elementExpr := range expr.Elements {
+		var element interface{}
+		element, err = i.evaluate(elementExpr)
+		if err != nil {
+			return
+		}
+		elements = append(elements, element)
+	}
+	return newLoxList(elements), nil
+}
+
+func (i *Interpreter) VisitMap(expr *ast.MapExpr) (result interface{}, err error) {
+	m := newLoxMap()
+	for index := range expr.Keys {
+		var key, value interface{}
+		key, err = i.evaluate(expr.Keys[index])
+		if err != nil {
+			return
+		}
+		value, err = i.evaluate(expr.Values[index])
+		if err != nil {
+			return
+		}
+		err = m.set(expr.RightBrace, key, value)
+		if err != nil {
+			return
+		}
+	}
+	return m, nil
+}
+
+func (i *Interpreter) VisitIndex(expr *ast.IndexExpr) (result interface{}, err error) {
+	object, err := i.evaluate(expr.Object)
+	if err != nil {
+		return
+	}
+	index, err := i.evaluate(expr.Index)
+	if err != nil {
+		return
+	}
+	return getIndex(object, index, expr.Bracket)
+}
+
+func getIndex(object interface{}, index interface{}, bracket *token.Token) (interface{}, error) {
+	switch collection := object.(type) {
+	case *loxList:
+		return collection.get(bracket, index)
+	case *loxMap:
+		return collection.get(bracket, index)
+	}
+	return nil, &RuntimeError{
+		msg:   fmt.Sprintf("Only lists and maps can be indexed, got %s.", typename.Of(object)),
+		token: bracket,
+	}
+}
+
+func (i *Interpreter) VisitIndexSet(expr *ast.IndexSetExpr) (result interface{}, err error) {
+	object, err := i.evaluate(expr.Object)
+	if err != nil {
+		return
+	}
+	index, err := i.evaluate(expr.Index)
+	if err != nil {
+		return
+	}
+	result, err = i.evaluate(expr.Value)
+	if err != nil {
+		return
+	}
+	return result, setIndex(object, index, result, expr.Bracket)
+}
+
+func setIndex(object interface{}, index interface{}, value interface{}, bracket *token.Token) error {
+	switch collection := object.(type) {
+	case *loxList:
+		return collection.set(bracket, index, value)
+	case *loxMap:
+		return collection.set(bracket, index, value)
+	}
+	return &RuntimeError{
+		msg:   fmt.Sprintf("Only lists and maps can be indexed, got %s.", typename.Of(object)),
+		token: bracket,
+	}
+}
+
+// The parser only leaves bad nodes in trees it reported errors for, which
+// aren't meant to be run.
+func (i *Interpreter) VisitBadExpr(expr *ast.BadExpr) (interface{}, error) {
+	return nil, &RuntimeError{msg: "Can't run code with syntax errors.", token: expr.From}
+}
+
+func (i *Interpreter) VisitBadStmt(stmt *ast.BadStmt) error {
+	return &RuntimeError{msg: "Can't run code with syntax errors.", token: stmt.From}
+}
+
 func (i *Interpreter) VisitExpression(stmt *ast.ExpressionStmt) error {
 	// Appropriately enough, we discard the value returned by i.evaluate() by
 	// placing that call inside a Golang expression statement.
-	i.evaluate(stmt.Expression)
-	return nil
+	_, err := i.evaluate(stmt.Expression)
+	return err
 }
 
 func (i *Interpreter) VisitPrint(stmt *ast.PrintStmt) error {
@@ -421,13 +847,13 @@ func (i *Interpreter) VisitAssign(e *ast.AssignExpr) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	if distance, ok := i.locals[e]; ok {
-		err := i.environment.assignAt(distance, e.Name.Lexeme, result)
+	if local, ok := i.locals[e]; ok {
+		i.environment.assignAt(local.depth, local.index, result)
+	} else {
+		err := i.globals.assign(e.Name, result)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		i.globals.values[e.Name.Lexeme] = result
 	}
 	return result, nil
 }
@@ -479,26 +905,79 @@ func (i *Interpreter) VisitWhile(stmt *ast.WhileStmt) (err error) {
 		if err != nil {
 			return
 		}
-		if i.isTruthy(cond) {
-			err = i.execute(stmt.Body)
+		if !i.isTruthy(cond) {
+			break
+		}
+
+		err = i.execute(stmt.Body)
+		if err == errBreak {
+			return nil
+		} else if err != nil && err != errContinue {
+			return
+		}
+
+		if stmt.Increment != nil {
+			_, err = i.evaluate(stmt.Increment)
 			if err != nil {
 				return
 			}
-		} else {
-			break
 		}
 	}
 	return
 }
 
+func (i *Interpreter) VisitBreak(stmt *ast.BreakStmt) error {
+	return errBreak
+}
+
+func (i *Interpreter) VisitContinue(stmt *ast.ContinueStmt) error {
+	return errContinue
+}
+
 func (i *Interpreter) VisitFunction(stmt *ast.FunctionStmt) (err error) {
-	function := &loxFunction{
-		declaration: stmt,
-	}
+	function := newLoxFunction(stmt, i.environment, i.globals, false)
 	i.environment.define(stmt.Name.Lexeme, function)
 	return nil
 }
 
+func (i *Interpreter) VisitClass(stmt *ast.ClassStmt) (err error) {
+	var superclass *loxClass
+	if stmt.Superclass != nil {
+		var value interface{}
+		value, err = i.evaluate(stmt.Superclass)
+		if err != nil {
+			return
+		}
+		var ok bool
+		superclass, ok = value.(*loxClass)
+		if !ok {
+			return &RuntimeError{
+				msg:   "Superclass must be a class.",
+				token: stmt.Superclass.Name,
+			}
+		}
+	}
+
+	// Methods of a subclass close over an extra environment holding `super`,
+	// matching the scope the resolver opens around them.
+	closure := i.environment
+	if superclass != nil {
+		closure = newEnvironment(closure)
+		closure.define("super", superclass)
+	}
+
+	methods := make(map[string]*loxFunction, len(stmt.Methods))
+	for _, method := range stmt.Methods {
+		methods[method.Name.Lexeme] = newLoxFunction(method, closure, i.globals, method.Name.Lexeme == "init")
+	}
+	i.environment.define(stmt.Name.Lexeme, &loxClass{
+		name:       stmt.Name.Lexeme,
+		superclass: superclass,
+		methods:    methods,
+	})
+	return nil
+}
+
 func (i *Interpreter) VisitReturn(stmt *ast.ReturnStmt) (err error) {
 	var value interface{}
 	if stmt.Value != nil {
@@ -507,8 +986,65 @@ func (i *Interpreter) VisitReturn(stmt *ast.ReturnStmt) (err error) {
 			return
 		}
 	}
-	// unwind the stack:
-	panic(&returnPayload{
-		Value: value,
-	})
+	return &completion{kind: completionReturn, value: value}
+}
+
+func (i *Interpreter) VisitThrow(stmt *ast.ThrowStmt) (err error) {
+	value, err := i.evaluate(stmt.Value)
+	if err != nil {
+		return
+	}
+	return &thrownError{
+		value:   value,
+		keyword: stmt.Keyword,
+	}
+}
+
+func (i *Interpreter) VisitTry(stmt *ast.TryStmt) (err error) {
+	err = i.executeBlock(stmt.Body, newEnvironment(i.environment))
+	if err != nil && stmt.CatchName != nil {
+		if value, ok := caughtValue(err); ok {
+			environment := newEnvironment(i.environment)
+			environment.define(stmt.CatchName.Lexeme, value)
+			err = i.executeBlock(stmt.CatchBody, environment)
+		}
+	}
+	if stmt.FinallyBody != nil {
+		// The finally block runs however the try block and the catch clause
+		// completed, including by a return, break or continue. If it
+		// completes abruptly itself, that wins over whatever was unwinding
+		// before.
+		finallyErr := i.executeBlock(stmt.FinallyBody, newEnvironment(i.environment))
+		if finallyErr != nil {
+			err = finallyErr
+		}
+	}
+	return
+}
+
+// Imports are only allowed at the top level, so the imported names always
+// become globals of the importing module.
+func (i *Interpreter) VisitImport(stmt *ast.ImportStmt) error {
+	module, err := i.loadModule(stmt)
+	if err != nil {
+		return err
+	}
+	if len(stmt.Names) == 0 {
+		for name, value := range module.values {
+			i.environment.define(name, value)
+		}
+		return nil
+	}
+	for _, name := range stmt.Names {
+		value, ok := module.values[name.Lexeme]
+		if !ok {
+			return &RuntimeError{
+				code:  diagnostic.ImportFailed,
+				msg:   fmt.Sprintf("Module %s has no export '%s'.", stmt.Path.Lexeme, name.Lexeme),
+				token: name,
+			}
+		}
+		i.environment.define(name.Lexeme, value)
+	}
+	return nil
 }
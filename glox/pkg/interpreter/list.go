@@ -0,0 +1,137 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/token"
+	"github.com/modulitos/glox/pkg/typename"
+)
+
+// The runtime representation of a Lox list. Lists are passed around by
+// reference, so pushing onto a list is visible through every variable that
+// holds it.
+type loxList struct {
+	elements []interface{}
+}
+
+func newLoxList(elements []interface{}) *loxList {
+	return &loxList{
+		elements: elements,
+	}
+}
+
+func (l *loxList) LoxType() string {
+	return typename.List
+}
+
+// checkIndex converts a Lox value into a position in the list, reporting any
+// problem against the bracket of the index expression.
+func (l *loxList) checkIndex(bracket *token.Token, index interface{}) (int, error) {
+	num, ok := index.(float64)
+	if !ok {
+		return 0, &RuntimeError{
+			msg:   fmt.Sprintf("List index must be a number, got %s.", typename.Of(index)),
+			token: bracket,
+		}
+	}
+	if num != math.Trunc(num) {
+		return 0, &RuntimeError{
+			msg:   fmt.Sprintf("List index must be an integer, got %v.", num),
+			token: bracket,
+		}
+	}
+	if num < 0 {
+		return 0, &RuntimeError{
+			code:  diagnostic.IndexOutOfRange,
+			msg:   fmt.Sprintf("List index must not be negative, got %v.", num),
+			token: bracket,
+		}
+	}
+	if num >= float64(len(l.elements)) {
+		return 0, &RuntimeError{
+			code:  diagnostic.IndexOutOfRange,
+			msg:   fmt.Sprintf("List index %v out of range for list of length %d.", num, len(l.elements)),
+			token: bracket,
+		}
+	}
+	return int(num), nil
+}
+
+func (l *loxList) get(bracket *token.Token, index interface{}) (interface{}, error) {
+	i, err := l.checkIndex(bracket, index)
+	if err != nil {
+		return nil, err
+	}
+	return l.elements[i], nil
+}
+
+func (l *loxList) set(bracket *token.Token, index interface{}, value interface{}) error {
+	i, err := l.checkIndex(bracket, index)
+	if err != nil {
+		return err
+	}
+	l.elements[i] = value
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// List natives
+
+func nativeLen(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	switch value := args[0].(type) {
+	case *loxList:
+		return float64(len(value.elements)), nil
+	case *loxMap:
+		return float64(len(value.keys)), nil
+	case string:
+		return float64(len(value)), nil
+	}
+	return nil, &RuntimeError{msg: fmt.Sprintf("len() expects a list, a map or a string, got %s.", typename.Of(args[0]))}
+}
+
+func nativePush(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	list, ok := args[0].(*loxList)
+	if !ok {
+		return nil, &RuntimeError{msg: fmt.Sprintf("push() expects a list, got %s.", typename.Of(args[0]))}
+	}
+	list.elements = append(list.elements, args[1])
+	return nil, nil
+}
+
+func nativePop(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	list, ok := args[0].(*loxList)
+	if !ok {
+		return nil, &RuntimeError{msg: fmt.Sprintf("pop() expects a list, got %s.", typename.Of(args[0]))}
+	}
+	if len(list.elements) == 0 {
+		return nil, &RuntimeError{msg: "Can't pop from an empty list."}
+	}
+	last := list.elements[len(list.elements)-1]
+	list.elements = list.elements[:len(list.elements)-1]
+	return last, nil
+}
+
+// slice(list, start, end) returns a new list holding the elements from start
+// up to, but not including, end.
+func nativeSlice(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	list, ok := args[0].(*loxList)
+	if !ok {
+		return nil, &RuntimeError{msg: fmt.Sprintf("slice() expects a list, got %s.", typename.Of(args[0]))}
+	}
+	start, startOk := args[1].(float64)
+	end, endOk := args[2].(float64)
+	if !startOk || !endOk || start != math.Trunc(start) || end != math.Trunc(end) {
+		return nil, &RuntimeError{msg: "slice() bounds must be integers."}
+	}
+	if start < 0 || end > float64(len(list.elements)) || start > end {
+		return nil, &RuntimeError{
+			code: diagnostic.IndexOutOfRange,
+			msg:  fmt.Sprintf("slice() bounds [%v, %v) out of range for list of length %d.", start, end, len(list.elements)),
+		}
+	}
+	elements := make([]interface{}, int(end)-int(start))
+	copy(elements, list.elements[int(start):int(end)])
+	return newLoxList(elements), nil
+}
@@ -0,0 +1,155 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/modulitos/glox/pkg/token"
+	"github.com/modulitos/glox/pkg/typename"
+)
+
+// The runtime representation of a Lox map. Like lists, maps are passed around
+// by reference. Entries are kept in insertion order, so iterating over keys()
+// or values() is deterministic.
+type loxMap struct {
+	keys   []interface{}
+	values []interface{}
+	// Maps a normalized key to its position in keys and values.
+	index map[interface{}]int
+}
+
+func newLoxMap() *loxMap {
+	return &loxMap{
+		index: make(map[interface{}]int),
+	}
+}
+
+func (m *loxMap) LoxType() string {
+	return typename.Map
+}
+
+// All NaNs share this key, since Lox considers NaN equal to itself.
+type nanKey struct{}
+
+// normalizeKey turns a Lox value into a Go map key that is equal to another
+// key exactly when Interpreter.isEqual says the two values are equal.
+func (m *loxMap) normalizeKey(bracket *token.Token, key interface{}) (interface{}, error) {
+	switch value := key.(type) {
+	case string:
+		return value, nil
+	case float64:
+		if math.IsNaN(value) {
+			return nanKey{}, nil
+		}
+		// -0 == 0 in Lox, so they have to land on the same entry.
+		if value == 0 {
+			return float64(0), nil
+		}
+		return value, nil
+	}
+	return nil, &RuntimeError{
+		msg:   fmt.Sprintf("Map keys must be strings or numbers, got %s.", typename.Of(key)),
+		token: bracket,
+	}
+}
+
+func (m *loxMap) get(bracket *token.Token, key interface{}) (interface{}, error) {
+	normalized, err := m.normalizeKey(bracket, key)
+	if err != nil {
+		return nil, err
+	}
+	position, ok := m.index[normalized]
+	if !ok {
+		return nil, &RuntimeError{
+			msg:   fmt.Sprintf("Undefined map key %v.", key),
+			token: bracket,
+		}
+	}
+	return m.values[position], nil
+}
+
+func (m *loxMap) set(bracket *token.Token, key interface{}, value interface{}) error {
+	normalized, err := m.normalizeKey(bracket, key)
+	if err != nil {
+		return err
+	}
+	if position, ok := m.index[normalized]; ok {
+		m.values[position] = value
+		return nil
+	}
+	m.index[normalized] = len(m.keys)
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+	return nil
+}
+
+func (m *loxMap) has(key interface{}) (bool, error) {
+	normalized, err := m.normalizeKey(nil, key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := m.index[normalized]
+	return ok, nil
+}
+
+// delete removes the entry for key, if there is one, keeping the remaining
+// entries in insertion order.
+func (m *loxMap) delete(key interface{}) (bool, error) {
+	normalized, err := m.normalizeKey(nil, key)
+	if err != nil {
+		return false, err
+	}
+	position, ok := m.index[normalized]
+	if !ok {
+		return false, nil
+	}
+	delete(m.index, normalized)
+	m.keys = append(m.keys[:position], m.keys[position+1:]...)
+	m.values = append(m.values[:position], m.values[position+1:]...)
+	for normalizedKey, p := range m.index {
+		if p > position {
+			m.index[normalizedKey] = p - 1
+		}
+	}
+	return true, nil
+}
+
+// ----------------------------------------------------------------------------
+// Map natives
+
+func nativeKeys(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	m, ok := args[0].(*loxMap)
+	if !ok {
+		return nil, &RuntimeError{msg: fmt.Sprintf("keys() expects a map, got %s.", typename.Of(args[0]))}
+	}
+	keys := make([]interface{}, len(m.keys))
+	copy(keys, m.keys)
+	return newLoxList(keys), nil
+}
+
+func nativeValues(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	m, ok := args[0].(*loxMap)
+	if !ok {
+		return nil, &RuntimeError{msg: fmt.Sprintf("values() expects a map, got %s.", typename.Of(args[0]))}
+	}
+	values := make([]interface{}, len(m.values))
+	copy(values, m.values)
+	return newLoxList(values), nil
+}
+
+func nativeHas(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	m, ok := args[0].(*loxMap)
+	if !ok {
+		return nil, &RuntimeError{msg: fmt.Sprintf("has() expects a map, got %s.", typename.Of(args[0]))}
+	}
+	return m.has(args[1])
+}
+
+// delete(map, key) reports whether there was an entry to remove.
+func nativeDelete(interpreter *Interpreter, args []interface{}) (interface{}, error) {
+	m, ok := args[0].(*loxMap)
+	if !ok {
+		return nil, &RuntimeError{msg: fmt.Sprintf("delete() expects a map, got %s.", typename.Of(args[0]))}
+	}
+	return m.delete(args[1])
+}
@@ -0,0 +1,188 @@
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/parser"
+	"github.com/modulitos/glox/pkg/scanner"
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// The environment variable holding extra directories to search for imported
+// modules, separated like PATH.
+const modulePathEnv = "GLOX_PATH"
+
+// Every module runs at most once per interpreter. Its globals are cached by
+// canonical path and shared by everyone importing it.
+type moduleRegistry struct {
+	loaded map[string]*environment
+	// Canonical paths of the modules being loaded, outermost first, so that
+	// we can report the whole cycle when a module imports itself.
+	loading []string
+}
+
+func newModuleRegistry() *moduleRegistry {
+	return &moduleRegistry{
+		loaded: make(map[string]*environment),
+	}
+}
+
+// SetWarningHandler sets what to do with the warnings found while resolving
+// an imported module. An error returned by the handler fails the import, which
+// is how warnings are treated as errors. Without a handler, warnings are
+// dropped.
+func (i *Interpreter) SetWarningHandler(handler func(warnings diagnostic.List) error) {
+	i.warningHandler = handler
+}
+
+// SetScriptPath records the file the interpreter is about to run, so that its
+// imports resolve relative to it and importing it back is reported as a cycle.
+// The returned function puts back the script that was running before, and has
+// to be called once the file ran, so that running another file in the same
+// session doesn't see this one as still being loaded.
+func (i *Interpreter) SetScriptPath(path string) (restore func(), err error) {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("Resolving script path: %w", err)
+	}
+	previous := i.scriptPath
+	i.scriptPath = canonical
+	i.modules.loading = append(i.modules.loading, canonical)
+	return func() {
+		i.scriptPath = previous
+		i.modules.loading = i.modules.loading[:len(i.modules.loading)-1]
+	}, nil
+}
+
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// resolveModulePath looks for the imported file relative to the importing
+// file first, and then in each of the GLOX_PATH directories.
+func (i *Interpreter) resolveModulePath(path *token.Token) (string, error) {
+	importPath := path.Literal.(string)
+	var candidates []string
+	if filepath.IsAbs(importPath) {
+		candidates = append(candidates, importPath)
+	} else {
+		dir := "."
+		if i.scriptPath != "" {
+			dir = filepath.Dir(i.scriptPath)
+		}
+		candidates = append(candidates, filepath.Join(dir, importPath))
+		for _, searchDir := range filepath.SplitList(os.Getenv(modulePathEnv)) {
+			if searchDir != "" {
+				candidates = append(candidates, filepath.Join(searchDir, importPath))
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return canonicalPath(candidate)
+		}
+	}
+	return "", &RuntimeError{
+		code:  diagnostic.ImportFailed,
+		msg:   fmt.Sprintf("Module %q not found, searched: %s.", importPath, strings.Join(candidates, ", ")),
+		token: path,
+	}
+}
+
+// loadModule returns the globals of the imported module, running it first if
+// nobody has imported it yet.
+func (i *Interpreter) loadModule(stmt *ast.ImportStmt) (*environment, error) {
+	path, err := i.resolveModulePath(stmt.Path)
+	if err != nil {
+		return nil, err
+	}
+	if globals, ok := i.modules.loaded[path]; ok {
+		return globals, nil
+	}
+	for index, loading := range i.modules.loading {
+		if loading == path {
+			cycle := append(append([]string{}, i.modules.loading[index:]...), path)
+			return nil, &RuntimeError{
+				code:  diagnostic.ImportFailed,
+				msg:   fmt.Sprintf("Import cycle detected: %s.", strings.Join(cycle, " -> ")),
+				token: stmt.Path,
+			}
+		}
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &RuntimeError{
+			code:  diagnostic.ImportFailed,
+			msg:   fmt.Sprintf("Reading module %s: %v", path, err),
+			token: stmt.Path,
+		}
+	}
+	s := scanner.NewFileScanner(path, source)
+	tokens, err := s.ScanTokens()
+	if err != nil {
+		return nil, fmt.Errorf("Scanning module %s: %w", path, err)
+	}
+	p := parser.Parser{Tokens: tokens}
+	statements, err := p.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("Parsing module %s: %w", path, err)
+	}
+	resolver := NewResolver(i)
+	err = resolver.ResolveStmts(statements)
+	if err != nil {
+		return nil, fmt.Errorf("Resolving module %s: %w", path, err)
+	}
+	if warnings := resolver.Warnings(); len(warnings) > 0 && i.warningHandler != nil {
+		err = i.warningHandler(warnings)
+		if err != nil {
+			return nil, fmt.Errorf("Resolving module %s: %w", path, err)
+		}
+	}
+
+	globals := newGlobalEnvironment()
+	previousEnvironment, previousGlobals, previousScriptPath := i.environment, i.globals, i.scriptPath
+	i.environment, i.globals, i.scriptPath = globals, globals, path
+	i.modules.loading = append(i.modules.loading, path)
+	defer func() {
+		i.environment, i.globals, i.scriptPath = previousEnvironment, previousGlobals, previousScriptPath
+		i.modules.loading = i.modules.loading[:len(i.modules.loading)-1]
+	}()
+
+	err = i.executeModule(statements)
+	if err != nil {
+		return nil, fmt.Errorf("Running module %s: %w", path, err)
+	}
+	i.modules.loaded[path] = globals
+	return globals, nil
+}
+
+func (i *Interpreter) executeModule(statements []ast.Stmt) error {
+	if i.closures {
+		_, err := i.runCompiled(statements)
+		if isTopLevelReturn(err) {
+			return nil
+		}
+		return err
+	}
+	for _, stmt := range statements {
+		err := i.execute(stmt)
+		if isTopLevelReturn(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
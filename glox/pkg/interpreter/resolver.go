@@ -1,58 +1,121 @@
 package interpreter
 
 import (
-	"fmt"
+	"strings"
 
 	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
 	"github.com/modulitos/glox/pkg/token"
 )
 
 type Resolver struct {
-	interpreter *Interpreter
-	scopes      scopes
+	interpreter     *Interpreter
+	scopes          scopes
+	currentFunction functionType
+	currentClass    classType
+	// How many loops enclose the statement being resolved, within the current
+	// function.
+	loopDepth int
+	// Resolution goes on after an error, so that every static error of the
+	// program is reported at once.
+	errors   diagnostic.List
+	warnings diagnostic.List
 }
 
 func NewResolver(interpreter *Interpreter) Resolver {
 	return Resolver{
-		interpreter: interpreter,
-		scopes:      make(scopes, 0),
+		interpreter:     interpreter,
+		scopes:          make(scopes, 0),
+		currentFunction: functionTypeNone,
+		currentClass:    classTypeNone,
 	}
 
 }
 
+// Tracks what kind of function body, if any, we are currently resolving, so
+// that we can catch statements that are only valid in some of them.
+type functionType int
+
+const (
+	functionTypeNone = functionType(iota)
+	functionTypeFunction
+	functionTypeInitializer
+	functionTypeMethod
+)
+
+// Tracks whether we are inside a class declaration, so that we can reject
+// `this` anywhere else, and `super` outside of subclasses.
+type classType int
+
+const (
+	classTypeNone = classType(iota)
+	classTypeClass
+	classTypeSubclass
+)
+
+// Tracks how a local variable was declared, since only variables and
+// parameters are reported when they are never used.
+type variableType int
+
+const (
+	variableTypeLocal = variableType(iota)
+	variableTypeParameter
+	// Functions, classes, caught exceptions, `this` and `super`.
+	variableTypeOther
+)
+
 ////////////////////////////////////////////////////////////////////////////////
 // API
 ////////////////////////////////////////////////////////////////////////////////
 
+// ResolveStmts resolves the local variables of a whole program. It goes on
+// past static errors, and returns all of them as a diagnostic.List. Warnings
+// don't make it fail, and are returned by Warnings instead.
 func (r *Resolver) ResolveStmts(stmts []ast.Stmt) error {
-	for _, stmt := range stmts {
-		err := r.resolveStmt(stmt)
-		// aggregate errors?
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	r.resolveStmts(stmts)
+	r.errors.Sort()
+	r.warnings.Sort()
+	return r.errors.Err()
+}
+
+// Warnings returns the warnings found by ResolveStmts, in source order.
+func (r *Resolver) Warnings() diagnostic.List {
+	return r.warnings
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 // scopes
 ////////////////////////////////////////////////////////////////////////////////
 
-type scopes []map[string]bool
+// What the resolver knows about a local variable.
+type variable struct {
+	name *token.Token
+	// Whether its initializer was resolved, so that it can be read.
+	defined bool
+	// Whether it was read. Assigning to a variable doesn't count as using it.
+	used bool
+	kind variableType
+	// Its position among the variables of its scope, which is also its
+	// position in the environment holding it at runtime.
+	slot int
+}
+
+type scope map[string]*variable
 
-func (s *scopes) pop() (map[string]bool, error) {
+type scopes []scope
+
+func (s *scopes) pop() (scope, error) {
 	last := s.peek()
 	(*s) = (*s)[:len(*s)-1]
 	return last, nil
 }
 
-func (s *scopes) push(scope map[string]bool) {
+func (s *scopes) push(scope scope) {
 	// TODO: is the deref necessary?
 	*s = append(*s, scope)
 }
 
-func (s *scopes) peek() map[string]bool {
+func (s *scopes) peek() scope {
 	if s.isEmpty() {
 		panic("called peek() when scopes stack is empty.")
 	}
@@ -67,6 +130,42 @@ func (s *scopes) isEmpty() bool {
 // Resolver private methods
 ////////////////////////////////////////////////////////////////////////////////
 
+// report records the error, if any, so that resolution can go on.
+func (r *Resolver) report(err error) {
+	if err == nil {
+		return
+	}
+	for _, d := range diagnostic.From(err) {
+		r.errors.Add(d)
+	}
+}
+
+func (r *Resolver) warn(d *diagnostic.Diagnostic) {
+	r.warnings.Add(d)
+}
+
+// resolveStmts resolves each statement in turn, even after one of them fails.
+// Statements following a return, break, continue or throw can never run, which
+// is reported once per list.
+func (r *Resolver) resolveStmts(stmts []ast.Stmt) {
+	var jump ast.Stmt
+	warned := false
+	for _, stmt := range stmts {
+		if jump != nil && !warned {
+			r.warn(diagnostic.Warningf(diagnostic.UnreachableCode, stmt.Span(), "Unreachable code.").
+				WithSecondary(jump.Span(), "any code after this is unreachable"))
+			warned = true
+		}
+		r.report(r.resolveStmt(stmt))
+		switch stmt.(type) {
+		case *ast.ReturnStmt, *ast.BreakStmt, *ast.ContinueStmt, *ast.ThrowStmt:
+			if jump == nil {
+				jump = stmt
+			}
+		}
+	}
+}
+
 func (r *Resolver) resolveStmt(stmt ast.Stmt) error {
 	return stmt.Accept(r)
 }
@@ -76,47 +175,84 @@ func (r *Resolver) resolveExpr(expr ast.Expr) error {
 	return err
 }
 
-func (r *Resolver) resolveFunction(f *ast.FunctionStmt) error {
+func (r *Resolver) resolveFunction(params []*token.Token, body []ast.Stmt, kind functionType) {
+	enclosingFunction := r.currentFunction
+	enclosingLoopDepth := r.loopDepth
+	r.currentFunction = kind
+	// A function body starts outside of any loop, even if it's declared
+	// inside one.
+	r.loopDepth = 0
+	defer func() {
+		r.currentFunction = enclosingFunction
+		r.loopDepth = enclosingLoopDepth
+	}()
+
 	r.beginScope()
 	defer r.endScope()
-	for _, param := range f.Params {
-		err := r.declare(param)
-		if err != nil {
-			return err
-		}
+	for _, param := range params {
+		r.declare(param, variableTypeParameter)
 		r.define(param)
 	}
-	err := r.ResolveStmts(f.Body)
-	if err != nil {
-		return err
-	}
-	return nil
+	r.resolveStmts(body)
+}
+
+func (r *Resolver) resolveBlock(stmts []ast.Stmt) {
+	r.beginScope()
+	defer r.endScope()
+	r.resolveStmts(stmts)
 }
 
 func (r *Resolver) beginScope() {
-	// r.scopes = append(r.scopes, make(map[string]bool))
-	r.scopes.push(make(map[string]bool))
+	r.scopes.push(make(scope))
 }
-func (r *Resolver) endScope() error {
-	_, err := r.scopes.pop()
-	return err
+
+// endScope discards the innermost scope, warning about the variables and
+// parameters in it that were never read. Names starting with an underscore
+// are meant to be unused, and are left alone.
+func (r *Resolver) endScope() {
+	scope, _ := r.scopes.pop()
+	for _, v := range scope {
+		if v.used || strings.HasPrefix(v.name.Lexeme, "_") {
+			continue
+		}
+		switch v.kind {
+		case variableTypeLocal:
+			r.warn(diagnostic.Warningf(diagnostic.UnusedVariable, v.name.Span(), "Local variable '%s' is never used.", v.name.Lexeme))
+		case variableTypeParameter:
+			r.warn(diagnostic.Warningf(diagnostic.UnusedParameter, v.name.Span(), "Parameter '%s' is never used.", v.name.Lexeme))
+		}
+	}
 }
 
 // Declaration adds the variable to the innermost scope so that it shadows any
 // outer one and so that we know the variable exists. We mark it as "not ready
-// yet" by binding its name to false in the scope map. The value associated with
-// a key in the scope map represents whether or not we have finished resolving
-// that variable's initializer.
-func (r *Resolver) declare(name *token.Token) error {
+// yet" until we have finished resolving its initializer, see define.
+func (r *Resolver) declare(name *token.Token, kind variableType) {
 	if r.scopes.isEmpty() {
-		return nil
+		return
 	}
 	scope := r.scopes.peek()
-	if ok, _ := scope[name.Lexeme]; ok {
-		return fmt.Errorf("variable %s at line %d already exists in the scope.", name.Lexeme, name.Line)
+	if previous, ok := scope[name.Lexeme]; ok && previous.defined {
+		r.report(diagnostic.Errorf(diagnostic.AlreadyDeclared, name.Span(), "Already a variable named %s in this scope.", name.Lexeme))
+	} else if shadowed := r.enclosingParameter(name.Lexeme); shadowed != nil {
+		r.warn(diagnostic.Warningf(diagnostic.ShadowedParameter, name.Span(), "Declaration of '%s' shadows a parameter.", name.Lexeme).
+			WithSecondary(shadowed.name.Span(), "parameter declared here"))
 	}
 
-	scope[name.Lexeme] = false
+	scope[name.Lexeme] = &variable{name: name, kind: kind, slot: len(scope)}
+}
+
+// enclosingParameter returns the parameter that a declaration in the innermost
+// scope would shadow, if any.
+func (r *Resolver) enclosingParameter(name string) *variable {
+	for i := len(r.scopes) - 2; i >= 0; i-- {
+		if v, ok := r.scopes[i][name]; ok {
+			if v.kind == variableTypeParameter {
+				return v
+			}
+			return nil
+		}
+	}
 	return nil
 }
 
@@ -124,88 +260,147 @@ func (r *Resolver) define(name *token.Token) {
 	if r.scopes.isEmpty() {
 		return
 	}
-	r.scopes.peek()[name.Lexeme] = true
+	r.scopes.peek()[name.Lexeme].defined = true
+}
+
+// defineImplicit defines a variable that isn't declared in the source, like
+// `this`, in the innermost scope.
+func (r *Resolver) defineImplicit(name string) {
+	scope := r.scopes.peek()
+	scope[name] = &variable{
+		name:    &token.Token{Lexeme: name},
+		defined: true,
+		kind:    variableTypeOther,
+		slot:    len(scope),
+	}
 }
 
 // We start at the innermost scope and work outwards, looking in each map for a
 // matching name. If we find the variable, we resolve it, passing in the number
 // of scopes between the current innermost scope and the scope where the
-// variable was found. So, if the variable was found in the current scope, we
-// pass in 0. If it’s in the immediately enclosing scope, 1
+// variable was found, along with its slot in that scope. So, if the variable
+// was found in the current scope, we pass in 0. If it’s in the immediately
+// enclosing scope, 1
 //
 // If we walk through all of the block scopes and never find the variable, we
-// leave it unresolved and assume it’s global.
-func (r *Resolver) resolveLocal(e ast.Expr, name string) {
-	// var i int;
+// leave it unresolved, assume it’s global, and return nil.
+//
+// Without an interpreter, like when the program is compiled for the VM, which
+// resolves variables itself, the resolver only checks the program.
+func (r *Resolver) resolveLocal(e ast.Expr, name string) *variable {
 	for i := len(r.scopes) - 1; i >= 0; i-- {
-		if _, ok := r.scopes[i][name]; ok {
-			r.interpreter.resolve(e, len(r.scopes)-1-i)
-			return
-
+		if v, ok := r.scopes[i][name]; ok {
+			if r.interpreter != nil {
+				r.interpreter.resolve(e, len(r.scopes)-1-i, v.slot)
+			}
+			return v
 		}
 	}
+	return nil
 }
 
 // ----------------------------------------------------------------------------
 // Resolver visitor
 
 func (r *Resolver) VisitBlock(stmt *ast.BlockStmt) (err error) {
-	r.beginScope()
-	// TODO: does an error in a defer statement propagate?
-	defer r.endScope()
-	return r.ResolveStmts(stmt.Statements)
+	r.resolveBlock(stmt.Statements)
+	return nil
 }
 
 func (r *Resolver) VisitVar(stmt *ast.VarStmt) error {
-	err := r.declare(stmt.Name)
-	if err != nil {
-		return err
-	}
+	r.declare(stmt.Name, variableTypeLocal)
+	// The variable is defined even if its initializer fails to resolve, so
+	// that using it later isn't reported too.
+	defer r.define(stmt.Name)
 	if stmt.Initializer != nil {
-		r.resolveExpr(stmt.Initializer)
+		return r.resolveExpr(stmt.Initializer)
 	}
-	r.define(stmt.Name)
 	return nil
 }
 
 func (r *Resolver) VisitVariable(e *ast.VariableExpr) (interface{}, error) {
 	if !r.scopes.isEmpty() {
-		initialized, ok := r.scopes.peek()[e.Name.Lexeme]
-		if ok && !initialized {
-			// If the variable exists in the current scope but its value is false, that
-			// means we have declared it but not yet defined it. We report that error.
-			err := fmt.Errorf("Can't read local variable in its own initializer.")
+		v, ok := r.scopes.peek()[e.Name.Lexeme]
+		if ok && !v.defined {
+			// If the variable exists in the current scope but isn't defined, that
+			// means we have declared it but not yet resolved its initializer. We
+			// report that error.
+			err := diagnostic.Errorf(diagnostic.ReadInOwnInitializer, e.Name.Span(), "Can't read local variable in its own initializer.")
 			return nil, err
 		}
 	}
-	r.resolveLocal(e, e.Name.Lexeme)
+	if v := r.resolveLocal(e, e.Name.Lexeme); v != nil {
+		v.used = true
+	}
 	return nil, nil
 }
 
 func (r *Resolver) VisitAssign(e *ast.AssignExpr) (interface{}, error) {
-	r.resolveExpr(e.Value)
+	err := r.resolveExpr(e.Value)
 	r.resolveLocal(e, e.Name.Lexeme)
-	return nil, nil
+	return nil, err
 }
 
 // Unlike variables, we define the name eagerly, before resolving the function’s
 // body. This lets a function recursively refer to itself inside its own body.
 func (r *Resolver) VisitFunction(stmt *ast.FunctionStmt) error {
-	err := r.declare(stmt.Name)
-	if err != nil {
-		return err
-	}
+	r.declare(stmt.Name, variableTypeOther)
 	r.define(stmt.Name)
-	err = r.resolveFunction(stmt)
-	if err != nil {
-		return err
+	r.resolveFunction(stmt.Params, stmt.Body, functionTypeFunction)
+	return nil
+}
+
+// Before we step in and start resolving the method bodies, we push a new scope
+// and define "this" in it as if it were a variable. Then, when we're done, we
+// discard that surrounding scope.
+func (r *Resolver) VisitClass(stmt *ast.ClassStmt) error {
+	enclosingClass := r.currentClass
+	r.currentClass = classTypeClass
+	defer func() {
+		r.currentClass = enclosingClass
+	}()
+
+	r.declare(stmt.Name, variableTypeOther)
+	r.define(stmt.Name)
+
+	if stmt.Superclass != nil {
+		if stmt.Superclass.Name.Lexeme == stmt.Name.Lexeme {
+			r.report(diagnostic.Errorf(diagnostic.InheritFromSelf, stmt.Superclass.Span(), "A class can't inherit from itself.").
+				WithSecondary(stmt.Name.Span(), "class declared here"))
+		}
+		r.currentClass = classTypeSubclass
+		_, err := r.VisitVariable(stmt.Superclass)
+		r.report(err)
+
+		// Each subclass gets its own scope holding `super`, wrapping the scope
+		// that holds `this`.
+		r.beginScope()
+		defer r.endScope()
+		r.defineImplicit("super")
+	}
+
+	r.beginScope()
+	defer r.endScope()
+	r.defineImplicit("this")
+
+	for _, method := range stmt.Methods {
+		kind := functionTypeMethod
+		if method.Name.Lexeme == "init" {
+			kind = functionTypeInitializer
+		}
+		r.resolveFunction(method.Params, method.Body, kind)
 	}
 	return nil
 }
 
+// Anonymous functions have no name to declare, so we only resolve their body.
+func (r *Resolver) VisitLambda(expr *ast.LambdaExpr) (interface{}, error) {
+	r.resolveFunction(expr.Params, expr.Body, functionTypeFunction)
+	return nil, nil
+}
+
 func (r *Resolver) VisitExpression(stmt *ast.ExpressionStmt) error {
-	r.resolveExpr(stmt.Expression)
-	return nil
+	return r.resolveExpr(stmt.Expression)
 }
 
 func (r *Resolver) VisitIf(stmt *ast.IfStmt) error {
@@ -214,67 +409,172 @@ func (r *Resolver) VisitIf(stmt *ast.IfStmt) error {
 	// the branch that is run, a static analysis is conservative—it analyzes any
 	// branch that could be run. Since either one could be reached at runtime,
 	// we resolve both.
-	err := r.resolveExpr(stmt.Condition)
-	if err != nil {
-		return err
-	}
-	err = r.resolveStmt(stmt.ThenBranch)
-	if err != nil {
-		return err
-	}
+	r.report(r.resolveExpr(stmt.Condition))
+	r.report(r.resolveStmt(stmt.ThenBranch))
 	if stmt.ElseBranch != nil {
-		err = r.resolveStmt(stmt.ElseBranch)
-		if err != nil {
-			return err
-		}
+		r.report(r.resolveStmt(stmt.ElseBranch))
 	}
 	return nil
 }
 
 func (r *Resolver) VisitPrint(stmt *ast.PrintStmt) error {
-	r.resolveExpr(stmt.Expression)
-	return nil
+	return r.resolveExpr(stmt.Expression)
 }
 
+// A return at the top level ends the script early, which is allowed but most
+// likely a mistake.
 func (r *Resolver) VisitReturn(stmt *ast.ReturnStmt) error {
+	if r.currentFunction == functionTypeNone {
+		r.warn(diagnostic.Warningf(diagnostic.TopLevelReturn, stmt.Keyword.Span(), "Return outside of a function ends the script."))
+	}
 	if stmt.Value != nil {
-		err := r.resolveExpr(stmt.Value)
-		if err != nil {
-			return err
+		if r.currentFunction == functionTypeInitializer {
+			r.report(diagnostic.Errorf(diagnostic.ReturnFromInitializer, stmt.Value.Span(), "Can't return a value from an initializer."))
 		}
+		return r.resolveExpr(stmt.Value)
 	}
 	return nil
 }
 
 func (r *Resolver) VisitWhile(stmt *ast.WhileStmt) error {
-	err := r.resolveExpr(stmt.Condition)
-	if err != nil {
-		return err
+	r.report(r.resolveExpr(stmt.Condition))
+
+	r.loopDepth++
+	r.report(r.resolveStmt(stmt.Body))
+	r.loopDepth--
+
+	if stmt.Increment != nil {
+		return r.resolveExpr(stmt.Increment)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitThrow(stmt *ast.ThrowStmt) error {
+	return r.resolveExpr(stmt.Value)
+}
+
+// The catch clause gets a single scope, holding the caught value along with
+// the catch body's own declarations.
+func (r *Resolver) VisitTry(stmt *ast.TryStmt) error {
+	r.resolveBlock(stmt.Body)
+	if stmt.CatchName != nil {
+		r.beginScope()
+		r.declare(stmt.CatchName, variableTypeOther)
+		r.define(stmt.CatchName)
+		r.resolveStmts(stmt.CatchBody)
+		r.endScope()
 	}
-	return r.resolveStmt(stmt.Body)
+	if stmt.FinallyBody != nil {
+		r.resolveBlock(stmt.FinallyBody)
+	}
+	return nil
+}
+
+// Importing everything from a module defines names we can't know statically,
+// so imports are limited to the top level where names are resolved at runtime.
+func (r *Resolver) VisitImport(stmt *ast.ImportStmt) error {
+	if !r.scopes.isEmpty() {
+		return diagnostic.Errorf(diagnostic.ImportOutsideTopLevel, stmt.Span(), "Can't import outside of the top level.")
+	}
+	return nil
+}
+
+func (r *Resolver) VisitBreak(stmt *ast.BreakStmt) error {
+	if r.loopDepth == 0 {
+		return diagnostic.Errorf(diagnostic.BreakOutsideLoop, stmt.Keyword.Span(), "Can't use 'break' outside of a loop.")
+	}
+	return nil
+}
+
+func (r *Resolver) VisitContinue(stmt *ast.ContinueStmt) error {
+	if r.loopDepth == 0 {
+		return diagnostic.Errorf(diagnostic.ContinueOutsideLoop, stmt.Keyword.Span(), "Can't use 'continue' outside of a loop.")
+	}
+	return nil
+}
+
+// Syntax errors were already reported by the parser, and there is nothing left
+// in a bad node to resolve.
+func (r *Resolver) VisitBadStmt(stmt *ast.BadStmt) error {
+	return nil
+}
+
+func (r *Resolver) VisitBadExpr(expr *ast.BadExpr) (interface{}, error) {
+	return nil, nil
 }
 
 func (r *Resolver) VisitBinary(expr *ast.BinaryExpr) (interface{}, error) {
-	err := r.resolveExpr(expr.Left)
-	if err != nil {
-		return nil, err
+	// Both operands are resolved even if the first one fails, so that every
+	// error in the expression is reported at once.
+	r.report(r.resolveExpr(expr.Left))
+	r.report(r.resolveExpr(expr.Right))
+	return nil, nil
+}
+
+func (r *Resolver) VisitCall(expr *ast.CallExpr) (interface{}, error) {
+	r.report(r.resolveExpr(expr.Callee))
+	for _, arg := range expr.Args {
+		r.report(r.resolveExpr(arg))
 	}
-	err = r.resolveExpr(expr.Right)
+	return nil, nil
+}
+
+func (r *Resolver) VisitGet(expr *ast.GetExpr) (interface{}, error) {
+	// Properties are looked up dynamically, so only the object is resolved.
+	err := r.resolveExpr(expr.Object)
 	return nil, err
 }
 
-func (r *Resolver) VisitCall(expr *ast.CallExpr) (interface{}, error) {
-	err := r.resolveExpr(expr.Callee)
-	if err != nil {
-		return nil, err
+func (r *Resolver) VisitSet(expr *ast.SetExpr) (interface{}, error) {
+	r.report(r.resolveExpr(expr.Value))
+	r.report(r.resolveExpr(expr.Object))
+	return nil, nil
+}
+
+func (r *Resolver) VisitThis(expr *ast.ThisExpr) (interface{}, error) {
+	if r.currentClass == classTypeNone {
+		return nil, diagnostic.Errorf(diagnostic.ThisOutsideClass, expr.Keyword.Span(), "Can't use 'this' outside of a class.")
 	}
-	for _, param := range expr.Args {
-		err := r.resolveExpr(param)
-		if err != nil {
-			return nil, err
-		}
+	r.resolveLocal(expr, expr.Keyword.Lexeme)
+	return nil, nil
+}
+
+func (r *Resolver) VisitSuper(expr *ast.SuperExpr) (interface{}, error) {
+	if r.currentClass == classTypeNone {
+		return nil, diagnostic.Errorf(diagnostic.SuperOutsideClass, expr.Keyword.Span(), "Can't use 'super' outside of a class.")
+	} else if r.currentClass != classTypeSubclass {
+		return nil, diagnostic.Errorf(diagnostic.SuperWithoutSuperclass, expr.Keyword.Span(), "Can't use 'super' in a class with no superclass.")
 	}
-	return nil, err
+	r.resolveLocal(expr, expr.Keyword.Lexeme)
+	return nil, nil
+}
+
+func (r *Resolver) VisitList(expr *ast.ListExpr) (interface{}, error) {
+	for _, element := range expr.Elements {
+		r.report(r.resolveExpr(element))
+	}
+	return nil, nil
+}
+
+func (r *Resolver) VisitMap(expr *ast.MapExpr) (interface{}, error) {
+	for index := range expr.Keys {
+		r.report(r.resolveExpr(expr.Keys[index]))
+		r.report(r.resolveExpr(expr.Values[index]))
+	}
+	return nil, nil
+}
+
+func (r *Resolver) VisitIndex(expr *ast.IndexExpr) (interface{}, error) {
+	r.report(r.resolveExpr(expr.Object))
+	r.report(r.resolveExpr(expr.Index))
+	return nil, nil
+}
+
+func (r *Resolver) VisitIndexSet(expr *ast.IndexSetExpr) (interface{}, error) {
+	r.report(r.resolveExpr(expr.Object))
+	r.report(r.resolveExpr(expr.Index))
+	r.report(r.resolveExpr(expr.Value))
+	return nil, nil
 }
 
 func (r *Resolver) VisitGrouping(expr *ast.GroupingExpr) (interface{}, error) {
@@ -287,12 +587,9 @@ func (r *Resolver) VisitLiteral(expr *ast.LiteralExpr) (interface{}, error) {
 }
 
 func (r *Resolver) VisitLogical(expr *ast.LogicalExpr) (interface{}, error) {
-	err := r.resolveExpr(expr.Left)
-	if err != nil {
-		return nil, err
-	}
-	err = r.resolveExpr(expr.Right)
-	return nil, err
+	r.report(r.resolveExpr(expr.Left))
+	r.report(r.resolveExpr(expr.Right))
+	return nil, nil
 }
 
 func (r *Resolver) VisitUnary(expr *ast.UnaryExpr) (interface{}, error) {
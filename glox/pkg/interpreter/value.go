@@ -0,0 +1,145 @@
+package interpreter
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// FromGo converts a value from a host Go program into a Lox value. Go numbers
+// of any kind become Lox numbers, slices and arrays become lists and maps with
+// string or number keys become maps, with their entries in key order, numbers
+// first. Values that are already Lox values pass through unchanged, so that
+// what ToGo returns converts back.
+func FromGo(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, bool, float64, string, Callable, *loxInstance, *loxList, *loxMap:
+		return v, nil
+	}
+	// Anything else is told apart by its kind, to accept every numeric type
+	// and every element type of slices and maps.
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]interface{}, v.Len())
+		for index := range elements {
+			converted, err := FromGo(v.Index(index).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[index] = converted
+		}
+		return newLoxList(elements), nil
+	case reflect.Map:
+		entries := make([]mapEntry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := FromGo(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case float64, string:
+			default:
+				return nil, fmt.Errorf("Can't convert Go map key of type %T to a Lox map key.", iter.Key().Interface())
+			}
+			entries = append(entries, mapEntry{key: key, value: iter.Value().Interface()})
+		}
+		sortEntries(entries)
+		m := newLoxMap()
+		for _, entry := range entries {
+			converted, err := FromGo(entry.value)
+			if err != nil {
+				return nil, err
+			}
+			err = m.set(nil, entry.key, converted)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("Can't convert Go value of type %T to a Lox value.", value)
+}
+
+// A mapEntry is an entry of a Go map, whose key was converted already.
+type mapEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// sortEntries sorts the entries of a Go map by key, numbers first.
+func sortEntries(entries []mapEntry) {
+	sort.Slice(entries, func(a, b int) bool {
+		x, xIsNumber := entries[a].key.(float64)
+		y, yIsNumber := entries[b].key.(float64)
+		if xIsNumber != yIsNumber {
+			return xIsNumber
+		}
+		if xIsNumber {
+			return x < y
+		}
+		return entries[a].key.(string) < entries[b].key.(string)
+	})
+}
+
+// ToGo converts a Lox value for use by a host Go program. Lists become slices
+// and maps become Go maps, both copied. Functions, classes and instances are
+// returned as opaque values that can be handed back to Lox. So are the lists
+// and maps that contain themselves, which can't be copied.
+func ToGo(value interface{}) interface{} {
+	if containsItself(value, map[interface{}]bool{}) {
+		return value
+	}
+	switch v := value.(type) {
+	case *loxList:
+		elements := make([]interface{}, len(v.elements))
+		for index, element := range v.elements {
+			elements[index] = ToGo(element)
+		}
+		return elements
+	case *loxMap:
+		m := make(map[interface{}]interface{}, len(v.keys))
+		for index, key := range v.keys {
+			m[key] = ToGo(v.values[index])
+		}
+		return m
+	}
+	return value
+}
+
+// containsItself tells whether a collection is found again inside itself,
+// at any depth.
+func containsItself(value interface{}, visiting map[interface{}]bool) bool {
+	var elements []interface{}
+	switch v := value.(type) {
+	case *loxList:
+		elements = v.elements
+	case *loxMap:
+		// Keys are strings or numbers, only values can be collections.
+		elements = v.values
+	default:
+		return false
+	}
+	if visiting[value] {
+		return true
+	}
+	visiting[value] = true
+	defer delete(visiting, value)
+	for _, element := range elements {
+		if containsItself(element, visiting) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+// Package lineeditor reads lines typed at a terminal, with the editing keys of
+// readline: moving the cursor around the line, recalling the previous lines,
+// searching them with Ctrl-R and completing words with Tab.
+//
+// The terminal is put in raw mode while a line is read, so that each key
+// reaches the editor as it is pressed, and restored before ReadLine returns.
+package lineeditor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrInterrupted is returned by ReadLine when Ctrl-C abandons the line.
+var ErrInterrupted = errors.New("interrupted")
+
+// The history keeps this many lines, the oldest being forgotten first.
+const maxHistory = 1000
+
+type Editor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// The terminal to put in raw mode, if the input is one.
+	fd       uintptr
+	terminal bool
+
+	history []string
+	// Where the history is saved, if anywhere.
+	historyFile string
+	complete    func(word string) []string
+}
+
+// New returns an editor reading keys from in and drawing the line on out. If
+// in isn't a terminal, keys are read as they come, with no raw mode.
+func New(in *os.File, out io.Writer) *Editor {
+	e := newEditor(in, out)
+	e.fd = in.Fd()
+	e.terminal = IsTerminal(e.fd)
+	return e
+}
+
+func newEditor(in io.Reader, out io.Writer) *Editor {
+	return &Editor{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// SetCompleter sets what Tab completes the word before the cursor with.
+// complete returns the words that the word may complete to.
+func (e *Editor) SetCompleter(complete func(word string) []string) {
+	e.complete = complete
+}
+
+// SetHistoryFile loads the history saved in path, if there is one, and saves
+// each line added to the history there from then on.
+func (e *Editor) SetHistoryFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Reading history: %w", err)
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	if len(lines) > maxHistory {
+		// Keep the file from growing forever.
+		lines = lines[len(lines)-maxHistory:]
+		err = os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+		if err != nil {
+			return fmt.Errorf("Writing history: %w", err)
+		}
+	}
+	e.history = lines
+	e.historyFile = path
+	return nil
+}
+
+// AddHistory adds a line to the history, unless it is blank or the same as the
+// previous one. Saving it is best effort: if the history file can't be written
+// to, the line is only remembered until the editor goes away.
+func (e *Editor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+// ReadLine shows the prompt, and returns the line typed after it once Enter is
+// pressed. It returns io.EOF when Ctrl-D is pressed on an empty line, or when
+// the input ends, and ErrInterrupted when Ctrl-C is pressed.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	if e.terminal {
+		restore, err := makeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	l := &line{prompt: prompt}
+	// Which line of the history is shown, len(e.history) being the one being
+	// typed, which is kept in draft while browsing the others.
+	index := len(e.history)
+	var draft []rune
+	e.refresh(l)
+	for {
+		k, err := e.readKey()
+		if err == io.EOF && len(l.buf) > 0 {
+			return string(l.buf), nil
+		} else if err != nil {
+			return "", err
+		}
+		if k == ctrlR {
+			k, err = e.search(l)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		switch k {
+		case enter, newline:
+			fmt.Fprint(e.out, "\r\n")
+			return string(l.buf), nil
+		case ctrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupted
+		case ctrlD:
+			if len(l.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			l.delete()
+		case keyUp, ctrlP, keyDown, ctrlN:
+			if index == len(e.history) {
+				draft = l.buf
+			}
+			if (k == keyUp || k == ctrlP) && index > 0 {
+				index--
+			} else if (k == keyDown || k == ctrlN) && index < len(e.history) {
+				index++
+			}
+			if index == len(e.history) {
+				l.set(draft)
+			} else {
+				l.set([]rune(e.history[index]))
+			}
+		case tab:
+			e.completeWord(l)
+		case ctrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		default:
+			l.edit(k)
+		}
+		e.refresh(l)
+	}
+}
+
+// refresh redraws the line, and puts the cursor back where it is in the line.
+func (e *Editor) refresh(l *line) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", l.prompt, string(l.buf))
+	if back := len(l.buf) - l.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// search looks for the query typed after Ctrl-R in the history, from the most
+// recent line back. Each Ctrl-R moves to the next older match. The line found
+// replaces the one being edited. Any other key ends the search, and is
+// returned to be handled on that line, except Ctrl-G, which cancels the search
+// and restores the line.
+func (e *Editor) search(l *line) (key, error) {
+	original := *l
+	var query []rune
+	// The line of the history matching the query, len(e.history) until one
+	// does.
+	match := len(e.history)
+	failed := false
+	find := func(from int) {
+		for index := from; index >= 0; index-- {
+			if at := strings.Index(e.history[index], string(query)); at >= 0 {
+				match = index
+				l.set([]rune(e.history[index]))
+				l.pos = len([]rune(e.history[index][:at]))
+				failed = false
+				return
+			}
+		}
+		failed = true
+	}
+
+	for {
+		status := "reverse-i-search"
+		if failed {
+			status = "failed reverse-i-search"
+		}
+		fmt.Fprintf(e.out, "\r(%s)`%s': %s\x1b[K", status, string(query), string(l.buf))
+
+		k, err := e.readKey()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case k == ctrlR:
+			if len(query) > 0 {
+				find(match - 1)
+			}
+		case k == backspace || k == ctrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case k == ctrlG:
+			*l = original
+			return 0, nil
+		case k < keyUp && unicode.IsPrint(rune(k)):
+			query = append(query, rune(k))
+			find(min(match, len(e.history)-1))
+		default:
+			return k, nil
+		}
+	}
+}
+
+// completeWord completes the word before the cursor as far as all of its
+// completions agree. If that doesn't add anything, they are listed below the
+// line instead.
+func (e *Editor) completeWord(l *line) {
+	if e.complete == nil {
+		return
+	}
+	start := l.pos
+	for start > 0 && isWordRune(l.buf[start-1]) {
+		start--
+	}
+	word := string(l.buf[start:l.pos])
+	var candidates []string
+	for _, candidate := range e.complete(word) {
+		if strings.HasPrefix(candidate, word) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+	sort.Strings(candidates)
+
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) > len(word) {
+		for _, r := range prefix[len(word):] {
+			l.insert(r)
+		}
+		return
+	}
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
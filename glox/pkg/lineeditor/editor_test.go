@@ -0,0 +1,189 @@
+package lineeditor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadLine(t *testing.T) {
+	history := []string{"var a = 1;", "print a;", "var b = 2;"}
+	complete := func(word string) []string {
+		return []string{"class", "clock", "print", "printed", "var"}
+	}
+
+	tests := []struct {
+		name     string
+		keys     string
+		expected string
+	}{
+		{
+			name:     "enter returns the line",
+			keys:     "print 1;\r",
+			expected: "print 1;",
+		},
+		{
+			name:     "arrows move the cursor",
+			keys:     "ac\x1b[Db\x1b[C!\r",
+			expected: "abc!",
+		},
+		{
+			name:     "home and end",
+			keys:     "bc\x01a\x05d\x1b[Hz\x1b[F.\r",
+			expected: "zabcd.",
+		},
+		{
+			name:     "backspace and delete",
+			keys:     "abxcy\x1b[D\x1b[D\x7f\x1b[C\x1b[3~\r",
+			expected: "abc",
+		},
+		{
+			name:     "ctrl-d deletes the rune under the cursor",
+			keys:     "ab\x1b[D\x04\r",
+			expected: "a",
+		},
+		{
+			name:     "ctrl-k and ctrl-u cut to the end and the start",
+			keys:     "hello world\x1b[1;5D\x0bthere\x1b[1;5D\x15\r",
+			expected: "there",
+		},
+		{
+			name:     "ctrl-w cuts the previous word",
+			keys:     "print foo\x17bar\r",
+			expected: "print bar",
+		},
+		{
+			name:     "up recalls older lines",
+			keys:     "\x1b[A\x1b[A\r",
+			expected: "print a;",
+		},
+		{
+			name:     "down comes back to the line being typed",
+			keys:     "draft\x1b[A\x1b[A\x1b[B\x1b[B\r",
+			expected: "draft",
+		},
+		{
+			name:     "ctrl-r finds the most recent match, then older ones",
+			keys:     "\x12var\x12\r",
+			expected: "var a = 1;",
+		},
+		{
+			name:     "other keys end the search on the line found",
+			keys:     "\x12prin\x05 // found\r",
+			expected: "print a; // found",
+		},
+		{
+			name:     "ctrl-g cancels the search",
+			keys:     "draft\x12print\x07\r",
+			expected: "draft",
+		},
+		{
+			name:     "tab completes a unique word",
+			keys:     "x = clo\t\r",
+			expected: "x = clock",
+		},
+		{
+			name:     "tab completes as far as the candidates agree",
+			keys:     "pr\t\r",
+			expected: "print",
+		},
+		{
+			name:     "the last line doesn't need a newline",
+			keys:     "unfinished",
+			expected: "unfinished",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given:
+			editor := newEditor(strings.NewReader(tc.keys), io.Discard)
+			editor.history = history
+			editor.SetCompleter(complete)
+
+			// When:
+			actual, err := editor.ReadLine("> ")
+
+			// Then:
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	t.Run("ctrl-d on an empty line ends the input", func(t *testing.T) {
+		editor := newEditor(strings.NewReader("\x04"), io.Discard)
+
+		_, err := editor.ReadLine("> ")
+
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("ctrl-c abandons the line", func(t *testing.T) {
+		editor := newEditor(strings.NewReader("print\x03next\r"), io.Discard)
+
+		_, err := editor.ReadLine("> ")
+		assert.Equal(t, ErrInterrupted, err)
+		actual, err := editor.ReadLine("> ")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "next", actual)
+	})
+
+	t.Run("ambiguous completions are listed", func(t *testing.T) {
+		out := new(strings.Builder)
+		editor := newEditor(strings.NewReader("cl\t\r"), out)
+		editor.SetCompleter(complete)
+
+		actual, err := editor.ReadLine("> ")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "cl", actual)
+		assert.Contains(t, out.String(), "\r\nclass  clock\r\n")
+	})
+}
+
+func TestHistoryFile(t *testing.T) {
+	t.Run("lines added are saved for the next session", func(t *testing.T) {
+		// Given:
+		path := filepath.Join(t.TempDir(), ".glox_history")
+		editor := newEditor(strings.NewReader(""), io.Discard)
+		assert.NoError(t, editor.SetHistoryFile(path))
+
+		// When:
+		editor.AddHistory("var a = 1;")
+		editor.AddHistory("var a = 1;")
+		editor.AddHistory("  ")
+		editor.AddHistory("print a;")
+
+		// Then:
+		next := newEditor(strings.NewReader("\x1b[A\x1b[A\r"), io.Discard)
+		assert.NoError(t, next.SetHistoryFile(path))
+		assert.Equal(t, []string{"var a = 1;", "print a;"}, next.history)
+		line, err := next.ReadLine("> ")
+		assert.NoError(t, err)
+		assert.Equal(t, "var a = 1;", line)
+	})
+
+	t.Run("only the most recent lines are kept", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".glox_history")
+		var saved strings.Builder
+		for index := 0; index < maxHistory+10; index++ {
+			fmt.Fprintf(&saved, "print %d;\n", index)
+		}
+		assert.NoError(t, os.WriteFile(path, []byte(saved.String()), 0600))
+
+		editor := newEditor(strings.NewReader(""), io.Discard)
+		assert.NoError(t, editor.SetHistoryFile(path))
+
+		assert.Len(t, editor.history, maxHistory)
+		assert.Equal(t, "print 10;", editor.history[0])
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, maxHistory, strings.Count(string(data), "\n"))
+	})
+}
@@ -0,0 +1,217 @@
+package lineeditor
+
+import (
+	"unicode"
+)
+
+// A key is a rune typed, a control character, or one of the keys that
+// terminals send as escape sequences, which come after every rune.
+type key rune
+
+const (
+	ctrlA     key = 1
+	ctrlB     key = 2
+	ctrlC     key = 3
+	ctrlD     key = 4
+	ctrlE     key = 5
+	ctrlF     key = 6
+	ctrlG     key = 7
+	ctrlH     key = 8
+	tab       key = 9
+	newline   key = 10
+	ctrlK     key = 11
+	ctrlL     key = 12
+	enter     key = 13
+	ctrlN     key = 14
+	ctrlP     key = 16
+	ctrlR     key = 18
+	ctrlU     key = 21
+	ctrlW     key = 23
+	escape    key = 27
+	backspace key = 127
+)
+
+const (
+	keyUp key = unicode.MaxRune + 1 + iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	// An escape sequence the editor doesn't know, which it ignores.
+	keyUnknown
+)
+
+// readKey reads a key, decoding the escape sequences of the arrows and the
+// like, as xterm and most terminals send them.
+func (e *Editor) readKey() (key, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || key(r) != escape {
+		return key(r), err
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch r {
+	case 'b':
+		return keyWordLeft, nil
+	case 'f':
+		return keyWordRight, nil
+	case 'O':
+		// Some terminals send ESC O for the arrows, Home and End.
+		r, _, err = e.in.ReadRune()
+		return csiKey("", r), err
+	case '[':
+		// A control sequence: numeric parameters separated by semicolons,
+		// then the final byte saying what it is.
+		var params []rune
+		for {
+			r, _, err = e.in.ReadRune()
+			if err != nil {
+				return 0, err
+			}
+			if r < '0' || r > ';' {
+				return csiKey(string(params), r), nil
+			}
+			params = append(params, r)
+		}
+	}
+	return keyUnknown, nil
+}
+
+func csiKey(params string, final rune) key {
+	// Ctrl or Alt with an arrow moves by words.
+	word := params == "1;3" || params == "1;5"
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		if word {
+			return keyWordRight
+		}
+		return keyRight
+	case 'D':
+		if word {
+			return keyWordLeft
+		}
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "3":
+			return keyDelete
+		}
+	}
+	return keyUnknown
+}
+
+// The line being edited.
+type line struct {
+	prompt string
+	buf    []rune
+	// Where the cursor is in buf.
+	pos int
+}
+
+// set replaces the text of the line, leaving the cursor at its end.
+func (l *line) set(text []rune) {
+	l.buf = append([]rune(nil), text...)
+	l.pos = len(l.buf)
+}
+
+func (l *line) insert(r rune) {
+	l.buf = append(l.buf, 0)
+	copy(l.buf[l.pos+1:], l.buf[l.pos:])
+	l.buf[l.pos] = r
+	l.pos++
+}
+
+// delete removes the rune under the cursor.
+func (l *line) delete() {
+	if l.pos < len(l.buf) {
+		l.buf = append(l.buf[:l.pos], l.buf[l.pos+1:]...)
+	}
+}
+
+// cut removes the runes between from and the cursor, wherever it is.
+func (l *line) cut(from int) {
+	start, end := from, l.pos
+	if start > end {
+		start, end = end, start
+	}
+	l.buf = append(l.buf[:start], l.buf[end:]...)
+	l.pos = start
+}
+
+func (l *line) wordLeft() int {
+	pos := l.pos
+	for pos > 0 && !isWordRune(l.buf[pos-1]) {
+		pos--
+	}
+	for pos > 0 && isWordRune(l.buf[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+func (l *line) wordRight() int {
+	pos := l.pos
+	for pos < len(l.buf) && !isWordRune(l.buf[pos]) {
+		pos++
+	}
+	for pos < len(l.buf) && isWordRune(l.buf[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// edit handles the keys that only change the line and the cursor.
+func (l *line) edit(k key) {
+	switch k {
+	case keyLeft, ctrlB:
+		if l.pos > 0 {
+			l.pos--
+		}
+	case keyRight, ctrlF:
+		if l.pos < len(l.buf) {
+			l.pos++
+		}
+	case keyHome, ctrlA:
+		l.pos = 0
+	case keyEnd, ctrlE:
+		l.pos = len(l.buf)
+	case keyWordLeft:
+		l.pos = l.wordLeft()
+	case keyWordRight:
+		l.pos = l.wordRight()
+	case backspace, ctrlH:
+		if l.pos > 0 {
+			l.cut(l.pos - 1)
+		}
+	case keyDelete:
+		l.delete()
+	case ctrlK:
+		l.buf = l.buf[:l.pos]
+	case ctrlU:
+		l.cut(0)
+	case ctrlW:
+		l.cut(l.wordLeft())
+	default:
+		if k < keyUp && unicode.IsPrint(rune(k)) {
+			l.insert(rune(k))
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package lineeditor
+
+import "errors"
+
+// IsTerminal reports whether the file descriptor is a terminal. Raw mode isn't
+// supported on this platform, so it never is as far as the editor is
+// concerned, and lines are read as they come.
+func IsTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (restore func(), err error) {
+	return nil, errors.New("raw mode is not supported on this platform")
+}
@@ -0,0 +1,55 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package lineeditor
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// IsTerminal reports whether the file descriptor is a terminal.
+func IsTerminal(fd uintptr) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// makeRaw puts the terminal in raw mode, where keys are read as they are
+// pressed, without being echoed, nor turned into signals like Ctrl-C. Output
+// processing is left on, so that newlines still return the carriage. It
+// returns how to restore the terminal as it was.
+func makeRaw(fd uintptr) (restore func(), err error) {
+	original, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	raw := *original
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Cflag |= syscall.CS8
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = setTermios(fd, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		setTermios(fd, original)
+	}, nil
+}
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	termios := &syscall.Termios{}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return termios, nil
+}
+
+func setTermios(fd uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
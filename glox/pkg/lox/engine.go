@@ -0,0 +1,393 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/interpreter"
+	"github.com/modulitos/glox/pkg/vm"
+)
+
+// A Value is any Lox value as seen from Go: nil, bool, float64, string,
+// []Value for lists, map[Value]Value for maps, or an opaque handle for
+// functions, classes and instances. See interpreter.FromGo and
+// interpreter.ToGo for the exact conversions.
+type Value = interface{}
+
+// Backend selects what runs the scripts of an Engine.
+type Backend string
+
+const (
+	// TreeWalker evaluates the syntax tree directly. It is the default.
+	TreeWalker = Backend("tree")
+	// VM compiles scripts to bytecode, and runs them on a stack-based virtual
+	// machine.
+	VM = Backend("vm")
+	// Closures compiles the syntax tree to Go closures once, then runs them.
+	// It behaves like TreeWalker, only faster.
+	Closures = Backend("closures")
+)
+
+// Engine embeds a Lox interpreter in a host Go program. Globals defined by one
+// call to Eval stay visible to the next, like they do in the REPL.
+type Engine struct {
+	backend     Backend
+	interpreter *interpreter.Interpreter
+	vm          *vm.VM
+	stdout      io.Writer
+	stderr      io.Writer
+	stdin       io.Reader
+	color       bool
+	// Whether resolver warnings fail the script instead of being reported.
+	warningsAsErrors bool
+	// Where the VM traces the instructions it runs, if anywhere.
+	trace   io.Writer
+	printer *diagnostic.Printer
+	// The sources entered at the prompt so far. Each gets its own name, so
+	// that the printer keeps them all for the errors raised in functions
+	// defined by earlier ones.
+	promptEntries int
+}
+
+type Option func(e *Engine)
+
+// WithStdout sets where `print` statements write to. Defaults to os.Stdout.
+func WithStdout(w io.Writer) Option {
+	return func(e *Engine) {
+		e.stdout = w
+	}
+}
+
+// WithStderr sets where warnings, and the errors RunPrompt recovers from, are
+// reported. Defaults to os.Stderr.
+func WithStderr(w io.Writer) Option {
+	return func(e *Engine) {
+		e.stderr = w
+	}
+}
+
+// WithStdin sets where RunPrompt reads its input from. Defaults to os.Stdin.
+func WithStdin(r io.Reader) Option {
+	return func(e *Engine) {
+		e.stdin = r
+	}
+}
+
+// WithColor highlights the diagnostics reported to stderr with ANSI colors.
+func WithColor(color bool) Option {
+	return func(e *Engine) {
+		e.color = color
+	}
+}
+
+// WithWarningsAsErrors makes resolver warnings, like unused variables, fail
+// the script before it runs, like the errors they become.
+func WithWarningsAsErrors(warningsAsErrors bool) Option {
+	return func(e *Engine) {
+		e.warningsAsErrors = warningsAsErrors
+	}
+}
+
+// WithBackend selects what runs the scripts. Defaults to TreeWalker.
+func WithBackend(backend Backend) Option {
+	return func(e *Engine) {
+		e.backend = backend
+	}
+}
+
+// WithTrace makes the VM backend print the stack and each instruction to w
+// before running it. The tree-walking interpreter ignores it.
+func WithTrace(w io.Writer) Option {
+	return func(e *Engine) {
+		e.trace = w
+	}
+}
+
+func NewEngine(options ...Option) *Engine {
+	e := &Engine{
+		backend: TreeWalker,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+		stdin:   os.Stdin,
+	}
+	for _, option := range options {
+		option(e)
+	}
+	e.reset()
+	e.printer = diagnostic.NewPrinter(e.stderr, e.color)
+	return e
+}
+
+// reset starts the backend afresh, forgetting every global defined since.
+func (e *Engine) reset() {
+	e.vm, e.interpreter = nil, nil
+	if e.backend == VM {
+		e.vm = vm.New(e.stdout)
+		e.vm.SetTrace(e.trace)
+		e.vm.SetModuleCompiler(e.compileModule)
+	} else {
+		e.interpreter = interpreter.NewInterpreter(e.stdout)
+		e.interpreter.SetWarningHandler(e.warn)
+		e.interpreter.SetClosureCompilation(e.backend == Closures)
+	}
+}
+
+// warn reports the warnings to stderr, or returns them as errors if warnings
+// are treated as errors.
+func (e *Engine) warn(warnings diagnostic.List) error {
+	if len(warnings) == 0 {
+		return nil
+	}
+	if e.warningsAsErrors {
+		return warnings.AsErrors()
+	}
+	e.printer.Print(warnings)
+	return nil
+}
+
+// Eval runs the source, and returns the value of its last statement if that
+// is an expression statement. Eval("1 + 2;") returns 3.
+func (e *Engine) Eval(src string) (Value, error) {
+	result, err := e.eval("", []byte(src))
+	if err != nil {
+		return nil, err
+	}
+	return e.toGo(result), nil
+}
+
+// RunFile runs the script at path. Its imports resolve relative to it.
+// Scripts precompiled by Compile, with the .loxc extension, run directly on the
+// VM backend.
+func (e *Engine) RunFile(path string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Reading script file: %w", err)
+	}
+	restore, err := e.setScriptPath(path)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	if filepath.Ext(path) == ".loxc" {
+		return e.runBytecode(path, bytes)
+	}
+	return e.run(path, bytes)
+}
+
+// Run runs source that wasn't read from a file, like a script piped to stdin
+// or passed on the command line. name labels its positions in diagnostics,
+// and its imports resolve relative to the working directory.
+func (e *Engine) Run(name string, source []byte) error {
+	return e.run(name, source)
+}
+
+// Check scans, parses and resolves the source without running it. Its
+// warnings are reported like they are before running it.
+func (e *Engine) Check(name string, source []byte) error {
+	_, err := e.check(name, source)
+	return err
+}
+
+// Report prints the diagnostics held by err to stderr, showing the lines of
+// the sources the engine read that they point at.
+func (e *Engine) Report(err error) {
+	e.printer.Print(err)
+}
+
+// Disassemble compiles the script at path for the VM, whatever the backend,
+// and prints its bytecode to stdout without running it.
+func (e *Engine) Disassemble(path string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Reading script file: %w", err)
+	}
+	statements, err := e.check(path, bytes)
+	if err != nil {
+		return err
+	}
+	script, err := vm.Compile(statements)
+	if err != nil {
+		return err
+	}
+	vm.Disassemble(e.stdout, script)
+	return nil
+}
+
+// Compile compiles the script at path for the VM backend, whatever the
+// backend, and returns it encoded in the .loxc format.
+func (e *Engine) Compile(path string) ([]byte, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Reading script file: %w", err)
+	}
+	statements, err := e.check(path, source)
+	if err != nil {
+		return nil, err
+	}
+	script, err := vm.Compile(statements)
+	if err != nil {
+		return nil, err
+	}
+	return vm.NewBytecode(script, path, source).MarshalBinary()
+}
+
+// runBytecode runs a script precompiled by Compile. It refuses to if the
+// source it was compiled from is around and has changed since.
+func (e *Engine) runBytecode(path string, data []byte) error {
+	if e.vm == nil {
+		return fmt.Errorf("Running %s: precompiled scripts only run on the vm backend.", path)
+	}
+	var bytecode vm.Bytecode
+	err := bytecode.UnmarshalBinary(data)
+	if err != nil {
+		return fmt.Errorf("Loading %s: %w", path, err)
+	}
+	source, err := os.ReadFile(bytecode.File)
+	if err == nil {
+		if bytecode.IsStale(source) {
+			return fmt.Errorf("Loading %s: %w: %s changed since it was compiled", path, vm.ErrStaleBytecode, bytecode.File)
+		}
+		e.printer.AddSource(bytecode.File, source)
+	}
+	_, err = e.vm.Interpret(bytecode.Script)
+	return err
+}
+
+// Call calls the global function or class with the given name.
+func (e *Engine) Call(name string, args ...Value) (Value, error) {
+	callee, ok := e.getGlobal(name)
+	if !ok {
+		return nil, fmt.Errorf("Undefined global: %s.", name)
+	}
+	loxArgs := make([]interface{}, len(args))
+	for index, arg := range args {
+		converted, err := e.fromGo(arg)
+		if err != nil {
+			return nil, err
+		}
+		loxArgs[index] = converted
+	}
+	var result interface{}
+	var err error
+	if e.vm != nil {
+		result, err = e.vm.Call(callee, loxArgs)
+	} else {
+		result, err = e.interpreter.Call(callee, loxArgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.toGo(result), nil
+}
+
+// SetGlobal defines, or redefines, a global variable visible to scripts.
+func (e *Engine) SetGlobal(name string, value Value) error {
+	converted, err := e.fromGo(value)
+	if err != nil {
+		return err
+	}
+	e.defineGlobal(name, converted)
+	return nil
+}
+
+// GetGlobal returns the value of a global variable, and whether it exists.
+func (e *Engine) GetGlobal(name string) (Value, bool) {
+	value, ok := e.getGlobal(name)
+	return e.toGo(value), ok
+}
+
+// RegisterFunc defines a global native function that scripts can call with
+// exactly arity arguments. An error returned by fn becomes a Lox runtime
+// error, which scripts can catch.
+func (e *Engine) RegisterFunc(name string, arity int, fn func(args []Value) (Value, error)) {
+	call := func(args []interface{}) (interface{}, error) {
+		goArgs := make([]Value, len(args))
+		for index, arg := range args {
+			goArgs[index] = e.toGo(arg)
+		}
+		result, err := fn(goArgs)
+		if err != nil {
+			return nil, err
+		}
+		return e.fromGo(result)
+	}
+	if e.vm != nil {
+		e.defineGlobal(name, vm.NewNative(name, arity, call))
+	} else {
+		e.defineGlobal(name, interpreter.NewNativeFunction(name, arity, call))
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Backends
+
+// setScriptPath records the file about to run, until restore is called.
+func (e *Engine) setScriptPath(path string) (restore func(), err error) {
+	if e.vm != nil {
+		return e.vm.SetScriptPath(path)
+	}
+	return e.interpreter.SetScriptPath(path)
+}
+
+// compileModule checks and compiles a module imported by a script running on
+// the VM, reporting its warnings like the tree-walking interpreter does.
+func (e *Engine) compileModule(path string, source []byte) (*vm.Function, error) {
+	statements, err := e.check(path, source)
+	if err != nil {
+		return nil, err
+	}
+	return vm.Compile(statements)
+}
+
+func (e *Engine) getGlobal(name string) (interface{}, bool) {
+	if e.vm != nil {
+		return e.vm.GetGlobal(name)
+	}
+	return e.interpreter.GetGlobal(name)
+}
+
+func (e *Engine) defineGlobal(name string, value interface{}) {
+	if e.vm != nil {
+		e.vm.DefineGlobal(name, value)
+	} else {
+		e.interpreter.DefineGlobal(name, value)
+	}
+}
+
+func (e *Engine) globalNames() []string {
+	if e.vm != nil {
+		return e.vm.GlobalNames()
+	}
+	return e.interpreter.GlobalNames()
+}
+
+func (e *Engine) builtinNames() []string {
+	if e.vm != nil {
+		return e.vm.BuiltinNames()
+	}
+	return e.interpreter.BuiltinNames()
+}
+
+func (e *Engine) inspect(value interface{}) string {
+	if e.vm != nil {
+		return vm.Inspect(value)
+	}
+	return e.interpreter.Inspect(value)
+}
+
+func (e *Engine) toGo(value interface{}) Value {
+	if e.vm != nil {
+		return vm.ToGo(value)
+	}
+	return interpreter.ToGo(value)
+}
+
+func (e *Engine) fromGo(value Value) (interface{}, error) {
+	if e.vm != nil {
+		return vm.FromGo(value)
+	}
+	return interpreter.FromGo(value)
+}
@@ -0,0 +1,350 @@
+package lox
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modulitos/glox/pkg/vm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine(t *testing.T) {
+	t.Run("eval returns the last expression's value", func(t *testing.T) {
+		// Given:
+		stdout := new(bytes.Buffer)
+		engine := NewEngine(WithStdout(stdout))
+
+		// When:
+		result, err := engine.Eval(`var x = 40; print "side effect"; x + 2;`)
+
+		// Then:
+		assert.NoError(t, err)
+		assert.Equal(t, 42.0, result)
+		assert.Equal(t, "side effect\n", stdout.String())
+	})
+
+	t.Run("globals persist between evals", func(t *testing.T) {
+		engine := NewEngine(WithStdout(new(bytes.Buffer)))
+
+		_, err := engine.Eval(`var list = [1, "two"];`)
+		assert.NoError(t, err)
+		result, err := engine.Eval(`list;`)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{1.0, "two"}, result)
+	})
+
+	t.Run("set and get globals", func(t *testing.T) {
+		engine := NewEngine(WithStdout(new(bytes.Buffer)))
+
+		assert.NoError(t, engine.SetGlobal("limit", 10))
+		assert.NoError(t, engine.SetGlobal("config", map[string]Value{"name": "svc"}))
+		_, err := engine.Eval(`var doubled = limit * 2; var name = config["name"];`)
+		assert.NoError(t, err)
+
+		doubled, ok := engine.GetGlobal("doubled")
+		assert.True(t, ok)
+		assert.Equal(t, 20.0, doubled)
+		name, ok := engine.GetGlobal("name")
+		assert.True(t, ok)
+		assert.Equal(t, "svc", name)
+		_, ok = engine.GetGlobal("missing")
+		assert.False(t, ok)
+	})
+
+	t.Run("values handed to Go convert back, and so do other Go types", func(t *testing.T) {
+		for _, backend := range backends {
+			t.Run(string(backend), func(t *testing.T) {
+				stdout := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(stdout), WithBackend(backend))
+				_, err := engine.Eval(`var m = {"list": [1, "two"], 3: {"nested": true}};`)
+				assert.NoError(t, err)
+				m, _ := engine.GetGlobal("m")
+
+				assert.NoError(t, engine.SetGlobal("copy", m))
+				assert.NoError(t, engine.SetGlobal("small", []uint8{1, 2}))
+				assert.NoError(t, engine.SetGlobal("sizes", map[int64]float32{2: 0.5, 1: 1.5}))
+				assert.NoError(t, engine.SetGlobal("flags", [2]bool{true, false}))
+				result, err := engine.Eval(`[copy["list"], copy[3]["nested"], small, sizes, flags];`)
+
+				assert.NoError(t, err)
+				assert.Equal(t, []Value{
+					[]Value{1.0, "two"}, true, []Value{1.0, 2.0}, map[Value]Value{1.0: 1.5, 2.0: 0.5}, []Value{true, false},
+				}, result)
+				// Go maps are in key order, numbers first.
+				_, err = engine.Eval(`print sizes; print copy;`)
+				assert.NoError(t, err)
+				assert.Equal(t, "{1: 1.5, 2: 0.5}\n{3: {\"nested\": true}, \"list\": [1, \"two\"]}\n", stdout.String())
+				err = engine.SetGlobal("bad", map[bool]Value{true: 1})
+				assert.EqualError(t, err, "Can't convert Go map key of type bool to a Lox map key.")
+				err = engine.SetGlobal("bad", struct{}{})
+				assert.EqualError(t, err, "Can't convert Go value of type struct {} to a Lox value.")
+			})
+		}
+	})
+
+	t.Run("call a Lox function from Go", func(t *testing.T) {
+		engine := NewEngine(WithStdout(new(bytes.Buffer)))
+		_, err := engine.Eval(`fun add(a, b) { return a + b; }`)
+		assert.NoError(t, err)
+
+		result, err := engine.Call("add", 1, 2.5)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3.5, result)
+
+		_, err = engine.Call("add", 1)
+		assert.ErrorContains(t, err, "Expected 2 arguments but got 1.")
+		_, err = engine.Call("nope")
+		assert.ErrorContains(t, err, "Undefined global: nope.")
+	})
+
+	t.Run("register a native function", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		engine := NewEngine(WithStdout(stdout))
+		engine.RegisterFunc("upper", 1, func(args []Value) (Value, error) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, errors.New("upper() expects a string")
+			}
+			return strings.ToUpper(s), nil
+		})
+
+		_, err := engine.Eval(`
+print upper("shout");
+try {
+  upper(1);
+} catch (e) {
+  print e.message;
+}
+`)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "SHOUT\nupper() expects a string\n", stdout.String())
+	})
+
+	t.Run("lists and maps that contain themselves are handed to Go as they are", func(t *testing.T) {
+		for _, backend := range backends {
+			t.Run(string(backend), func(t *testing.T) {
+				stdout := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(stdout), WithBackend(backend), WithStdin(strings.NewReader("l\n")))
+				_, err := engine.Eval(`var m = {}; var l = [m]; m["l"] = l;`)
+				assert.NoError(t, err)
+
+				list, ok := engine.GetGlobal("l")
+				assert.True(t, ok)
+				assert.NoError(t, engine.SetGlobal("same", list))
+				same, err := engine.Eval(`same == l;`)
+				assert.NoError(t, err)
+				assert.Equal(t, true, same)
+				assert.NoError(t, engine.RunPrompt())
+				assert.Contains(t, stdout.String(), "> [{\"l\": [...]}]\n")
+			})
+		}
+	})
+
+	t.Run("the vm backend embeds the same way", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		engine := NewEngine(WithStdout(stdout), WithBackend(VM))
+		engine.RegisterFunc("twice", 1, func(args []Value) (Value, error) {
+			return args[0].(float64) * 2, nil
+		})
+		assert.NoError(t, engine.SetGlobal("names", []Value{"a", "b"}))
+
+		_, err := engine.Eval(`fun describe(n) { return names[n] + twice(n); }`)
+		assert.NoError(t, err)
+		result, err := engine.Call("describe", 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "b2", result)
+		_, err = engine.Eval(`import "other.lox";`)
+		assert.ErrorContains(t, err, `Module "other.lox" not found, searched: other.lox.`)
+	})
+
+	t.Run("precompiled scripts run until their source changes", func(t *testing.T) {
+		dir := t.TempDir()
+		script := filepath.Join(dir, "script.lox")
+		compiled := filepath.Join(dir, "script.loxc")
+		assert.NoError(t, os.WriteFile(script, []byte(`print "compiled";`), 0644))
+		bytecode, err := NewEngine().Compile(script)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(compiled, bytecode, 0644))
+		stdout := new(bytes.Buffer)
+
+		err = NewEngine(WithStdout(stdout), WithBackend(VM)).RunFile(compiled)
+		assert.NoError(t, err)
+		assert.Equal(t, "compiled\n", stdout.String())
+
+		err = NewEngine(WithStdout(stdout)).RunFile(compiled)
+		assert.ErrorContains(t, err, "precompiled scripts only run on the vm backend.")
+		assert.NoError(t, os.WriteFile(script, []byte(`print "edited";`), 0644))
+		err = NewEngine(WithStdout(stdout), WithBackend(VM)).RunFile(compiled)
+		assert.ErrorIs(t, err, vm.ErrStaleBytecode)
+	})
+
+	t.Run("go panics become internal errors with the lox stack", func(t *testing.T) {
+		for _, backend := range backends {
+			t.Run(string(backend), func(t *testing.T) {
+				stdout := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(stdout), WithBackend(backend))
+				engine.RegisterFunc("crash", 0, func(args []Value) (Value, error) {
+					panic("boom")
+				})
+
+				_, err := engine.Eval(`fun outer() {
+  try {
+    crash();
+  } catch (e) {
+    print "caught";
+  } finally {
+    print "finally";
+  }
+}
+outer();`)
+
+				// Every backend points at the call, like for the errors
+				// natives return.
+				assert.EqualError(t, err, "3:11: error[E0310]: Internal error: boom\n"+
+					"note: this is a bug in glox, not in the script\n"+
+					"note: stack trace:\n"+
+					"  at outer (3:11)\n"+
+					"  at <script> (10:7)")
+				assert.Equal(t, "finally\n", stdout.String())
+
+				// The engine is still usable after the panic.
+				_, err = engine.Eval(`print "after";`)
+				assert.NoError(t, err)
+				assert.Equal(t, "finally\nafter\n", stdout.String())
+			})
+		}
+	})
+
+	t.Run("prompt reads stdin and reports errors to stderr", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		stderr := new(bytes.Buffer)
+		engine := NewEngine(
+			WithStdout(stdout),
+			WithStderr(stderr),
+			WithStdin(strings.NewReader("var a = 1;\nprint a + ;\nprint a;\n")),
+		)
+
+		err := engine.RunPrompt()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "starting up lox version 0.0.0\n> > > 1\n> ", stdout.String())
+		assert.Equal(t, "error[E0101]: Expect expression.\n"+
+			" --> <repl:2>:1:11\n"+
+			"  |\n"+
+			"1 | print a + ;\n"+
+			"  |           ^ found ';'\n"+
+			"\n", stderr.String())
+	})
+
+	t.Run("prompt continues open statements, prints values and runs commands", func(t *testing.T) {
+		input := "fun add(a, b) {\n" +
+			"  return a + b;\n" +
+			"}\n" +
+			"add(1, 2)\n" +
+			"\"two\nlines\"\n" +
+			"print nope;\n" +
+			":env\n" +
+			":ast 1 + 2\n" +
+			":reset\n" +
+			":env\n" +
+			":nope\n" +
+			":quit\n" +
+			"print \"unreachable\";\n"
+		for _, backend := range backends {
+			t.Run(string(backend), func(t *testing.T) {
+				stdout := new(bytes.Buffer)
+				stderr := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(stdout), WithStderr(stderr), WithStdin(strings.NewReader(input)), WithBackend(backend))
+
+				err := engine.RunPrompt()
+
+				assert.NoError(t, err)
+				assert.Equal(t, "starting up lox version 0.0.0\n"+
+					"> ... ... > 3\n"+
+					"> ... \"two\\nlines\"\n"+
+					"> > add = <fn add>\n"+
+					"> (+ 1 2)\n"+
+					"> Forgot every global.\n"+
+					"> > > ", stdout.String())
+				assert.Equal(t, "error[E0301]: Undefined variable: nope.\n"+
+					" --> <repl:4>:1:7\n"+
+					"  |\n"+
+					"1 | print nope;\n"+
+					"  |       ^^^^\n"+
+					"\n"+
+					"Unknown command :nope, see :help.\n", stderr.String())
+			})
+		}
+	})
+
+	t.Run("prompt shows the source of errors raised in earlier entries", func(t *testing.T) {
+		input := "fun f() {\n" +
+			"  return 1 + nil;\n" +
+			"}\n" +
+			"var longer_line_here = 1; var more = 2; print f();\n"
+		for _, backend := range backends {
+			t.Run(string(backend), func(t *testing.T) {
+				stderr := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(new(bytes.Buffer)), WithStderr(stderr), WithStdin(strings.NewReader(input)), WithBackend(backend))
+
+				err := engine.RunPrompt()
+
+				assert.NoError(t, err)
+				assert.Equal(t, "error[E0303]: operands must be both numbers, both strings, or at least one number and a string. Got number and nil.\n"+
+					" --> <repl:1>:2:12\n"+
+					"  |\n"+
+					"2 |   return 1 + nil;\n"+
+					"  |            ^\n"+
+					"  = note: stack trace:\n"+
+					"            at f (<repl:1>:2:12)\n"+
+					"            at <script> (<repl:2>:1:49)\n"+
+					"\n", stderr.String())
+			})
+		}
+	})
+
+	t.Run("warnings are reported to stderr without stopping the script", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		stderr := new(bytes.Buffer)
+		engine := NewEngine(WithStdout(stdout), WithStderr(stderr))
+
+		_, err := engine.Eval("{\n  var unused = 1;\n}\nprint \"ran\";")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "ran\n", stdout.String())
+		assert.Equal(t, "warning[W0001]: Local variable 'unused' is never used.\n"+
+			" --> 2:7\n"+
+			"  |\n"+
+			"2 |   var unused = 1;\n"+
+			"  |       ^^^^^^\n"+
+			"\n", stderr.String())
+	})
+
+	t.Run("check doesn't run the source, and run reports errors against it", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		stderr := new(bytes.Buffer)
+		engine := NewEngine(WithStdout(stdout), WithStderr(stderr))
+
+		assert.NoError(t, engine.Check("<stdin>", []byte(`print "checked";`)))
+		assert.NoError(t, engine.SetGlobal("args", []Value{"a", "b"}))
+		err := engine.Run("<stdin>", []byte("print args;\nprint args[2];"))
+		engine.Report(err)
+
+		assert.Error(t, err)
+		assert.Equal(t, "[\"a\", \"b\"]\n", stdout.String())
+		assert.Equal(t, "error[E0306]: List index 2 out of range for list of length 2.\n"+
+			" --> <stdin>:2:13\n"+
+			"  |\n"+
+			"2 | print args[2];\n"+
+			"  |             ^\n"+
+			"\n", stderr.String())
+	})
+}
@@ -1,61 +1,79 @@
 package lox
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 
+	"github.com/modulitos/glox/pkg/ast"
 	"github.com/modulitos/glox/pkg/interpreter"
 	"github.com/modulitos/glox/pkg/parser"
 	"github.com/modulitos/glox/pkg/scanner"
+	"github.com/modulitos/glox/pkg/vm"
 )
 
-func run(source []byte, interpreterInstance *interpreter.Interpreter) error {
-	s := scanner.NewScanner(source)
-	tokens, err := s.ScanTokens()
+func (e *Engine) run(file string, source []byte) error {
+	_, err := e.eval(file, source)
+	return err
+}
+
+// eval runs the source and returns the value of its last statement, if that
+// is an expression statement. The file name only labels source positions, and
+// is empty for source that wasn't read from a file. Warnings are reported to
+// stderr before the source runs, unless they are treated as errors.
+func (e *Engine) eval(file string, source []byte) (interface{}, error) {
+	statements, err := e.check(file, source)
 	if err != nil {
-		err = fmt.Errorf("Scanning tokens: %w", err)
-		return err
+		return nil, err
+	}
+	if e.vm != nil {
+		script, err := vm.Compile(statements)
+		if err != nil {
+			return nil, err
+		}
+		return e.vm.Interpret(script)
 	}
+	return e.interpreter.Evaluate(statements)
+}
 
-	parser := parser.Parser{Tokens: tokens}
-	statements, err := parser.Parse()
+// check scans, parses and resolves the source, and reports its warnings.
+func (e *Engine) check(file string, source []byte) ([]ast.Stmt, error) {
+	statements, err := e.parse(file, source)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resolver := interpreter.NewResolver(interpreterInstance)
+	// The VM resolves variables as it compiles them, so the resolver only
+	// checks the program for it.
+	resolver := interpreter.NewResolver(e.interpreter)
 	err = resolver.ResolveStmts(statements)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return interpreterInstance.Interpret(statements)
+	err = e.warn(resolver.Warnings())
+	if err != nil {
+		return nil, err
+	}
+	return statements, nil
 }
 
-func RunFile(file string) error {
-	fmt.Printf("running file: %s\n", file)
-	bytes, err := os.ReadFile(file)
+// parse scans and parses the source, without resolving it.
+func (e *Engine) parse(file string, source []byte) ([]ast.Stmt, error) {
+	e.printer.AddSource(file, source)
+	s := scanner.NewFileScanner(file, source)
+	tokens, err := s.ScanTokens()
 	if err != nil {
-		return fmt.Errorf("Reading script file: %w", err)
+		err = fmt.Errorf("Scanning tokens: %w", err)
+		return nil, err
 	}
-	interpreter := interpreter.NewInterpreter(os.Stdout)
-	return run(bytes, interpreter)
+
+	parser := parser.Parser{Tokens: tokens}
+	return parser.Parse()
 }
 
-func RunPrompt() (err error) {
-	fmt.Println("starting up lox version 0.0.0")
-	scanner := bufio.NewScanner(os.Stdin)
-	interpreter := interpreter.NewInterpreter(os.Stdout)
-	fmt.Print("> ")
-	for scanner.Scan() {
-		promptErr := run(scanner.Bytes(), interpreter)
-		if promptErr != nil {
-			fmt.Printf("Error evaluating input: %v\n", promptErr)
-		}
-		fmt.Print("> ")
-	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "Reading standard input:", err)
-	}
-	return nil
+func RunFile(file string, options ...Option) error {
+	fmt.Printf("running file: %s\n", file)
+	return NewEngine(options...).RunFile(file)
+}
+
+func RunPrompt(options ...Option) (err error) {
+	return NewEngine(options...).RunPrompt()
 }
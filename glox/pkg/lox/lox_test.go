@@ -2,14 +2,21 @@ package lox
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 
-	"github.com/modulitos/glox/pkg/interpreter"
 	"github.com/stretchr/testify/assert"
 )
 
+// All backends run the same programs, and have to agree on their output and
+// their errors.
+var backends = []Backend{TreeWalker, VM, Closures}
+
 func TestInterpreterIntegration(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -125,34 +132,1301 @@ for (var i = 0; i < 12; i = i + 1) {
 `,
 			expected: "0\n1\n1\n2\n3\n5\n8\n13\n21\n34\n55\n89\n",
 		},
+		{
+			name: "class declaration and instances",
+			source: `
+class Bagel {}
+var bagel = Bagel();
+print Bagel;
+print bagel;
+`,
+			expected: "Bagel\nBagel instance\n",
+		},
+		{
+			name: "instance fields",
+			source: `
+class Box {}
+var box = Box();
+box.contents = "cereal";
+print box.contents;
+box.contents = box.contents + "!";
+print box.contents;
+`,
+			expected: "cereal\ncereal!\n",
+		},
+		{
+			name: "methods and this",
+			source: `
+class Cake {
+  taste() {
+    var adjective = "delicious";
+    print "The " + this.flavor + " cake is " + adjective + "!";
+  }
+}
+
+var cake = Cake();
+cake.flavor = "German chocolate";
+cake.taste();
+`,
+			expected: "The German chocolate cake is delicious!\n",
+		},
+		{
+			name: "bound methods remember this",
+			source: `
+class Person {
+  sayName() {
+    print this.name;
+  }
+}
+
+var jane = Person();
+jane.name = "Jane";
+var bill = Person();
+bill.name = "Bill";
+bill.sayName = jane.sayName;
+bill.sayName();
+`,
+			expected: "Jane\n",
+		},
+		{
+			name: "initializer",
+			source: `
+class Point {
+  init(x, y) {
+    this.x = x;
+    this.y = y;
+  }
+
+  sum() {
+    return this.x + this.y;
+  }
+}
+
+var p = Point(1, 2);
+print p.sum();
+print p.init(3, 4).sum();
+`,
+			expected: "3\n7\n",
+		},
+		{
+			name: "early return in initializer",
+			source: `
+class Foo {
+  init() {
+    this.ok = true;
+    return;
+    this.ok = false;
+  }
+}
+print Foo().ok;
+`,
+			expected: "true\n",
+		},
+		{
+			name: "inherited methods",
+			source: `
+class Doughnut {
+  cook() {
+    print "Fry until golden brown.";
+  }
+}
+
+class BostonCream < Doughnut {}
+
+BostonCream().cook();
+`,
+			expected: "Fry until golden brown.\n",
+		},
+		{
+			name: "calling superclass methods",
+			source: `
+class Doughnut {
+  cook() {
+    print "Fry until golden brown.";
+  }
+}
+
+class BostonCream < Doughnut {
+  cook() {
+    super.cook();
+    print "Pipe full of custard and coat with chocolate.";
+  }
+}
+
+BostonCream().cook();
+`,
+			expected: "Fry until golden brown.\nPipe full of custard and coat with chocolate.\n",
+		},
+		{
+			name: "super binds to the declaring class's superclass",
+			source: `
+class A {
+  method() {
+    print "A method";
+  }
+}
+
+class B < A {
+  method() {
+    print "B method";
+  }
+
+  test() {
+    super.method();
+  }
+}
+
+class C < B {}
+
+C().test();
+`,
+			expected: "A method\n",
+		},
+		{
+			name: "inherited initializer",
+			source: `
+class Shape {
+  init(name) {
+    this.name = name;
+  }
+}
+
+class Square < Shape {
+  init(side) {
+    super.init("square");
+    this.side = side;
+  }
+
+  area() {
+    return this.side * this.side;
+  }
+}
+
+var s = Square(3);
+print s.name + " " + s.area();
+`,
+			expected: "square 9\n",
+		},
+		{
+			name: "closure counter",
+			source: `
+fun makeCounter() {
+  var i = 0;
+  fun count() {
+    i = i + 1;
+    print i;
+  }
+
+  return count;
+}
+
+var counter = makeCounter();
+counter();
+counter();
+
+var other = makeCounter();
+other();
+counter();
+`,
+			expected: "1\n2\n1\n3\n",
+		},
+		{
+			name: "closure callbacks",
+			source: `
+fun forEach(n, callback) {
+  for (var i = 0; i < n; i = i + 1) {
+    callback(i);
+  }
+}
+
+fun run() {
+  var total = 0;
+  fun add(i) {
+    total = total + i;
+  }
+  forEach(4, add);
+  return total;
+}
+
+print run();
+`,
+			expected: "6\n",
+		},
+		{
+			name: "nested closures",
+			source: `
+fun outer() {
+  var x = "outer";
+  fun middle() {
+    fun inner() {
+      print x;
+    }
+    return inner;
+  }
+  return middle;
+}
+
+var middle = outer();
+var inner = middle();
+inner();
+`,
+			expected: "outer\n",
+		},
+		{
+			name: "closures resolve statically",
+			source: `
+var a = "global";
+{
+  fun showA() {
+    print a;
+  }
+
+  showA();
+  var a = "block";
+  showA();
+}
+`,
+			expected: "global\nglobal\n",
+		},
+		{
+			name: "bound method stored and called elsewhere",
+			source: `
+class Greeter {
+  init(name) {
+    this.name = name;
+  }
+  greet() {
+    print "hi " + this.name;
+  }
+}
+
+fun call(f) {
+  var name = "caller";
+  f();
+}
+
+call(Greeter("bob").greet);
+`,
+			expected: "hi bob\n",
+		},
+		{
+			name: "anonymous function as an argument",
+			source: `
+fun thrice(fn) {
+  for (var i = 1; i <= 3; i = i + 1) {
+    fn(i);
+  }
+}
+
+thrice(fun (a) {
+  print a;
+});
+`,
+			expected: "1\n2\n3\n",
+		},
+		{
+			name: "anonymous function as a variable initializer and return value",
+			source: `
+var add = fun (a, b) { return a + b; };
+print add(1, 2);
+
+fun adder(n) {
+  return fun (x) { return x + n; };
+}
+print adder(10)(5);
+`,
+			expected: "3\n15\n",
+		},
+		{
+			name: "anonymous function as an expression statement",
+			source: `
+fun () {
+  print "called";
+};
+(fun () { print "called immediately"; })();
+`,
+			expected: "called immediately\n",
+		},
+		{
+			name: "printing an anonymous function",
+			source: `
+var f = fun () {};
+print f;
+`,
+			expected: "<fn anonymous@line 2>\n",
+		},
+		{
+			name: "break out of a while loop",
+			source: `
+var i = 0;
+while (true) {
+  if (i == 3) break;
+  print i;
+  i = i + 1;
+}
+print "done";
+`,
+			expected: "0\n1\n2\ndone\n",
+		},
+		{
+			name: "continue in a for loop still runs the increment",
+			source: `
+for (var i = 0; i < 6; i = i + 1) {
+  if (i == 1 or i == 4) continue;
+  print i;
+}
+`,
+			expected: "0\n2\n3\n5\n",
+		},
+		{
+			name: "break only leaves the innermost loop",
+			source: `
+for (var i = 0; i < 3; i = i + 1) {
+  for (var j = 0; j < 3; j = j + 1) {
+    if (j == 1) break;
+    print i + ":" + j;
+  }
+}
+`,
+			expected: "0:0\n1:0\n2:0\n",
+		},
+		{
+			name: "break inside a function inside a loop",
+			source: `
+fun find(target) {
+  var found = nil;
+  for (var i = 0; i < 10; i = i + 1) {
+    if (i * i == target) {
+      found = i;
+      break;
+    }
+  }
+  return found;
+}
+while (true) {
+  print find(49);
+  break;
+}
+`,
+			expected: "7\n",
+		},
+		{
+			name: "list literals and printing",
+			source: `
+print [];
+print [1, "two", [3, nil], true];
+`,
+			expected: "[]\n[1, \"two\", [3, nil], true]\n",
+		},
+		{
+			name: "list indexing and index assignment",
+			source: `
+var xs = [10, 20, 30];
+print xs[0] + xs[2];
+xs[1] = xs[1] + 1;
+print xs[1];
+print xs[1] = "x";
+print xs;
+`,
+			expected: "40\n21\nx\n[10, \"x\", 30]\n",
+		},
+		{
+			name: "list natives",
+			source: `
+var xs = [];
+for (var i = 0; i < 5; i = i + 1) {
+  push(xs, i * i);
+}
+print len(xs);
+print pop(xs);
+print xs;
+print slice(xs, 1, 3);
+print len("four");
+`,
+			expected: "5\n16\n[0, 1, 4, 9]\n[1, 4]\n4\n",
+		},
+		{
+			name: "lists are shared by reference and compared by identity",
+			source: `
+var a = [1];
+var b = a;
+push(b, 2);
+print a;
+print a == b;
+print [1] == [1];
+`,
+			expected: "[1, 2]\ntrue\nfalse\n",
+		},
+		{
+			name: "lists that contain themselves print as [...] there",
+			source: `
+var l = [1];
+l[0] = l;
+print l;
+var outer = [l, l];
+print outer;
+print "list: " + len(l);
+`,
+			expected: "[[...]]\n[[[...]], [[...]]]\nlist: 1\n",
+		},
+		{
+			name: "unary operators",
+			source: `
+print -3 + 1;
+print !true;
+`,
+			expected: "-2\nfalse\n",
+		},
+		{
+			name: "map literals keep insertion order",
+			source: `
+var m = {"b": 1, "a": 2, 3: "three"};
+print m;
+print {};
+print keys(m);
+print values(m);
+`,
+			expected: "{\"b\": 1, \"a\": 2, 3: \"three\"}\n{}\n[\"b\", \"a\", 3]\n[1, 2, \"three\"]\n",
+		},
+		{
+			name: "maps that contain themselves print as {...} there",
+			source: `
+var m = {};
+m["self"] = m;
+print m;
+var l = [m];
+m["list"] = l;
+print l;
+`,
+			expected: "{\"self\": {...}}\n[{\"self\": {...}, \"list\": [...]}]\n",
+		},
+		{
+			name: "map index get and set",
+			source: `
+var counts = {};
+var words = ["a", "b", "a", "c", "a"];
+for (var i = 0; i < len(words); i = i + 1) {
+  var word = words[i];
+  if (has(counts, word)) {
+    counts[word] = counts[word] + 1;
+  } else {
+    counts[word] = 1;
+  }
+}
+print counts;
+print len(counts);
+`,
+			expected: "{\"a\": 3, \"b\": 1, \"c\": 1}\n3\n",
+		},
+		{
+			name: "map delete",
+			source: `
+var m = {"a": 1, "b": 2, "c": 3};
+print delete(m, "b");
+print delete(m, "b");
+print m;
+m["b"] = 4;
+print m;
+`,
+			expected: "true\nfalse\n{\"a\": 1, \"c\": 3}\n{\"a\": 1, \"c\": 3, \"b\": 4}\n",
+		},
+		{
+			name: "map keys follow Lox equality",
+			source: `
+var nan = 0 / 0;
+var m = {};
+m[nan] = "first";
+m[nan] = "second";
+m[0] = "zero";
+m[-0] = "negative zero";
+m["1"] = "string";
+m[1] = "number";
+print m;
+`,
+			expected: "{NaN: \"second\", 0: \"negative zero\", \"1\": \"string\", 1: \"number\"}\n",
+		},
+		{
+			name: "a brace at the start of a statement is a block",
+			source: `
+{
+  print "block";
+}
+({"a": 1});
+print {"a": 1}["a"];
+`,
+			expected: "block\n1\n",
+		},
+		{
+			name: "throw and catch a value",
+			source: `
+try {
+  print "before";
+  throw "oops";
+  print "unreachable";
+} catch (e) {
+  print "caught " + e;
+}
+`,
+			expected: "before\ncaught oops\n",
+		},
+		{
+			name: "exceptions unwind through function calls",
+			source: `
+fun check(n) {
+  if (n > 2) throw {"code": n};
+  return n;
+}
+
+fun run() {
+  for (var i = 0; i < 5; i = i + 1) {
+    print check(i);
+  }
+}
+
+try {
+  run();
+} catch (err) {
+  print err["code"];
+}
+`,
+			expected: "0\n1\n2\n3\n",
+		},
+		{
+			name: "runtime errors are catchable",
+			source: `
+try {
+  print 1 / 0;
+} catch (e) {
+  print e.message;
+  print e.line;
+}
+try {
+  print "a" - 1;
+} catch (e) {
+  print e.message;
+}
+try {
+  print missing;
+} catch (e) {
+  print e.message;
+}
+`,
+			expected: "Cannot divide by zero.\n3\nOperand must be a number\nUndefined variable: missing.\n",
+		},
+		{
+			name: "finally runs on every path",
+			source: `
+try {
+  print "try";
+} finally {
+  print "finally 1";
+}
+
+try {
+  throw "error";
+} catch (e) {
+  print "catch";
+} finally {
+  print "finally 2";
+}
+
+try {
+  try {
+    throw "inner";
+  } finally {
+    print "finally 3";
+  }
+} catch (e) {
+  print "outer caught " + e;
+}
+`,
+			expected: "try\nfinally 1\ncatch\nfinally 2\nfinally 3\nouter caught inner\n",
+		},
+		{
+			name: "return inside try still runs finally",
+			source: `
+fun f() {
+  try {
+    return "returned";
+  } finally {
+    print "cleanup";
+  }
+}
+print f();
+
+fun g() {
+  try {
+    return "ignored";
+  } finally {
+    return "overridden";
+  }
+}
+print g();
+`,
+			expected: "cleanup\nreturned\noverridden\n",
+		},
+		{
+			name: "break inside try runs finally and is not caught",
+			source: `
+while (true) {
+  try {
+    break;
+  } catch (e) {
+    print "not reached";
+  } finally {
+    print "finally";
+  }
+}
+print "after";
+`,
+			expected: "finally\nafter\n",
+		},
+	}
+
+	for _, tc := range tests {
+		for _, backend := range backends {
+			t.Run(tc.name+"/"+string(backend), func(t *testing.T) {
+				// Given:
+				buf := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(buf), WithStderr(io.Discard), WithBackend(backend))
+
+				// When:
+				err := engine.run("", []byte(tc.source))
+				if err != nil {
+					t.Errorf("%v has an unexpected err:\nerror:\n%v\n", tc.name, err)
+
+					return
+				}
+
+				b, err := ioutil.ReadAll(buf)
+				if err != nil {
+					t.Errorf("%v has an unexpected err:\nerror:\n%v\n", tc.name, err)
+					return
+				}
+				actual := string(b)
+
+				// Then:
+				if len(tc.expected) != 0 {
+					assert.Equal(t, tc.expected, actual)
+				} else {
+					assert.Regexp(t, tc.regex, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestInterpreterIntegrationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+		// Whether wantErr is the whole error, rather than part of it.
+		exact bool
+	}{
+		{
+			name: "this outside of a class",
+			source: `
+fun notAMethod() {
+  return this;
+}
+`,
+			wantErr: "Can't use 'this' outside of a class",
+		},
+		{
+			name: "return a value from an initializer",
+			source: `
+class Foo {
+  init() {
+    return "something else";
+  }
+}
+`,
+			wantErr: "Can't return a value from an initializer",
+		},
+		{
+			name: "undefined property",
+			source: `
+class Foo {}
+Foo().bar;
+`,
+			wantErr: "Undefined property 'bar'.",
+		},
+		{
+			name:    "property on a non-instance",
+			source:  `"str".length;`,
+			wantErr: "Only instances have properties.",
+		},
+		{
+			name:    "wrong number of initializer arguments",
+			source:  `class Foo { init(a) {} } Foo();`,
+			wantErr: "Expected 1 argument but got 0.",
+		},
+		{
+			name:    "wrong number of native arguments",
+			source:  `len(1, 2);`,
+			wantErr: "1:9: error[E0305]: Expected 1 argument but got 2.",
+			exact:   true,
+		},
+		{
+			name:    "class inheriting from itself",
+			source:  `class Oops < Oops {}`,
+			wantErr: "A class can't inherit from itself",
+		},
+		{
+			name: "super outside of a class",
+			source: `
+fun notAMethod() {
+  return super.method();
+}
+`,
+			wantErr: "Can't use 'super' outside of a class",
+		},
+		{
+			name: "super in a class without a superclass",
+			source: `
+class Base {
+  method() {
+    return super.method();
+  }
+}
+`,
+			wantErr: "Can't use 'super' in a class with no superclass",
+		},
+		{
+			name: "superclass that is not a class",
+			source: `
+var NotAClass = "I am totally not a class";
+class Subclass < NotAClass {}
+`,
+			wantErr: "Superclass must be a class.",
+		},
+		{
+			name: "undefined superclass method",
+			source: `
+class A {}
+class B < A {
+  method() {
+    super.missing();
+  }
+}
+B().method();
+`,
+			wantErr: "Undefined property 'missing'.",
+		},
+		{
+			name:    "break outside of a loop",
+			source:  `break;`,
+			wantErr: "Can't use 'break' outside of a loop",
+		},
+		{
+			name: "continue in a function declared inside a loop",
+			source: `
+while (true) {
+  fun f() {
+    continue;
+  }
+}
+`,
+			wantErr: "Can't use 'continue' outside of a loop",
+		},
+		{
+			name:    "negative list index",
+			source:  `var xs = [1, 2]; print xs[-1];`,
+			wantErr: "List index must not be negative, got -1.",
+		},
+		{
+			name:    "list index out of range",
+			source:  `var xs = [1, 2]; xs[2] = 3;`,
+			wantErr: "List index 2 out of range for list of length 2.",
+		},
+		{
+			name:    "pop from an empty list",
+			source:  `pop([]);`,
+			wantErr: "Can't pop from an empty list.",
+		},
+		{
+			name:    "missing map key",
+			source:  `var m = {"a": 1}; print m["b"];`,
+			wantErr: "Undefined map key b.",
+		},
+		{
+			name:    "unsupported map key",
+			source:  `var m = {}; m[true] = 1;`,
+			wantErr: "1:19: error[E0300]: Map keys must be strings or numbers, got boolean.",
+			exact:   true,
+		},
+		{
+			name:    "list index of the wrong type",
+			source:  `var l = [1]; print l["a"];`,
+			wantErr: "1:25: error[E0300]: List index must be a number, got string.",
+			exact:   true,
+		},
+		{
+			name:    "indexing a number",
+			source:  `var n = 1; print n[0];`,
+			wantErr: "1:21: error[E0300]: Only lists and maps can be indexed, got number.",
+			exact:   true,
+		},
+		{
+			name:    "native called with the wrong type",
+			source:  `push({}, 1);`,
+			wantErr: "1:11: error[E0300]: push() expects a list, got map.",
+			exact:   true,
+		},
+		{
+			name:    "calling a string",
+			source:  `"a"();`,
+			wantErr: "1:5: error[E0304]: Can only call functions and classes. Callee is unexpected type: string.",
+			exact:   true,
+		},
+		{
+			name:    "adding a function",
+			source:  `fun f() {} print f + 1;`,
+			wantErr: "1:20: error[E0303]: operands must be both numbers, both strings, or at least one number and a string. Got function and number.",
+			exact:   true,
+		},
+		{
+			name:    "uncaught throw",
+			source:  `throw "boom";`,
+			wantErr: "Uncaught exception: boom",
+		},
+		{
+			name:    "try without catch or finally",
+			source:  `try { print 1; }`,
+			wantErr: "Expect catch or finally after try block",
+		},
+		{
+			name:    "assigning an undeclared global",
+			source:  `undeclared = 1;`,
+			wantErr: "Cannot assign undeclared variable: 'undeclared'.",
+		},
+		{
+			name:    "errors carry their position and code",
+			source:  "var a = 1;\nprint a +;",
+			wantErr: "2:10: error[E0101]: Expect expression.",
+		},
+		{
+			name:    "runtime errors carry their position and code",
+			source:  "var a = 1;\nprint a + nope;",
+			wantErr: "2:11: error[E0301]: Undefined variable: nope.",
+		},
+		{
+			name:    "every syntax error is reported in one pass",
+			source:  "print 1 +;\nprint 2;\nvar = 3;",
+			wantErr: "1:10: error[E0101]: Expect expression.\n3:5: error[E0100]: Expect variable name.",
+		},
+		{
+			name:   "runtime errors carry the Lox stack trace",
+			source: "fun inner() {\n  return 1 + nil;\n}\nfun outer() {\n  return inner();\n}\nouter();",
+			wantErr: "2:12: error[E0303]: operands must be both numbers, both strings, or at least one number and a string. Got number and nil.\n" +
+				"note: stack trace:\n" +
+				"  at inner (2:12)\n" +
+				"  at outer (5:16)\n" +
+				"  at <script> (7:7)",
+			exact: true,
+		},
+		{
+			name:   "uncaught exceptions carry the Lox stack trace",
+			source: "class Oops {\n  init() {\n    throw \"oops\";\n  }\n}\nOops();",
+			wantErr: "3:5: error[E0308]: Uncaught exception: oops\n" +
+				"note: stack trace:\n" +
+				"  at Oops (3:5)\n" +
+				"  at <script> (6:6)",
+		},
+		{
+			name:   "unbounded recursion overflows the stack cleanly",
+			source: "fun loop(n) {\n  return loop(n + 1);\n}\nloop(0);",
+			wantErr: "2:20: error[E0309]: Stack overflow.\n" +
+				"note: stack trace:\n" +
+				"  at loop (2:20)\n" +
+				"  at loop (2:20)\n" +
+				"  at loop (2:20)\n" +
+				"  [previous line repeated 9997 more times]\n" +
+				"  at <script> (4:7)",
+		},
+		{
+			name:    "operator errors point at the operator",
+			source:  "var a = 1;\nprint a < \"b\";",
+			wantErr: "2:9: error[E0303]: Operand must be a number",
+			exact:   true,
+		},
+		{
+			name:    "operator errors point at the operator across lines",
+			source:  "print (1 +\n  nil);",
+			wantErr: "1:10: error[E0303]: operands must be both numbers, both strings, or at least one number and a string. Got number and nil.",
+			exact:   true,
+		},
+		{
+			name:    "every resolver error is reported in one pass",
+			source:  "print this;\nfun f() { break; }\nclass A < A {}",
+			wantErr: "1:7: error[E0207]: Can't use 'this' outside of a class.\n2:11: error[E0205]: Can't use 'break' outside of a loop.\n3:11: error[E0202]: A class can't inherit from itself.",
+		},
+		{
+			name:    "every resolver error in an expression is reported",
+			source:  "print this + super.x;\nprint [this, {super.y: 1}];",
+			wantErr: "1:7: error[E0207]: Can't use 'this' outside of a class.\n1:14: error[E0208]: Can't use 'super' outside of a class.\n2:8: error[E0207]: Can't use 'this' outside of a class.\n2:15: error[E0208]: Can't use 'super' outside of a class.",
+			exact:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		for _, backend := range backends {
+			t.Run(tc.name+"/"+string(backend), func(t *testing.T) {
+				// Given:
+				buf := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(buf), WithStderr(io.Discard), WithBackend(backend))
+
+				// When:
+				err := engine.run("", []byte(tc.source))
+
+				// Then:
+				if tc.exact {
+					assert.EqualError(t, err, tc.wantErr)
+				} else if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name         string
+		source       string
+		expected     string
+		wantWarnings string
+	}{
+		{
+			name:         "unused locals and parameters",
+			source:       "fun f(a, _b) {\n  var c = 1;\n  var _d = 2;\n}\nf(1, 2);",
+			wantWarnings: "1:7: warning[W0002]: Parameter 'a' is never used.\n2:7: warning[W0001]: Local variable 'c' is never used.",
+		},
+		{
+			name:         "assigning isn't using",
+			source:       "{\n  var a = 1;\n  a = 2;\n}",
+			wantWarnings: "2:7: warning[W0001]: Local variable 'a' is never used.",
+		},
+		{
+			name:     "closures use the variables they capture",
+			source:   "fun counter() {\n  var count = 0;\n  return fun() { count = count + 1; return count; };\n}\nprint counter()();",
+			expected: "1\n",
+		},
+		{
+			name:         "shadowed parameters",
+			source:       "fun f(a) {\n  print a;\n  { var a = 2; print a; }\n}\nf(1);",
+			expected:     "1\n2\n",
+			wantWarnings: "3:9: warning[W0003]: Declaration of 'a' shadows a parameter.",
+		},
+		{
+			name:         "unreachable code is reported once",
+			source:       "fun f() {\n  return 1;\n  print 2;\n  print 3;\n}\nprint f();",
+			expected:     "1\n",
+			wantWarnings: "3:3: warning[W0004]: Unreachable code.",
+		},
+		{
+			name:         "top-level return ends the script",
+			source:       "print 1;\nreturn;",
+			expected:     "1\n",
+			wantWarnings: "2:1: warning[W0005]: Return outside of a function ends the script.",
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			// Given:
-			buf := new(bytes.Buffer)
-			interpreter := interpreter.NewInterpreter(buf)
+			stdout := new(bytes.Buffer)
+			engine := NewEngine(WithStdout(stdout), WithStderr(new(bytes.Buffer)))
+			strict := NewEngine(WithStdout(new(bytes.Buffer)), WithWarningsAsErrors(true))
 
 			// When:
-			err := run([]byte(tc.source), interpreter)
-			if err != nil {
-				t.Errorf("%v has an unexpected err:\nerror:\n%v\n", tc.name, err)
+			err := engine.run("", []byte(tc.source))
+			strictErr := strict.run("", []byte(tc.source))
 
+			// Then:
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, stdout.String())
+			if tc.wantWarnings == "" {
+				assert.NoError(t, strictErr)
 				return
 			}
+			if assert.Error(t, strictErr) {
+				assert.Equal(t, strings.ReplaceAll(tc.wantWarnings, "warning[", "error["), strictErr.Error())
+			}
+		})
+	}
+}
 
-			b, err := ioutil.ReadAll(buf)
-			if err != nil {
-				t.Errorf("%v has an unexpected err:\nerror:\n%v\n", tc.name, err)
-				return
+func TestImports(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		main     string
+		glox     string
+		expected string
+		wantErr  string
+	}{
+		{
+			name: "import everything",
+			files: map[string]string{
+				"lib.lox": `
+var greeting = "hello";
+fun greet(name) {
+  return greeting + " " + name;
+}
+`,
+			},
+			main: `
+import "lib.lox";
+print greet("world");
+print greeting;
+`,
+			expected: "hello world\nhello\n",
+		},
+		{
+			name: "import selected names",
+			files: map[string]string{
+				"lib.lox": `
+var a = 1;
+var b = 2;
+var c = 3;
+`,
+			},
+			main: `
+import { a, c } from "lib.lox";
+print a + c;
+print b;
+`,
+			wantErr: "Undefined variable: b.",
+		},
+		{
+			name: "modules have their own globals",
+			files: map[string]string{
+				"counter.lox": `
+var count = 0;
+fun increment() {
+  count = count + 1;
+  return count;
+}
+`,
+			},
+			main: `
+var count = 100;
+import { increment } from "counter.lox";
+increment();
+print increment();
+print count;
+`,
+			expected: "2\n100\n",
+		},
+		{
+			name: "modules run once",
+			files: map[string]string{
+				"lib.lox":       `print "loading lib";`,
+				"other/mid.lox": `import "../lib.lox";`,
+			},
+			main: `
+import "lib.lox";
+import "other/mid.lox";
+import "lib.lox";
+print "done";
+`,
+			expected: "loading lib\ndone\n",
+		},
+		{
+			name: "paths fall back to GLOX_PATH",
+			files: map[string]string{
+				"shared/util.lox": `fun twice(x) { return x * 2; }`,
+			},
+			glox: "shared",
+			main: `
+import { twice } from "util.lox";
+print twice(21);
+`,
+			expected: "42\n",
+		},
+		{
+			name: "missing export",
+			files: map[string]string{
+				"lib.lox": `var a = 1;`,
+			},
+			main:    `import { nope } from "lib.lox";`,
+			wantErr: "has no export 'nope'.",
+		},
+		{
+			name:    "missing module",
+			main:    `import "missing.lox";`,
+			wantErr: `Module "missing.lox" not found`,
+		},
+		{
+			name: "import cycle",
+			files: map[string]string{
+				"a.lox": `import "b.lox";`,
+				"b.lox": `import "main.lox";`,
+			},
+			main:    `import "a.lox";`,
+			wantErr: "main.lox -> ",
+		},
+		{
+			name:    "import outside of the top level",
+			main:    `{ import "lib.lox"; }`,
+			wantErr: "Can't import outside of the top level",
+		},
+	}
+
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					// Given:
+					dir := t.TempDir()
+					for name, source := range tc.files {
+						path := filepath.Join(dir, name)
+						if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+							t.Fatal(err)
+						}
+						if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+							t.Fatal(err)
+						}
+					}
+					mainPath := filepath.Join(dir, "main.lox")
+					if err := os.WriteFile(mainPath, []byte(tc.main), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					gloxPath := ""
+					if tc.glox != "" {
+						gloxPath = filepath.Join(dir, tc.glox)
+					}
+					t.Setenv("GLOX_PATH", gloxPath)
+
+					buf := new(bytes.Buffer)
+					engine := NewEngine(WithStdout(buf), WithStderr(io.Discard), WithBackend(backend))
+
+					// When:
+					err := engine.RunFile(mainPath)
+
+					// Then:
+					if tc.wantErr != "" {
+						if assert.Error(t, err) {
+							assert.Contains(t, err.Error(), tc.wantErr)
+						}
+						return
+					}
+					if err != nil {
+						t.Errorf("%v has an unexpected err:\nerror:\n%v\n", tc.name, err)
+						return
+					}
+					assert.Equal(t, tc.expected, buf.String())
+				})
 			}
-			actual := string(b)
 
-			// Then:
-			if len(tc.expected) != 0 {
-				assert.Equal(t, tc.expected, actual)
-			} else {
-				assert.Regexp(t, tc.regex, actual)
+			t.Run("files run one after the other don't see each other as loading", func(t *testing.T) {
+				// Given:
+				dir := t.TempDir()
+				first := filepath.Join(dir, "a1.lox")
+				second := filepath.Join(dir, "b1.lox")
+				assert.NoError(t, os.WriteFile(first, []byte(`var a = "a1";`), 0o644))
+				assert.NoError(t, os.WriteFile(second, []byte(`import { a } from "a1.lox"; print a;`), 0o644))
+				t.Setenv("GLOX_PATH", "")
+				buf := new(bytes.Buffer)
+				engine := NewEngine(WithStdout(buf), WithStderr(io.Discard), WithBackend(backend))
+
+				// When:
+				err := engine.RunFile(first)
+				assert.NoError(t, err)
+				err = engine.RunFile(second)
+
+				// Then:
+				assert.NoError(t, err)
+				assert.Equal(t, "a1\n", buf.String())
+				// Code run afterwards resolves its imports from the working
+				// directory again.
+				_, err = engine.Eval(`import "b1.lox";`)
+				assert.ErrorContains(t, err, `Module "b1.lox" not found, searched: b1.lox.`)
+			})
+		})
+	}
+}
+
+// Local variables are read and written on every iteration, several scopes
+// away from where they are declared. Run with `go test -bench . ./pkg/lox`.
+const loopBenchmark = `
+fun sum(n) {
+  var total = 0;
+  for (var i = 0; i < n; i = i + 1) {
+    var j = i;
+    while (j > 0 and j > i - 3) {
+      total = total + j;
+      j = j - 1;
+    }
+  }
+  return total;
+}
+sum(20000);
+`
+
+// The same loop over globals, which are still looked up by name in a map.
+// Locals used to be looked up by name too, in a map per scope, so this shows
+// roughly what their slots save.
+const globalLoopBenchmark = `
+var total;
+var i;
+var j;
+fun sum(n) {
+  total = 0;
+  for (i = 0; i < n; i = i + 1) {
+    j = i;
+    while (j > 0 and j > i - 3) {
+      total = total + j;
+      j = j - 1;
+    }
+  }
+  return total;
+}
+sum(20000);
+`
+
+func BenchmarkLoop(b *testing.B) {
+	for _, backend := range backends {
+		for _, bench := range []struct {
+			name   string
+			script string
+		}{
+			{"locals-by-slot", loopBenchmark},
+			{"globals-by-name", globalLoopBenchmark},
+		} {
+			b.Run(string(backend)+"/"+bench.name, func(b *testing.B) {
+				engine := NewEngine(WithStdout(io.Discard), WithStderr(io.Discard), WithBackend(backend))
+				for n := 0; n < b.N; n++ {
+					_, err := engine.Eval(bench.script)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Every call returns through a return statement.
+const fibBenchmark = `
+fun fib(n) {
+  if (n < 2) return n;
+  return fib(n - 1) + fib(n - 2);
+}
+fib(20);
+`
+
+func BenchmarkFib(b *testing.B) {
+	for _, backend := range backends {
+		b.Run(string(backend), func(b *testing.B) {
+			engine := NewEngine(WithStdout(io.Discard), WithStderr(io.Discard), WithBackend(backend))
+			for n := 0; n < b.N; n++ {
+				_, err := engine.Eval(fibBenchmark)
+				if err != nil {
+					b.Fatal(err)
+				}
 			}
 		})
 	}
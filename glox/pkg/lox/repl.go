@@ -0,0 +1,288 @@
+package lox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/lineeditor"
+	"github.com/modulitos/glox/pkg/parser"
+	"github.com/modulitos/glox/pkg/scanner"
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// The prompt for a new statement, and the one for the lines continuing it.
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+)
+
+const replHelp = `Statements run as they are entered. The values of expressions are printed, and
+their trailing semicolon can be left out.
+
+Commands:
+  :help          show this help
+  :env           list the globals and their values
+  :load <file>   run a script in this session
+  :reset         forget every global defined so far
+  :ast <code>    print the syntax tree of the code without running it
+  :time <code>   run the code and print how long it took
+  :quit          end the session
+`
+
+// The commands that take an argument, and what it is.
+var commandParams = map[string]string{
+	":load": "file",
+	":ast":  "code",
+	":time": "code",
+}
+
+// RunPrompt runs the statements read from stdin, until it is exhausted. A
+// statement whose brackets or strings aren't closed yet continues on the next
+// lines. The value of an expression statement is printed. Lines starting with
+// a colon are commands, see replHelp. Errors are reported to stderr, and don't
+// end the session nor lose the globals defined so far.
+//
+// When stdin and stdout are a terminal, lines are read with a line editor,
+// which keeps their history in ~/.glox_history, and completes the keywords and
+// the globals with Tab.
+func (e *Engine) RunPrompt() error {
+	fmt.Fprintln(e.stdout, "starting up lox version 0.0.0")
+	reader := e.lineReader()
+	// The lines of a statement that isn't complete yet.
+	var pending []byte
+	for {
+		linePrompt := prompt
+		if len(pending) > 0 {
+			linePrompt = continuationPrompt
+		}
+		line, err := reader.ReadLine(linePrompt)
+		if errors.Is(err, lineeditor.ErrInterrupted) {
+			// Ctrl-C abandons the statement being typed.
+			pending = nil
+			continue
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Reading standard input: %w", err)
+		}
+		reader.AddHistory(line)
+
+		if len(pending) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if quit := e.runCommand(line); quit {
+				return nil
+			}
+			continue
+		}
+		pending = append(pending, line...)
+		if isIncomplete(pending) {
+			pending = append(pending, '\n')
+			continue
+		}
+		e.evalPrompt(pending)
+		pending = nil
+	}
+	// A statement cut short by the end of the input still runs, to report
+	// what it is missing.
+	if len(pending) > 0 {
+		e.evalPrompt(pending)
+	}
+	return nil
+}
+
+// A lineReader reads the lines typed at the prompt.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	AddHistory(line string)
+}
+
+// lineReader returns a line editor if the prompt runs in a terminal, and a
+// plain reader otherwise, like when stdin is a pipe.
+func (e *Engine) lineReader() lineReader {
+	in, inOk := e.stdin.(*os.File)
+	out, outOk := e.stdout.(*os.File)
+	if !inOk || !outOk || !lineeditor.IsTerminal(in.Fd()) || !lineeditor.IsTerminal(out.Fd()) {
+		return &plainReader{scanner: bufio.NewScanner(e.stdin), out: e.stdout}
+	}
+
+	editor := lineeditor.New(in, out)
+	editor.SetCompleter(e.completions)
+	home, err := os.UserHomeDir()
+	if err == nil {
+		err = editor.SetHistoryFile(filepath.Join(home, ".glox_history"))
+	}
+	if err != nil {
+		fmt.Fprintf(e.stderr, "The history won't be saved: %v\n", err)
+	}
+	return editor
+}
+
+// completions returns the keywords, the globals and the builtins starting with
+// word.
+func (e *Engine) completions(word string) []string {
+	names := e.globalNames()
+	names = append(names, e.builtinNames()...)
+	for keyword := range token.Keywords {
+		names = append(names, keyword)
+	}
+	var completions []string
+	for _, name := range names {
+		if strings.HasPrefix(name, word) {
+			completions = append(completions, name)
+		}
+	}
+	return completions
+}
+
+// plainReader reads lines as they come, showing the prompt before each one.
+type plainReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *plainReader) AddHistory(line string) {}
+
+// evalPrompt runs a statement entered at the prompt, and prints its value if
+// it is an expression statement whose value isn't nil.
+func (e *Engine) evalPrompt(source []byte) {
+	value, err := e.eval(e.promptSourceName(), completeStatement(source))
+	if err != nil {
+		e.printer.Print(err)
+		return
+	}
+	if value != nil {
+		fmt.Fprintln(e.stdout, e.inspect(value))
+	}
+}
+
+// promptSourceName names the next source entered at the prompt, like
+// <repl:1> for the first one.
+func (e *Engine) promptSourceName() string {
+	e.promptEntries++
+	return fmt.Sprintf("<repl:%d>", e.promptEntries)
+}
+
+// runCommand runs a line starting with a colon, and reports whether it ends
+// the session.
+func (e *Engine) runCommand(line string) (quit bool) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
+	if param, ok := commandParams[name]; ok && arg == "" {
+		fmt.Fprintf(e.stderr, "Usage: %s <%s>\n", name, param)
+		return false
+	}
+
+	switch name {
+	case ":help":
+		fmt.Fprint(e.stdout, replHelp)
+	case ":quit":
+		return true
+	case ":env":
+		for _, global := range e.globalNames() {
+			value, _ := e.getGlobal(global)
+			fmt.Fprintf(e.stdout, "%s = %s\n", global, e.inspect(value))
+		}
+	case ":load":
+		err := e.RunFile(arg)
+		if err != nil {
+			e.printer.Print(err)
+		}
+	case ":reset":
+		e.reset()
+		fmt.Fprintln(e.stdout, "Forgot every global.")
+	case ":ast":
+		statements, err := e.parse(e.promptSourceName(), completeStatement([]byte(arg)))
+		if err != nil {
+			e.printer.Print(err)
+			return false
+		}
+		printer := ast.AstPrint{}
+		tree, err := printer.PrintStmts(statements)
+		if err != nil {
+			e.printer.Print(err)
+			return false
+		}
+		fmt.Fprint(e.stdout, tree)
+	case ":time":
+		start := time.Now()
+		e.evalPrompt([]byte(arg))
+		fmt.Fprintf(e.stdout, "Took %s.\n", time.Since(start).Round(time.Microsecond))
+	default:
+		fmt.Fprintf(e.stderr, "Unknown command %s, see :help.\n", name)
+	}
+	return false
+}
+
+// isIncomplete tells whether the source ends inside brackets, or a string,
+// that the next lines may close.
+func isIncomplete(source []byte) bool {
+	s := scanner.NewScanner(source)
+	tokens, err := s.ScanTokens()
+	if err != nil {
+		for _, d := range diagnostic.From(err) {
+			if d.Code == diagnostic.UnterminatedString {
+				return true
+			}
+		}
+		return false
+	}
+	depth := 0
+	for _, t := range tokens {
+		switch t.TokenType {
+		case token.LeftParen, token.LeftBrace, token.LeftBracket:
+			depth++
+		case token.RightParen, token.RightBrace, token.RightBracket:
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// completeStatement adds the semicolon left out after the last token of the
+// source, so that `1 + 2` runs like `1 + 2;` does. Source that only parses
+// without it, like a block, is left as it is.
+func completeStatement(source []byte) []byte {
+	s := scanner.NewScanner(source)
+	tokens, err := s.ScanTokens()
+	// The last token is always the end of the file.
+	if err != nil || len(tokens) < 2 {
+		return source
+	}
+	last := tokens[len(tokens)-2]
+	if last.TokenType == token.Semicolon {
+		return source
+	}
+	completed := make([]byte, 0, len(source)+1)
+	completed = append(completed, source[:last.End]...)
+	completed = append(completed, ';')
+	completed = append(completed, source[last.End:]...)
+
+	s = scanner.NewScanner(completed)
+	tokens, err = s.ScanTokens()
+	if err != nil {
+		return source
+	}
+	p := parser.Parser{Tokens: tokens}
+	if _, err := p.Parse(); err != nil {
+		return source
+	}
+	return completed
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
 	"github.com/modulitos/glox/pkg/token"
 )
 
@@ -14,14 +15,14 @@ const maxFuncArgCounts = 255
 type Parser struct {
 	Tokens  []*token.Token
 	current int
-}
 
-type ParserError struct {
-	err error
-}
+	// How many braces are open at the current token, and how many were open
+	// inside each enclosing block. They let synchronize stop at the brace
+	// closing a block, rather than at one closing a map literal.
+	braces int
+	blocks []int
 
-func (e ParserError) Error() string {
-	return fmt.Sprintf("ParserError: %v", e.err)
+	errors diagnostic.List
 }
 
 // ----------------------------------------------------------------------------
@@ -42,6 +43,12 @@ func (p *Parser) previous() *token.Token {
 func (p *Parser) advance() *token.Token {
 	if !p.isAtEnd() {
 		p.current += 1
+		switch p.previous().TokenType {
+		case token.LeftBrace:
+			p.braces++
+		case token.RightBrace:
+			p.braces--
+		}
 	}
 	return p.previous()
 }
@@ -54,6 +61,13 @@ func (p *Parser) check(tokenType token.Type) bool {
 	}
 }
 
+func (p *Parser) checkNext(tokenType token.Type) bool {
+	if p.isAtEnd() || p.Tokens[p.current+1].TokenType == token.Eof {
+		return false
+	}
+	return tokenType == p.Tokens[p.current+1].TokenType
+}
+
 func (p *Parser) match(types ...token.Type) bool {
 	for _, tokenType := range types {
 		if p.check(tokenType) {
@@ -64,17 +78,53 @@ func (p *Parser) match(types ...token.Type) bool {
 	return false
 }
 
-func (p *Parser) consume(tokenType token.Type) (*token.Token, error) {
+// consume advances past the next token if it has the given type, and otherwise
+// reports message as an error on it.
+func (p *Parser) consume(tokenType token.Type, message string) (*token.Token, error) {
 	if p.check(tokenType) {
 		return p.advance(), nil
 	} else {
-		actual := p.peek()
-		return nil, fmt.Errorf("wanted token type: %s, got: %s, at line: %d", tokenType, actual, actual.Line)
+		return nil, p.errorAt(p.peek(), diagnostic.ExpectedToken, message)
+	}
+}
+
+// errorAt reports an error on the given token, labelled with what was found
+// there instead.
+func (p *Parser) errorAt(actual *token.Token, code diagnostic.Code, message string) error {
+	found := fmt.Sprintf("found '%s'", actual.Lexeme)
+	if actual.TokenType == token.Eof {
+		found = "found end of file"
 	}
+	return diagnostic.Errorf(code, actual.Span(), "%s", message).WithLabel(found)
 }
 
-// Discards tokens until it think it has found a statement boundary.
+// unclosed points an error about a missing closing delimiter back at the
+// opening one.
+func unclosed(err error, open *token.Token) error {
+	if d, ok := err.(*diagnostic.Diagnostic); ok {
+		d.WithSecondary(open.Span(), fmt.Sprintf("to match this '%s'", open.Lexeme))
+	}
+	return err
+}
+
+// report records a syntax error. An error on the same line as the previous
+// one is most likely caused by it, so it is dropped, like go/parser does.
+func (p *Parser) report(err error) {
+	for _, d := range diagnostic.From(err) {
+		if n := len(p.errors); n > 0 && p.errors[n-1].Span().Line == d.Span().Line {
+			continue
+		}
+		p.errors.Add(d)
+	}
+}
+
+// Discards tokens until it think it has found a statement boundary. The brace
+// closing the enclosing block is a boundary too, so that the block still ends
+// where it should.
 func (p *Parser) synchronize() {
+	if p.closesBlock() {
+		return
+	}
 	p.advance()
 
 	for !p.isAtEnd() {
@@ -83,7 +133,10 @@ func (p *Parser) synchronize() {
 		}
 
 		switch p.peek().TokenType {
-		case token.Class, token.Fun, token.Var, token.For, token.If, token.While, token.Print, token.Return:
+		case token.Class, token.Fun, token.Var, token.For, token.If, token.While, token.Print, token.Return, token.Break, token.Continue, token.Throw, token.Try, token.Import:
+			return
+		}
+		if p.closesBlock() {
 			return
 		}
 
@@ -91,19 +144,40 @@ func (p *Parser) synchronize() {
 	}
 }
 
+func (p *Parser) closesBlock() bool {
+	return p.check(token.RightBrace) && len(p.blocks) > 0 && p.braces == p.blocks[len(p.blocks)-1]
+}
+
 // ----------------------------------------------------------------------------
 // Types
 
-// declaration → funDecl | varDecl | statement;
-func (p *Parser) declaration() (stmt ast.Stmt, err error) {
-	defer func() {
-		if err != nil {
-			p.synchronize()
-			return
+// declaration → classDecl | funDecl | varDecl | statement;
+//
+// A declaration with a syntax error is reported and replaced by a BadStmt, and
+// parsing picks up again at the next statement.
+func (p *Parser) declaration() ast.Stmt {
+	from := p.peek()
+	stmt, err := p.parseDeclaration()
+	if err != nil {
+		p.report(err)
+		p.synchronize()
+		to := from
+		if p.current > 0 && p.previous().Start >= from.Start {
+			to = p.previous()
 		}
-	}()
+		return &ast.BadStmt{From: from, To: to}
+	}
+	return stmt
+}
 
-	if p.match(token.Fun) {
+func (p *Parser) parseDeclaration() (stmt ast.Stmt, err error) {
+	if p.match(token.Class) {
+		return p.classDeclaration()
+	}
+	// A `fun` not followed by a name starts an anonymous function, which is an
+	// expression, so we leave it for the statement rules.
+	if p.check(token.Fun) && p.checkNext(token.Identifier) {
+		p.advance()
 		return p.function("function")
 	}
 	if p.match(token.Var) {
@@ -112,32 +186,111 @@ func (p *Parser) declaration() (stmt ast.Stmt, err error) {
 	return p.statement()
 }
 
+// classDecl → "class" IDENTIFIER ( "<" IDENTIFIER )? "{" function* "}" ;
+func (p *Parser) classDeclaration() (stmt ast.Stmt, err error) {
+	keyword := p.previous()
+	name, err := p.consume(token.Identifier, "Expect class name.")
+	if err != nil {
+		return
+	}
+
+	var superclass *ast.VariableExpr
+	if p.match(token.Less) {
+		var superclassName *token.Token
+		superclassName, err = p.consume(token.Identifier, "Expect superclass name.")
+		if err != nil {
+			return
+		}
+		superclass = &ast.VariableExpr{
+			Name: superclassName,
+		}
+	}
+	_, err = p.consume(token.LeftBrace, "Expect '{' before class body.")
+	if err != nil {
+		return
+	}
+
+	var methods []*ast.FunctionStmt
+	for !p.check(token.RightBrace) && !p.isAtEnd() {
+		var method *ast.FunctionStmt
+		method, err = p.function("method")
+		if err != nil {
+			return
+		}
+		methods = append(methods, method)
+	}
+
+	rightBrace, err := p.consume(token.RightBrace, "Expect '}' after class body.")
+	if err != nil {
+		return
+	}
+	return &ast.ClassStmt{
+		Keyword:    keyword,
+		Name:       name,
+		Superclass: superclass,
+		Methods:    methods,
+		RightBrace: rightBrace,
+	}, nil
+}
+
 // funDecl    → "fun" function ;
-// function   → IDENTIFIER "(" parameters? ")" block ;
-// parameters → IDENTIFIER ( "," IDENTIFIER )* ;
-func (p *Parser) function(kind string) (stmt ast.Stmt, err error) {
-	name, err := p.consume(token.Identifier)
+// function   → IDENTIFIER functionBody ;
+//
+// Methods have no "fun" keyword, so their Keyword is left nil.
+func (p *Parser) function(kind string) (stmt *ast.FunctionStmt, err error) {
+	var keyword *token.Token
+	if p.previous().TokenType == token.Fun {
+		keyword = p.previous()
+	}
+	name, err := p.consume(token.Identifier, fmt.Sprintf("Expect %s name.", kind))
 	if err != nil {
-		err = fmt.Errorf("Expect %s name: %w", kind, err)
 		return
 	}
-	_, err = p.consume(token.LeftParen)
+	params, body, err := p.functionBody(kind)
+	if err != nil {
+		return
+	}
+	return &ast.FunctionStmt{
+		Keyword:    keyword,
+		Name:       name,
+		Params:     params,
+		Body:       body,
+		RightBrace: p.previous(),
+	}, nil
+}
+
+// lambda → "fun" functionBody ;
+func (p *Parser) lambda() (expr ast.Expr, err error) {
+	keyword := p.previous()
+	params, body, err := p.functionBody("anonymous function")
+	if err != nil {
+		return
+	}
+	return &ast.LambdaExpr{
+		Keyword:    keyword,
+		Params:     params,
+		Body:       body,
+		RightBrace: p.previous(),
+	}, nil
+}
+
+// functionBody → "(" parameters? ")" block ;
+// parameters   → IDENTIFIER ( "," IDENTIFIER )* ;
+func (p *Parser) functionBody(kind string) (params []*token.Token, body []ast.Stmt, err error) {
+	_, err = p.consume(token.LeftParen, fmt.Sprintf("Expect '(' after %s name.", kind))
 	if err != nil {
-		err = fmt.Errorf("Expect ( after %s name: %w", kind, err)
 		return
 	}
 
-	var params []*token.Token
 	if !p.check(token.RightParen) {
 		for {
-			if len(params) >= maxFuncArgCounts {
-				err = fmt.Errorf("Can't have more than 255 parameters.")
-				return
+			// The parser isn't confused by this, so we report it and carry on.
+			if len(params) == maxFuncArgCounts {
+				p.report(diagnostic.Errorf(diagnostic.TooManyParameters, p.peek().Span(), "Can't have more than 255 parameters."))
 			}
 			var param *token.Token
-			param, err = p.consume(token.Identifier)
+			param, err = p.consume(token.Identifier, "Expect parameter name.")
 			if err != nil {
-				err = fmt.Errorf("Expect parameter name: %w.", err)
 				return
 			}
 			params = append(params, param)
@@ -147,29 +300,21 @@ func (p *Parser) function(kind string) (stmt ast.Stmt, err error) {
 			}
 		}
 	}
-	_, err = p.consume(token.RightParen)
-	if err != nil {
-		err = fmt.Errorf("Expect ) after %s parameters: %w", kind, err)
-		return
-	}
-	_, err = p.consume(token.LeftBrace)
+	_, err = p.consume(token.RightParen, fmt.Sprintf("Expect ')' after %s parameters.", kind))
 	if err != nil {
-		err = fmt.Errorf("Expect { before %s body: %w", kind, err)
 		return
 	}
-	body, err := p.block()
+	_, err = p.consume(token.LeftBrace, fmt.Sprintf("Expect '{' before %s body.", kind))
 	if err != nil {
 		return
 	}
-	return &ast.FunctionStmt{
-		Name:   name,
-		Params: params,
-		Body:   body,
-	}, nil
+	body, err = p.block()
+	return
 }
 
 func (p *Parser) varDeclaration() (stmt ast.Stmt, err error) {
-	name, err := p.consume(token.Identifier)
+	keyword := p.previous()
+	name, err := p.consume(token.Identifier, "Expect variable name.")
 	if err != nil {
 		return
 	}
@@ -181,11 +326,12 @@ func (p *Parser) varDeclaration() (stmt ast.Stmt, err error) {
 		}
 	}
 
-	_, err = p.consume(token.Semicolon)
+	_, err = p.consume(token.Semicolon, "Expect ';' after variable declaration.")
 	if err != nil {
 		return
 	}
 	return &ast.VarStmt{
+		Keyword:     keyword,
 		Name:        name,
 		Initializer: initializer,
 	}, nil
@@ -198,8 +344,32 @@ func (p *Parser) varDeclaration() (stmt ast.Stmt, err error) {
 //	| printStmt
 //	| returnStmt
 //	| whileStmt
+//	| breakStmt
+//	| continueStmt
+//	| throwStmt
+//	| tryStmt
+//	| importStmt
 //	| block ;
+//
+// A "{" at the start of a statement always opens a block, even though "{" also
+// starts a map literal in expression position. An expression statement that
+// begins with a map literal has to wrap it in parentheses.
 func (p *Parser) statement() (stmt ast.Stmt, err error) {
+	if p.match(token.Break) {
+		return p.breakStatement()
+	}
+	if p.match(token.Continue) {
+		return p.continueStatement()
+	}
+	if p.match(token.Throw) {
+		return p.throwStatement()
+	}
+	if p.match(token.Try) {
+		return p.tryStatement()
+	}
+	if p.match(token.Import) {
+		return p.importStatement()
+	}
 	if p.match(token.For) {
 		return p.forStatement()
 	}
@@ -216,13 +386,16 @@ func (p *Parser) statement() (stmt ast.Stmt, err error) {
 		return p.printStatement()
 	}
 	if p.match(token.LeftBrace) {
+		leftBrace := p.previous()
 		var statements []ast.Stmt
 		statements, err = p.block()
 		if err != nil {
 			return
 		}
 		return &ast.BlockStmt{
+			LeftBrace:  leftBrace,
 			Statements: statements,
+			RightBrace: p.previous(),
 		}, nil
 	}
 	return p.expressionStatement()
@@ -238,9 +411,8 @@ func (p *Parser) returnStatement() (stmt ast.Stmt, err error) {
 			return
 		}
 	}
-	_, err = p.consume(token.Semicolon)
+	_, err = p.consume(token.Semicolon, "Expect ';' after return value.")
 	if err != nil {
-		err = fmt.Errorf("Expect ; after return value: %w", err)
 		return
 	}
 	return &ast.ReturnStmt{
@@ -250,10 +422,168 @@ func (p *Parser) returnStatement() (stmt ast.Stmt, err error) {
 
 }
 
+// breakStmt → "break" ";" ;
+func (p *Parser) breakStatement() (stmt ast.Stmt, err error) {
+	keyword := p.previous()
+	_, err = p.consume(token.Semicolon, "Expect ';' after break.")
+	if err != nil {
+		return
+	}
+	return &ast.BreakStmt{
+		Keyword: keyword,
+	}, nil
+}
+
+// continueStmt → "continue" ";" ;
+func (p *Parser) continueStatement() (stmt ast.Stmt, err error) {
+	keyword := p.previous()
+	_, err = p.consume(token.Semicolon, "Expect ';' after continue.")
+	if err != nil {
+		return
+	}
+	return &ast.ContinueStmt{
+		Keyword: keyword,
+	}, nil
+}
+
+// throwStmt → "throw" expression ";" ;
+func (p *Parser) throwStatement() (stmt ast.Stmt, err error) {
+	keyword := p.previous()
+	value, err := p.expression()
+	if err != nil {
+		return
+	}
+	_, err = p.consume(token.Semicolon, "Expect ';' after thrown value.")
+	if err != nil {
+		return
+	}
+	return &ast.ThrowStmt{
+		Keyword: keyword,
+		Value:   value,
+	}, nil
+}
+
+// tryStmt → "try" block ( "catch" "(" IDENTIFIER ")" block )? ( "finally" block )? ;
+//
+// At least one of the catch and finally clauses is required.
+func (p *Parser) tryStatement() (stmt ast.Stmt, err error) {
+	keyword := p.previous()
+	_, err = p.consume(token.LeftBrace, "Expect '{' after try.")
+	if err != nil {
+		return
+	}
+	body, err := p.block()
+	if err != nil {
+		return
+	}
+
+	var catchName *token.Token
+	var catchBody []ast.Stmt
+	if p.match(token.Catch) {
+		_, err = p.consume(token.LeftParen, "Expect '(' after catch.")
+		if err != nil {
+			return
+		}
+		catchName, err = p.consume(token.Identifier, "Expect caught variable name.")
+		if err != nil {
+			return
+		}
+		_, err = p.consume(token.RightParen, "Expect ')' after caught variable name.")
+		if err != nil {
+			return
+		}
+		_, err = p.consume(token.LeftBrace, "Expect '{' before catch body.")
+		if err != nil {
+			return
+		}
+		catchBody, err = p.block()
+		if err != nil {
+			return
+		}
+	}
+
+	var finallyBody []ast.Stmt
+	hasFinally := p.match(token.Finally)
+	if hasFinally {
+		_, err = p.consume(token.LeftBrace, "Expect '{' after finally.")
+		if err != nil {
+			return
+		}
+		finallyBody, err = p.block()
+		if err != nil {
+			return
+		}
+		// An empty finally block still counts as a finally clause.
+		if finallyBody == nil {
+			finallyBody = []ast.Stmt{}
+		}
+	}
+
+	if catchName == nil && !hasFinally {
+		err = p.errorAt(p.peek(), diagnostic.MissingCatchOrFinally, "Expect catch or finally after try block.")
+		return
+	}
+	return &ast.TryStmt{
+		Keyword:     keyword,
+		Body:        body,
+		CatchName:   catchName,
+		CatchBody:   catchBody,
+		FinallyBody: finallyBody,
+		RightBrace:  p.previous(),
+	}, nil
+}
+
+// importStmt → "import" ( "{" IDENTIFIER ( "," IDENTIFIER )* "}" "from" )? STRING ";" ;
+//
+// "from" is only a keyword in this position, so it can still be used as a name
+// everywhere else.
+func (p *Parser) importStatement() (stmt ast.Stmt, err error) {
+	keyword := p.previous()
+	var names []*token.Token
+	if p.match(token.LeftBrace) {
+		for {
+			var name *token.Token
+			name, err = p.consume(token.Identifier, "Expect imported name.")
+			if err != nil {
+				return
+			}
+			names = append(names, name)
+			if !p.match(token.Comma) {
+				break
+			}
+		}
+		_, err = p.consume(token.RightBrace, "Expect '}' after imported names.")
+		if err != nil {
+			return
+		}
+		var from *token.Token
+		from, err = p.consume(token.Identifier, "Expect 'from' after imported names.")
+		if err == nil && from.Lexeme != "from" {
+			err = p.errorAt(from, diagnostic.ExpectedToken, "Expect 'from' after imported names.")
+		}
+		if err != nil {
+			return
+		}
+	}
+	path, err := p.consume(token.String, "Expect module path.")
+	if err != nil {
+		return
+	}
+	_, err = p.consume(token.Semicolon, "Expect ';' after import.")
+	if err != nil {
+		return
+	}
+	return &ast.ImportStmt{
+		Keyword: keyword,
+		Path:    path,
+		Names:   names,
+	}, nil
+}
+
 func (p *Parser) forStatement() (stmt ast.Stmt, err error) {
-	_, err = p.consume(token.LeftParen)
+	keyword := p.previous()
+	_, err = p.consume(token.LeftParen, "Expect '(' after 'for'.")
 	if err != nil {
-		err = fmt.Errorf("expected '(' after if statement: %w", err)
 		return
 	}
 
@@ -279,9 +609,8 @@ func (p *Parser) forStatement() (stmt ast.Stmt, err error) {
 			return
 		}
 	}
-	_, err = p.consume(token.Semicolon)
+	_, err = p.consume(token.Semicolon, "Expect ';' after condition.")
 	if err != nil {
-		err = fmt.Errorf("expected ';' after condition: %w", err)
 		return
 	}
 
@@ -292,9 +621,8 @@ func (p *Parser) forStatement() (stmt ast.Stmt, err error) {
 			return
 		}
 	}
-	_, err = p.consume(token.RightParen)
+	_, err = p.consume(token.RightParen, "Expect ')' after for loop conditions.")
 	if err != nil {
-		err = fmt.Errorf("expected ')' after for loop conditions: %w", err)
 		return
 	}
 
@@ -302,24 +630,17 @@ func (p *Parser) forStatement() (stmt ast.Stmt, err error) {
 	if err != nil {
 		return
 	}
-	// We begin the de-sugaring:
-	if increment != nil {
-		body = &ast.BlockStmt{
-			Statements: []ast.Stmt{
-				body,
-				&ast.ExpressionStmt{
-					Expression: increment,
-				},
-			},
-		}
-	}
-
+	// We begin the de-sugaring. The increment is kept apart from the body,
+	// rather than appended to it, so that a `continue` in the body still runs
+	// it.
 	if condition == nil {
 		condition = &ast.LiteralExpr{Value: true}
 	}
 	body = &ast.WhileStmt{
+		Keyword:   keyword,
 		Condition: condition,
 		Body:      body,
+		Increment: increment,
 	}
 
 	if initializer != nil {
@@ -335,9 +656,9 @@ func (p *Parser) forStatement() (stmt ast.Stmt, err error) {
 }
 
 func (p *Parser) ifStatement() (stmt ast.Stmt, err error) {
-	_, err = p.consume(token.LeftParen)
+	keyword := p.previous()
+	_, err = p.consume(token.LeftParen, "Expect '(' after 'if'.")
 	if err != nil {
-		err = fmt.Errorf("expected '(' after if statement: %w", err)
 		return
 	}
 	expr, err := p.expression()
@@ -345,9 +666,8 @@ func (p *Parser) ifStatement() (stmt ast.Stmt, err error) {
 		return
 	}
 
-	_, err = p.consume(token.RightParen)
+	_, err = p.consume(token.RightParen, "Expect ')' after if condition.")
 	if err != nil {
-		err = fmt.Errorf("expected ')' after if condition: %w", err)
 		return
 	}
 
@@ -364,6 +684,7 @@ func (p *Parser) ifStatement() (stmt ast.Stmt, err error) {
 	}
 
 	return &ast.IfStmt{
+		Keyword:    keyword,
 		Condition:  expr,
 		ThenBranch: thenBranch,
 		ElseBranch: elseBranch,
@@ -371,9 +692,9 @@ func (p *Parser) ifStatement() (stmt ast.Stmt, err error) {
 }
 
 func (p *Parser) whileStatement() (stmt ast.Stmt, err error) {
-	_, err = p.consume(token.LeftParen)
+	keyword := p.previous()
+	_, err = p.consume(token.LeftParen, "Expect '(' after 'while'.")
 	if err != nil {
-		err = fmt.Errorf("expected '(' after if statement: %w", err)
 		return
 	}
 
@@ -381,9 +702,8 @@ func (p *Parser) whileStatement() (stmt ast.Stmt, err error) {
 	if err != nil {
 		return
 	}
-	_, err = p.consume(token.RightParen)
+	_, err = p.consume(token.RightParen, "Expect ')' after while condition.")
 	if err != nil {
-		err = fmt.Errorf("expected ')' after if condition: %w", err)
 		return
 	}
 
@@ -393,38 +713,39 @@ func (p *Parser) whileStatement() (stmt ast.Stmt, err error) {
 	}
 
 	return &ast.WhileStmt{
+		Keyword:   keyword,
 		Condition: expr,
 		Body:      body,
 	}, nil
 }
 
 func (p *Parser) block() (stmts []ast.Stmt, err error) {
+	p.blocks = append(p.blocks, p.braces)
+	defer func() {
+		p.blocks = p.blocks[:len(p.blocks)-1]
+	}()
 	for !p.check(token.RightBrace) && !p.isAtEnd() {
-		var stmt ast.Stmt
-		stmt, err = p.declaration()
-		if err != nil {
-			return
-		}
-		stmts = append(stmts, stmt)
+		stmts = append(stmts, p.declaration())
 	}
-	_, err = p.consume(token.RightBrace)
+	_, err = p.consume(token.RightBrace, "Expect '}' after block.")
 	if err != nil {
-		err = fmt.Errorf("expected '}' after block: %w", err)
 		return
 	}
 	return
 }
 
 func (p *Parser) printStatement() (stmt ast.Stmt, err error) {
+	keyword := p.previous()
 	value, err := p.expression()
 	if err != nil {
 		return
 	}
-	_, err = p.consume(token.Semicolon)
+	_, err = p.consume(token.Semicolon, "Expect ';' after value.")
 	if err != nil {
 		return
 	}
 	return &ast.PrintStmt{
+		Keyword:    keyword,
 		Expression: value,
 	}, nil
 }
@@ -434,7 +755,7 @@ func (p *Parser) expressionStatement() (stmt ast.Stmt, err error) {
 	if err != nil {
 		return
 	}
-	_, err = p.consume(token.Semicolon)
+	_, err = p.consume(token.Semicolon, "Expect ';' after expression.")
 	if err != nil {
 		return
 	}
@@ -447,6 +768,7 @@ func (p *Parser) expression() (expr ast.Expr, err error) {
 	return p.assignment()
 }
 func (p *Parser) assignment() (expr ast.Expr, err error) {
+	from := p.peek()
 	expr, err = p.or()
 	if err != nil {
 		return
@@ -459,7 +781,6 @@ func (p *Parser) assignment() (expr ast.Expr, err error) {
 	var rvalue ast.Expr
 	rvalue, err = p.assignment()
 	if err != nil {
-		err = fmt.Errorf("invalid rvalue %w", err)
 		return
 	}
 
@@ -470,8 +791,29 @@ func (p *Parser) assignment() (expr ast.Expr, err error) {
 		}
 		return
 	}
+	if indexExpr, ok := expr.(*ast.IndexExpr); ok {
+		expr = &ast.IndexSetExpr{
+			Object:  indexExpr.Object,
+			Bracket: indexExpr.Bracket,
+			Index:   indexExpr.Index,
+			Value:   rvalue,
+		}
+		return
+	}
+	if getExpr, ok := expr.(*ast.GetExpr); ok {
+		expr = &ast.SetExpr{
+			Object: getExpr.Object,
+			Name:   getExpr.Name,
+			Value:  rvalue,
+		}
+		return
+	}
 
-	err = fmt.Errorf("Invalid assignment target for equals token, at line: %d", equals.Line)
+	// The parser isn't confused about where the assignment ends, so we report
+	// the error without unwinding, and keep parsing the rest of the statement.
+	p.report(diagnostic.Errorf(diagnostic.InvalidAssignmentTarget, expr.Span(), "Invalid assignment target.").
+		WithSecondary(equals.Span(), "assigned here"))
+	expr = &ast.BadExpr{From: from, To: p.previous()}
 	return
 
 }
@@ -606,7 +948,7 @@ func (p *Parser) factor() (expr ast.Expr, err error) {
 
 // unary → ( "!" | "-" ) unary | call ;
 func (p *Parser) unary() (expr ast.Expr, err error) {
-	if p.match(token.Slash, token.Star) {
+	if p.match(token.Bang, token.Minus) {
 		operator := p.previous()
 		var right ast.Expr
 		right, err = p.unary()
@@ -622,7 +964,7 @@ func (p *Parser) unary() (expr ast.Expr, err error) {
 	return p.call()
 }
 
-// call      → primary ( "(" arguments? ")" )* ;
+// call      → primary ( "(" arguments? ")" | "." IDENTIFIER | "[" expression "]" )* ;
 // arguments → expression ( "," expression )* ;
 func (p *Parser) call() (expr ast.Expr, err error) {
 	expr, err = p.primary()
@@ -635,6 +977,32 @@ func (p *Parser) call() (expr ast.Expr, err error) {
 			if err != nil {
 				return
 			}
+		} else if p.match(token.LeftBracket) {
+			var index ast.Expr
+			index, err = p.expression()
+			if err != nil {
+				return
+			}
+			var bracket *token.Token
+			bracket, err = p.consume(token.RightBracket, "Expect ']' after index.")
+			if err != nil {
+				return
+			}
+			expr = &ast.IndexExpr{
+				Object:  expr,
+				Bracket: bracket,
+				Index:   index,
+			}
+		} else if p.match(token.Dot) {
+			var name *token.Token
+			name, err = p.consume(token.Identifier, "Expect property name after '.'.")
+			if err != nil {
+				return
+			}
+			expr = &ast.GetExpr{
+				Object: expr,
+				Name:   name,
+			}
 		} else {
 			break
 		}
@@ -643,15 +1011,15 @@ func (p *Parser) call() (expr ast.Expr, err error) {
 }
 
 func (p *Parser) finishCall(incoming ast.Expr) (expr ast.Expr, err error) {
+	leftParen := p.previous()
 	var args []ast.Expr
 	if !p.check(token.RightParen) {
 		for {
-			if len(args) >= maxFuncArgCounts {
+			if len(args) == maxFuncArgCounts {
 				// Having a maximum number of arguments will simplify our bytecode interpreter
 				// in Part III. We want our two interpreters to be compatible with each other,
 				// even in weird corner cases like this, so we’ll add the same limit
-				err = fmt.Errorf("Can't have more than 255 arguments.")
-				return
+				p.report(diagnostic.Errorf(diagnostic.TooManyArguments, p.peek().Span(), "Can't have more than 255 arguments."))
 			}
 			var arg ast.Expr
 			arg, err = p.expression()
@@ -664,9 +1032,9 @@ func (p *Parser) finishCall(incoming ast.Expr) (expr ast.Expr, err error) {
 			}
 		}
 	}
-	paren, err := p.consume(token.RightParen)
+	paren, err := p.consume(token.RightParen, "Expect ')' after arguments.")
 	if err != nil {
-		err = fmt.Errorf("expected ')' after arguments: %w", err)
+		err = unclosed(err, leftParen)
 		return
 	}
 	return &ast.CallExpr{
@@ -680,24 +1048,60 @@ func (p *Parser) primary() (expr ast.Expr, err error) {
 	if p.match(token.False) {
 		expr = &ast.LiteralExpr{
 			Value: false,
+			Token: p.previous(),
 		}
 		return
 	}
 	if p.match(token.True) {
 		expr = &ast.LiteralExpr{
 			Value: true,
+			Token: p.previous(),
 		}
 		return
 	}
 	if p.match(token.Nil) {
 		expr = &ast.LiteralExpr{
 			Value: nil,
+			Token: p.previous(),
 		}
 		return
 	}
 	if p.match(token.Number, token.String) {
 		expr = &ast.LiteralExpr{
 			Value: p.previous().Literal,
+			Token: p.previous(),
+		}
+		return
+	}
+	if p.match(token.Fun) {
+		return p.lambda()
+	}
+	if p.match(token.LeftBracket) {
+		return p.list()
+	}
+	if p.match(token.LeftBrace) {
+		return p.mapLiteral()
+	}
+	if p.match(token.Super) {
+		keyword := p.previous()
+		_, err = p.consume(token.Dot, "Expect '.' after 'super'.")
+		if err != nil {
+			return
+		}
+		var method *token.Token
+		method, err = p.consume(token.Identifier, "Expect superclass method name.")
+		if err != nil {
+			return
+		}
+		expr = &ast.SuperExpr{
+			Keyword: keyword,
+			Method:  method,
+		}
+		return
+	}
+	if p.match(token.This) {
+		expr = &ast.ThisExpr{
+			Keyword: p.previous(),
 		}
 		return
 	}
@@ -709,39 +1113,112 @@ func (p *Parser) primary() (expr ast.Expr, err error) {
 	}
 
 	if p.match(token.LeftParen) {
+		leftParen := p.previous()
 		var groupingExpr ast.Expr
 		groupingExpr, err = p.expression()
 		if err != nil {
 			return
 		}
-		_, err = p.consume(token.RightParen)
+		var rightParen *token.Token
+		rightParen, err = p.consume(token.RightParen, "Expect ')' after expression.")
 		if err != nil {
+			err = unclosed(err, leftParen)
 			return
 		}
 		expr = &ast.GroupingExpr{
+			LeftParen:  leftParen,
 			Expression: groupingExpr,
+			RightParen: rightParen,
 		}
 		return
 	}
 	actual := p.peek()
-	err = fmt.Errorf("unexpected token type: %s, at line: %d", actual.TokenType, actual.Line)
+	err = p.errorAt(actual, diagnostic.ExpectedExpression, "Expect expression.")
 	return
 }
 
+// list → "[" ( expression ( "," expression )* )? "]" ;
+func (p *Parser) list() (expr ast.Expr, err error) {
+	leftBracket := p.previous()
+	var elements []ast.Expr
+	if !p.check(token.RightBracket) {
+		for {
+			var element ast.Expr
+			element, err = p.expression()
+			if err != nil {
+				return
+			}
+			elements = append(elements, element)
+			if !p.match(token.Comma) {
+				break
+			}
+		}
+	}
+	rightBracket, err := p.consume(token.RightBracket, "Expect ']' after list elements.")
+	if err != nil {
+		err = unclosed(err, leftBracket)
+		return
+	}
+	return &ast.ListExpr{
+		LeftBracket:  leftBracket,
+		Elements:     elements,
+		RightBracket: rightBracket,
+	}, nil
+}
+
+// map   → "{" ( entry ( "," entry )* )? "}" ;
+// entry → expression ":" expression ;
+func (p *Parser) mapLiteral() (expr ast.Expr, err error) {
+	leftBrace := p.previous()
+	var keys []ast.Expr
+	var values []ast.Expr
+	if !p.check(token.RightBrace) {
+		for {
+			var key ast.Expr
+			key, err = p.expression()
+			if err != nil {
+				return
+			}
+			_, err = p.consume(token.Colon, "Expect ':' after map key.")
+			if err != nil {
+				return
+			}
+			var value ast.Expr
+			value, err = p.expression()
+			if err != nil {
+				return
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+			if !p.match(token.Comma) {
+				break
+			}
+		}
+	}
+	rightBrace, err := p.consume(token.RightBrace, "Expect '}' after map entries.")
+	if err != nil {
+		err = unclosed(err, leftBrace)
+		return
+	}
+	return &ast.MapExpr{
+		LeftBrace:  leftBrace,
+		Keys:       keys,
+		Values:     values,
+		RightBrace: rightBrace,
+	}, nil
+}
+
 // ----------------------------------------------------------------------------
 // Public API
 
+// Parse parses the whole program. It recovers from syntax errors, so that all
+// of them are reported in one pass: they are returned together as a
+// diagnostic.List, and the declarations they were found in are replaced by
+// BadStmt nodes in the returned statements.
 func (p *Parser) Parse() (statements []ast.Stmt, err error) {
 	statements = make([]ast.Stmt, 0)
 	for !p.isAtEnd() {
-		statement, statementErr := p.declaration()
-		if statementErr != nil {
-			err = ParserError{
-				err: statementErr,
-			}
-			return
-		}
-		statements = append(statements, statement)
+		statements = append(statements, p.declaration())
 	}
-	return
+	return statements, p.errors.Err()
 }
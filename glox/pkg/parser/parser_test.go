@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/scanner"
 	"github.com/modulitos/glox/pkg/token"
 	"github.com/stretchr/testify/assert"
 )
@@ -52,13 +55,29 @@ func TestParser_Parse(t *testing.T) {
 			expected: []ast.Stmt{
 				&ast.ExpressionStmt{
 					Expression: &ast.BinaryExpr{
-						Left: &ast.LiteralExpr{Value: 1},
+						Left: &ast.LiteralExpr{
+							Value: 1,
+							Token: &token.Token{
+								TokenType: token.Number,
+								Lexeme:    "1",
+								Literal:   1,
+								Line:      1,
+							},
+						},
 						Operator: &token.Token{
 							TokenType: token.Plus,
 							Lexeme:    "+",
 							Line:      1,
 						},
-						Right: &ast.LiteralExpr{Value: 2},
+						Right: &ast.LiteralExpr{
+							Value: 2,
+							Token: &token.Token{
+								TokenType: token.Number,
+								Lexeme:    "2",
+								Literal:   2,
+								Line:      1,
+							},
+						},
 					},
 				},
 			},
@@ -121,21 +140,55 @@ func TestParser_Parse(t *testing.T) {
 			expected: []ast.Stmt{
 				&ast.ExpressionStmt{
 					Expression: &ast.BinaryExpr{
-						Left: &ast.LiteralExpr{Value: "asdf"},
+						Left: &ast.LiteralExpr{
+							Value: "asdf",
+							Token: &token.Token{
+								TokenType: token.String,
+								Lexeme:    "asdf",
+								Literal:   "asdf",
+								Line:      1,
+							},
+						},
 						Operator: &token.Token{
 							TokenType: token.LessEqual,
 							Lexeme:    "<=",
 							Line:      1,
 						},
 						Right: &ast.GroupingExpr{
+							LeftParen: &token.Token{
+								TokenType: token.LeftParen,
+								Lexeme:    "(",
+								Line:      1,
+							},
 							Expression: &ast.BinaryExpr{
-								Left: &ast.LiteralExpr{Value: 1},
+								Left: &ast.LiteralExpr{
+									Value: 1,
+									Token: &token.Token{
+										TokenType: token.Number,
+										Lexeme:    "1",
+										Literal:   1,
+										Line:      1,
+									},
+								},
 								Operator: &token.Token{
 									TokenType: token.Plus,
 									Lexeme:    "+",
 									Line:      1,
 								},
-								Right: &ast.LiteralExpr{Value: 2},
+								Right: &ast.LiteralExpr{
+									Value: 2,
+									Token: &token.Token{
+										TokenType: token.Number,
+										Lexeme:    "2",
+										Literal:   2,
+										Line:      1,
+									},
+								},
+							},
+							RightParen: &token.Token{
+								TokenType: token.RightParen,
+								Lexeme:    ")",
+								Line:      1,
 							},
 						},
 					},
@@ -179,13 +232,29 @@ func TestParser_Parse(t *testing.T) {
 			expected: []ast.Stmt{
 				&ast.ExpressionStmt{
 					Expression: &ast.BinaryExpr{
-						Left: &ast.LiteralExpr{Value: "asdf"},
+						Left: &ast.LiteralExpr{
+							Value: "asdf",
+							Token: &token.Token{
+								TokenType: token.String,
+								Lexeme:    "asdf",
+								Literal:   "asdf",
+								Line:      1,
+							},
+						},
 						Operator: &token.Token{
 							TokenType: token.Plus,
 							Lexeme:    "+",
 							Line:      1,
 						},
-						Right: &ast.LiteralExpr{Value: "qwer"},
+						Right: &ast.LiteralExpr{
+							Value: "qwer",
+							Token: &token.Token{
+								TokenType: token.String,
+								Lexeme:    "qwer",
+								Literal:   "qwer",
+								Line:      1,
+							},
+						},
 					},
 				},
 			},
@@ -220,7 +289,21 @@ func TestParser_Parse(t *testing.T) {
 			},
 			expected: []ast.Stmt{
 				&ast.PrintStmt{
-					Expression: &ast.LiteralExpr{Value: "qwer"},
+					Keyword: &token.Token{
+						TokenType: token.Print,
+						Lexeme:    "print",
+						Literal:   "asdf",
+						Line:      1,
+					},
+					Expression: &ast.LiteralExpr{
+						Value: "qwer",
+						Token: &token.Token{
+							TokenType: token.String,
+							Lexeme:    "qwer",
+							Literal:   "qwer",
+							Line:      1,
+						},
+					},
 				},
 			},
 		},
@@ -266,13 +349,26 @@ func TestParser_Parse(t *testing.T) {
 			},
 			expected: []ast.Stmt{
 				&ast.VarStmt{
+					Keyword: &token.Token{
+						TokenType: token.Var,
+						Lexeme:    "Var",
+						Line:      1,
+					},
 					Name: &token.Token{
 						TokenType: token.Identifier,
 						Literal:   "qwer",
 						Lexeme:    "qwer",
 						Line:      1,
 					},
-					Initializer: &ast.LiteralExpr{Value: 42},
+					Initializer: &ast.LiteralExpr{
+						Value: 42,
+						Token: &token.Token{
+							TokenType: token.Number,
+							Lexeme:    "42",
+							Literal:   42,
+							Line:      1,
+						},
+					},
 				},
 			},
 		},
@@ -360,27 +456,63 @@ func TestParser_Parse(t *testing.T) {
 			},
 			expected: []ast.Stmt{
 				&ast.VarStmt{
+					Keyword: &token.Token{
+						TokenType: token.Var,
+						Lexeme:    "Var",
+						Line:      1,
+					},
 					Name: &token.Token{
 						TokenType: token.Identifier,
 						Literal:   "foo",
 						Lexeme:    "foo",
 						Line:      1,
 					},
-					Initializer: &ast.LiteralExpr{Value: 42},
+					Initializer: &ast.LiteralExpr{
+						Value: 42,
+						Token: &token.Token{
+							TokenType: token.Number,
+							Lexeme:    "42",
+							Literal:   42,
+							Line:      1,
+						},
+					},
 				},
 
 				&ast.BlockStmt{
+					LeftBrace: &token.Token{
+						TokenType: token.LeftBrace,
+						Lexeme:    "{",
+						Line:      2,
+					},
 					Statements: []ast.Stmt{
 						&ast.VarStmt{
+							Keyword: &token.Token{
+								TokenType: token.Var,
+								Lexeme:    "Var",
+								Line:      3,
+							},
 							Name: &token.Token{
 								TokenType: token.Identifier,
 								Literal:   "foo",
 								Lexeme:    "foo",
 								Line:      3,
 							},
-							Initializer: &ast.LiteralExpr{Value: 42},
+							Initializer: &ast.LiteralExpr{
+								Value: 42,
+								Token: &token.Token{
+									TokenType: token.Number,
+									Lexeme:    "42",
+									Literal:   42,
+									Line:      3,
+								},
+							},
 						},
 					},
+					RightBrace: &token.Token{
+						TokenType: token.RightBrace,
+						Lexeme:    "}",
+						Line:      4,
+					},
 				},
 			},
 		},
@@ -447,9 +579,19 @@ func TestParser_Parse(t *testing.T) {
 						Args: []ast.Expr{
 							&ast.LiteralExpr{
 								Value: "Dear",
+								Token: &token.Token{
+									TokenType: token.String,
+									Lexeme:    "Dear",
+									Literal:   "Dear",
+								},
 							},
 							&ast.LiteralExpr{
 								Value: "Reader",
+								Token: &token.Token{
+									TokenType: token.String,
+									Lexeme:    "Reader",
+									Literal:   "Reader",
+								},
 							},
 						},
 					},
@@ -474,3 +616,143 @@ func TestParser_Parse(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_Spans(t *testing.T) {
+	source := "print (1 + 2) * x;\nfun add(a, b) {\n  return a + b;\n}\nvar xs = [1, \"é\"];\n"
+	s := scanner.NewFileScanner("test.lox", []byte(source))
+	tokens, err := s.ScanTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := Parser{Tokens: tokens}
+	stmts, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		node     interface{ Span() token.Span }
+		expected token.Span
+		text     string
+	}{
+		{
+			name:     "statements exclude the trailing semicolon",
+			node:     stmts[0],
+			expected: token.Span{File: "test.lox", Line: 1, Column: 1, Start: 0, End: 17},
+			text:     "print (1 + 2) * x",
+		},
+		{
+			name:     "grouping includes its parens",
+			node:     stmts[0].(*ast.PrintStmt).Expression.(*ast.BinaryExpr).Left,
+			expected: token.Span{File: "test.lox", Line: 1, Column: 7, Start: 6, End: 13},
+			text:     "(1 + 2)",
+		},
+		{
+			name:     "function spans from fun to its closing brace",
+			node:     stmts[1],
+			expected: token.Span{File: "test.lox", Line: 2, Column: 1, Start: 19, End: 52},
+			text:     "fun add(a, b) {\n  return a + b;\n}",
+		},
+		{
+			name:     "nested return statement",
+			node:     stmts[1].(*ast.FunctionStmt).Body[0],
+			expected: token.Span{File: "test.lox", Line: 3, Column: 3, Start: 37, End: 49},
+			text:     "return a + b",
+		},
+		{
+			name:     "list literal includes its brackets",
+			node:     stmts[2].(*ast.VarStmt).Initializer,
+			expected: token.Span{File: "test.lox", Line: 5, Column: 10, Start: 62, End: 71},
+			text:     "[1, \"é\"]",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			span := tc.node.Span()
+			assert.Equal(t, tc.expected, span)
+			assert.Equal(t, tc.text, source[span.Start:span.End])
+		})
+	}
+}
+
+func TestParser_ErrorRecovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		wantErrs  []string
+		wantStmts []string
+	}{
+		{
+			name:   "reports every broken statement",
+			source: "print 1 +;\nvar x = 2;\nprint x x;\nprint 3;",
+			wantErrs: []string{
+				"1:10: error[E0101]: Expect expression.",
+				"3:9: error[E0100]: Expect ';' after value.",
+			},
+			wantStmts: []string{"*ast.BadStmt", "*ast.VarStmt", "*ast.BadStmt", "*ast.PrintStmt"},
+		},
+		{
+			name:   "the block still ends at its closing brace",
+			source: "{\n  print 1\n}\nprint 2;",
+			wantErrs: []string{
+				"3:1: error[E0100]: Expect ';' after value.",
+			},
+			wantStmts: []string{"*ast.BlockStmt[*ast.BadStmt]", "*ast.PrintStmt"},
+		},
+		{
+			name:   "braces of map literals don't end the block",
+			source: "{\n  var m = {1: };\n  print 1;\n}\nprint 2;",
+			wantErrs: []string{
+				"2:15: error[E0101]: Expect expression.",
+			},
+			wantStmts: []string{"*ast.BlockStmt[*ast.BadStmt *ast.PrintStmt]", "*ast.PrintStmt"},
+		},
+		{
+			name:   "errors caused by a previous one are dropped",
+			source: "print (1 + ;) ;\nprint 2;",
+			wantErrs: []string{
+				"1:12: error[E0101]: Expect expression.",
+			},
+			wantStmts: []string{"*ast.BadStmt", "*ast.BadStmt", "*ast.PrintStmt"},
+		},
+		{
+			name:   "invalid assignment targets don't stop the statement",
+			source: "1 + 2 = 3;\nprint 4;",
+			wantErrs: []string{
+				"1:1: error[E0102]: Invalid assignment target.",
+			},
+			wantStmts: []string{"*ast.ExpressionStmt", "*ast.PrintStmt"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := scanner.NewScanner([]byte(tc.source))
+			tokens, err := s.ScanTokens()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parser := Parser{Tokens: tokens}
+
+			stmts, err := parser.Parse()
+
+			var gotErrs []string
+			for _, d := range diagnostic.From(err) {
+				gotErrs = append(gotErrs, d.Error())
+			}
+			assert.Equal(t, tc.wantErrs, gotErrs)
+			assert.Equal(t, tc.wantStmts, stmtKinds(stmts))
+		})
+	}
+}
+
+func stmtKinds(stmts []ast.Stmt) []string {
+	kinds := make([]string, len(stmts))
+	for index, stmt := range stmts {
+		kinds[index] = fmt.Sprintf("%T", stmt)
+		if block, ok := stmt.(*ast.BlockStmt); ok {
+			kinds[index] += fmt.Sprintf("%v", stmtKinds(block.Statements))
+		}
+	}
+	return kinds
+}
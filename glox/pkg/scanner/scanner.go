@@ -1,16 +1,17 @@
 package scanner
 
 import (
-	"errors"
-	"fmt"
 	"strconv"
-	"strings"
 	"unicode/utf8"
 
+	"github.com/modulitos/glox/pkg/diagnostic"
 	"github.com/modulitos/glox/pkg/token"
 )
 
 type scanner struct {
+	// The name of the file being scanned, recorded on every token. Empty for
+	// code that doesn't come from a file.
+	file   string
 	source []byte
 	tokens []*token.Token
 
@@ -23,17 +24,33 @@ type scanner struct {
 	// Tracks what source line current is on so we can produce tokens that know their location.
 	line int
 
-	errors []error
+	// The byte offset where the current line begins, to compute columns from.
+	lineStart int
+
+	// The line and column the lexeme being scanned starts at. Strings can
+	// span several lines, so these can differ from where the lexeme ends.
+	startLine   int
+	startColumn int
+
+	// The comments scanned since the last token, which go to the next one.
+	comments []token.Comment
+
+	errors diagnostic.List
 }
 
 func NewScanner(source []byte) scanner {
+	return NewFileScanner("", source)
+}
+
+// NewFileScanner scans source that was read from the named file.
+func NewFileScanner(file string, source []byte) scanner {
 	return scanner{
+		file:    file,
 		source:  source,
 		tokens:  []*token.Token{},
 		start:   0,
 		current: 0,
 		line:    1,
-		errors:  []error{},
 	}
 }
 
@@ -41,22 +58,43 @@ func (s *scanner) ScanTokens() ([]*token.Token, error) {
 	for !s.isAtEnd() {
 		// We are at the beginning of the next lexeme.
 		s.start = s.current
+		s.startLine = s.line
+		s.startColumn = s.column(s.start)
 		s.scanToken()
 	}
 
-	s.tokens = append(s.tokens, token.NewEofToken(s.line))
+	eof := token.NewEofToken(s.line)
+	eof.File = s.file
+	eof.Column = s.column(s.current)
+	eof.Start = s.current
+	eof.End = s.current
+	eof.Comments = s.comments
+	s.tokens = append(s.tokens, eof)
 
 	if len(s.errors) > 0 {
-		builder := strings.Builder{}
-		for _, err := range s.errors {
-			builder.WriteString(fmt.Sprintf("%v\n", err.Error()))
-		}
-		return nil, errors.New(builder.String())
+		return nil, s.errors
 	} else {
 		return s.tokens, nil
 	}
 }
 
+// errorf reports an error on the lexeme being scanned, or on its first length
+// bytes if length isn't negative.
+func (s *scanner) errorf(code diagnostic.Code, length int, format string, args ...interface{}) {
+	end := s.current
+	if length >= 0 {
+		end = s.start + length
+	}
+	span := token.Span{
+		File:   s.file,
+		Line:   s.startLine,
+		Column: s.startColumn,
+		Start:  s.start,
+		End:    end,
+	}
+	s.errors.Add(diagnostic.Errorf(code, span, format, args...))
+}
+
 func (s *scanner) scanToken() {
 	for !s.isAtEnd() {
 		c := s.advance()
@@ -65,7 +103,7 @@ func (s *scanner) scanToken() {
 			// do nothing on whitespace chars
 			return
 		case '\n':
-			s.line++
+			s.newline()
 			return
 		case '(':
 			s.addSimpleToken(token.LeftParen)
@@ -79,9 +117,18 @@ func (s *scanner) scanToken() {
 		case '}':
 			s.addSimpleToken(token.RightBrace)
 			return
+		case '[':
+			s.addSimpleToken(token.LeftBracket)
+			return
+		case ']':
+			s.addSimpleToken(token.RightBracket)
+			return
 		case ',':
 			s.addSimpleToken(token.Comma)
 			return
+		case ':':
+			s.addSimpleToken(token.Colon)
+			return
 		case '.':
 			s.addSimpleToken(token.Dot)
 			return
@@ -100,7 +147,17 @@ func (s *scanner) scanToken() {
 				for s.peek() != '\n' && !s.isAtEnd() {
 					s.advance()
 				}
-				// Comments as lexemes are ignored.
+				// Comments aren't tokens, but are kept with the next token.
+				s.comments = append(s.comments, token.Comment{
+					Text: string(s.source[s.start:s.current]),
+					Span: token.Span{
+						File:   s.file,
+						Line:   s.startLine,
+						Column: s.startColumn,
+						Start:  s.start,
+						End:    s.current,
+					},
+				})
 			} else {
 				s.addSimpleToken(token.Slash)
 			}
@@ -145,13 +202,25 @@ func (s *scanner) scanToken() {
 			} else if s.isAlpha(c) {
 				s.identifier()
 			} else {
-				s.errors = append(s.errors, fmt.Errorf("Unexpected character: %c on line: %d", c, s.line))
+				s.errorf(diagnostic.UnexpectedCharacter, -1, "Unexpected character: %c", c)
 			}
 			return
 		}
 	}
 }
 
+// Columns count runes rather than bytes, so that they match what editors show
+// for lines with multi-byte characters.
+func (s *scanner) column(offset int) int {
+	return utf8.RuneCount(s.source[s.lineStart:offset]) + 1
+}
+
+// newline is called once the '\n' ending a line has been consumed.
+func (s *scanner) newline() {
+	s.line++
+	s.lineStart = s.current
+}
+
 func (s *scanner) isAtEnd() bool {
 	return s.current >= len(s.source)
 }
@@ -181,9 +250,15 @@ func (s *scanner) addToken(t token.Type, literal interface{}) {
 		&token.Token{
 			TokenType: t,
 			Lexeme:    string(s.source[s.start:s.current]),
-			Line:      s.line,
+			Line:      s.startLine,
 			Literal:   literal,
+			File:      s.file,
+			Column:    s.startColumn,
+			Start:     s.start,
+			End:       s.current,
+			Comments:  s.comments,
 		})
+	s.comments = nil
 }
 
 func (s *scanner) addSimpleToken(t token.Type) {
@@ -223,26 +298,25 @@ func (s *scanner) peekNext() rune {
 	}
 }
 
-func (s *scanner) string() (err error) {
+func (s *scanner) string() {
 	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
-			s.line++
-		} else {
-			s.advance()
+		if s.advance() == '\n' {
+			s.newline()
 		}
 	}
 
 	if s.isAtEnd() {
-		err = fmt.Errorf("Unterminated string at line: %d", s.line)
+		// Point at the opening quote, since the rest of the file is part of
+		// the string.
+		s.errorf(diagnostic.UnterminatedString, 1, "Unterminated string.")
 		return
 	}
 
 	s.advance()
 	s.addToken(token.String, string(s.source[s.start+1:s.current-1]))
-	return
 }
 
-func (s *scanner) number() (err error) {
+func (s *scanner) number() {
 	for s.isDigit(s.peek()) {
 		s.advance()
 	}
@@ -256,12 +330,11 @@ func (s *scanner) number() (err error) {
 	literal, err := strconv.ParseFloat(str, 64)
 
 	if err != nil {
-		err = fmt.Errorf("Failed to parse number into string: %s, at line: %d", str, s.line)
+		s.errorf(diagnostic.InvalidNumber, -1, "Invalid number: %s", str)
 		return
 	}
 
 	s.addToken(token.Number, literal)
-	return
 }
 
 func (s *scanner) identifier() (err error) {
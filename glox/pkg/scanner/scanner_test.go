@@ -48,7 +48,13 @@ func TestScanner_ScanTokens(t *testing.T) {
 		{
 			name:       "multiple unexpected characters",
 			source:     "(+)^ \n {.}^",
-			wantErr:    fmt.Errorf("Unexpected character: ^ on line: 1\nUnexpected character: ^ on line: 2\n"),
+			wantErr:    fmt.Errorf("1:4: error[E0001]: Unexpected character: ^\n2:5: error[E0001]: Unexpected character: ^"),
+			wantTokens: nil,
+		},
+		{
+			name:       "unterminated string",
+			source:     "print \"abc\ndef",
+			wantErr:    fmt.Errorf("1:7: error[E0002]: Unterminated string."),
 			wantTokens: nil,
 		},
 		{
@@ -71,9 +77,28 @@ func TestScanner_ScanTokens(t *testing.T) {
 				simpleToken(token.Bang, 1, "!"),
 				simpleToken(token.Bang, 2, "!"),
 				simpleToken(token.Bang, 2, "!"),
-				simpleToken(token.LeftParen, 3, "("),
+				{
+					TokenType: token.LeftParen,
+					Lexeme:    "(",
+					Line:      3,
+					Comments: []token.Comment{
+						{
+							Text: "// this is a comment ",
+							Span: token.Span{Line: 2, Column: 3, Start: 4, End: 25},
+						},
+					},
+				},
 				simpleToken(token.RightParen, 3, ")"),
-				token.NewEofToken(3),
+				{
+					TokenType: token.Eof,
+					Line:      3,
+					Comments: []token.Comment{
+						{
+							Text: "// some other comment",
+							Span: token.Span{Line: 3, Column: 4, Start: 29, End: 50},
+						},
+					},
+				},
 			},
 		},
 		{
@@ -170,6 +195,32 @@ func TestScanner_ScanTokens(t *testing.T) {
 				token.NewEofToken(1),
 			},
 		},
+		{
+			name:    "brackets",
+			source:  "[1]",
+			wantErr: nil,
+			wantTokens: []*token.Token{
+				simpleToken(token.LeftBracket, 1, "["),
+				{
+					TokenType: token.Number,
+					Lexeme:    "1",
+					Literal:   1.0,
+					Line:      1,
+				},
+				simpleToken(token.RightBracket, 1, "]"),
+				token.NewEofToken(1),
+			},
+		},
+		{
+			name:    "loop control key words",
+			source:  "break continue",
+			wantErr: nil,
+			wantTokens: []*token.Token{
+				simpleToken(token.Break, 1, "break"),
+				simpleToken(token.Continue, 1, "continue"),
+				token.NewEofToken(1),
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -187,7 +238,68 @@ func TestScanner_ScanTokens(t *testing.T) {
 				t.Errorf("ScanTokens() error = %v, wantErr %v", err, tc.wantErr)
 				return
 			}
+			// Positions are covered by TestScanner_Spans.
+			for _, tok := range gotTokens {
+				tok.Column, tok.Start, tok.End = 0, 0, 0
+			}
 			assert.Equal(t, tc.wantTokens, gotTokens)
 		})
 	}
 }
+
+func TestScanner_Spans(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		wantSpans []token.Span
+	}{
+		{
+			name:   "single line",
+			source: "var x = 1;",
+			wantSpans: []token.Span{
+				{File: "test.lox", Line: 1, Column: 1, Start: 0, End: 3},
+				{File: "test.lox", Line: 1, Column: 5, Start: 4, End: 5},
+				{File: "test.lox", Line: 1, Column: 7, Start: 6, End: 7},
+				{File: "test.lox", Line: 1, Column: 9, Start: 8, End: 9},
+				{File: "test.lox", Line: 1, Column: 10, Start: 9, End: 10},
+				{File: "test.lox", Line: 1, Column: 11, Start: 10, End: 10},
+			},
+		},
+		{
+			name:   "columns count runes, offsets count bytes",
+			source: "\"héllo\" +\n  x",
+			wantSpans: []token.Span{
+				{File: "test.lox", Line: 1, Column: 1, Start: 0, End: 8},
+				{File: "test.lox", Line: 1, Column: 9, Start: 9, End: 10},
+				{File: "test.lox", Line: 2, Column: 3, Start: 13, End: 14},
+				{File: "test.lox", Line: 2, Column: 4, Start: 14, End: 14},
+			},
+		},
+		{
+			name:   "multi-line string starts where it opens",
+			source: "\"a\nb\" c",
+			wantSpans: []token.Span{
+				{File: "test.lox", Line: 1, Column: 1, Start: 0, End: 5},
+				{File: "test.lox", Line: 2, Column: 4, Start: 6, End: 7},
+				{File: "test.lox", Line: 2, Column: 5, Start: 7, End: 7},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewFileScanner("test.lox", []byte(tc.source))
+			gotTokens, err := s.ScanTokens()
+			if err != nil {
+				t.Errorf("ScanTokens() has an unexpected err:\nerror:\n%v\n", err)
+				return
+			}
+
+			var gotSpans []token.Span
+			for _, tok := range gotTokens {
+				gotSpans = append(gotSpans, tok.Span())
+			}
+			assert.Equal(t, tc.wantSpans, gotSpans)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package token
+
+import "fmt"
+
+// A Span is a range of source text. Line and Column locate its first
+// character, while Start and End are byte offsets into the source, End being
+// exclusive. The zero Span is empty, and is used for nodes built without
+// positions, such as in tests.
+type Span struct {
+	File   string
+	Line   int
+	Column int
+	Start  int
+	End    int
+}
+
+// IsValid reports whether the span points at actual source text.
+func (s Span) IsValid() bool {
+	return s.Line > 0
+}
+
+// Join returns the smallest span covering both spans. Empty spans are
+// ignored, so joining the spans of a node's children gives the span of the
+// node.
+func (s Span) Join(other Span) Span {
+	if !other.IsValid() {
+		return s
+	}
+	if !s.IsValid() {
+		return other
+	}
+	joined := s
+	if other.Start < s.Start {
+		joined.Line = other.Line
+		joined.Column = other.Column
+		joined.Start = other.Start
+	}
+	if other.End > s.End {
+		joined.End = other.End
+	}
+	return joined
+}
+
+// String formats the start of the span the way compilers do, as
+// file:line:column.
+func (s Span) String() string {
+	if s.File == "" {
+		return fmt.Sprintf("%d:%d", s.Line, s.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Column)
+}
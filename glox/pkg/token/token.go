@@ -13,6 +13,26 @@ type Token struct {
 	// convert that textual representation of a value to the living runtime
 	// object that will be used by the interpreter later.
 	Literal interface{}
+
+	// File is the name of the source file, if there is one. Column is where
+	// the lexeme starts on Line, counting runes from 1. Start and End are the
+	// byte offsets of the lexeme in the source, End being exclusive.
+	File   string
+	Column int
+	Start  int
+	End    int
+
+	// Comments are the comments found between the previous token and this
+	// one, in the order they appear. The interpreters ignore them, but the
+	// formatter puts them back around the code.
+	Comments []Comment
+}
+
+// A Comment is a line comment, from its slashes to the end of the line, the
+// newline excluded.
+type Comment struct {
+	Text string
+	Span Span
 }
 
 func NewEofToken(line int) *Token {
@@ -25,3 +45,14 @@ func NewEofToken(line int) *Token {
 func (t *Token) String() string {
 	return fmt.Sprintf("type: %s with lexeme: %q with literal: %s", t.TokenType.String(), t.Lexeme, t.Literal)
 }
+
+// Span returns the range of source text covered by the token.
+func (t *Token) Span() Span {
+	return Span{
+		File:   t.File,
+		Line:   t.Line,
+		Column: t.Column,
+		Start:  t.Start,
+		End:    t.End,
+	}
+}
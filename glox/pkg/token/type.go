@@ -12,7 +12,10 @@ const (
 	RightParen
 	LeftBrace
 	RightBrace
+	LeftBracket
+	RightBracket
 	Comma
+	Colon
 	Dot
 	Minus
 	Plus
@@ -38,38 +41,52 @@ const (
 	// // Keywords.
 	Eof
 	And
+	Break
+	Catch
 	Class
+	Continue
 	Else
 	False
+	Finally
 	Fun
 	For
 	If
+	Import
 	Nil
 	Or
 	Print
 	Return
 	Super
 	This
+	Throw
 	True
+	Try
 	Var
 	While
 )
 
 var Keywords = map[string]Type{
-	"and":    And,
-	"class":  Class,
-	"else":   Else,
-	"false":  False,
-	"fun":    Fun,
-	"for":    For,
-	"if":     If,
-	"nil":    Nil,
-	"or":     Or,
-	"print":  Print,
-	"return": Return,
-	"super":  Super,
-	"this":   This,
-	"true":   True,
-	"var":    Var,
-	"while":  While,
+	"and":      And,
+	"break":    Break,
+	"catch":    Catch,
+	"class":    Class,
+	"continue": Continue,
+	"else":     Else,
+	"false":    False,
+	"finally":  Finally,
+	"fun":      Fun,
+	"for":      For,
+	"if":       If,
+	"import":   Import,
+	"nil":      Nil,
+	"or":       Or,
+	"print":    Print,
+	"return":   Return,
+	"super":    Super,
+	"this":     This,
+	"throw":    Throw,
+	"true":     True,
+	"try":      Try,
+	"var":      Var,
+	"while":    While,
 }
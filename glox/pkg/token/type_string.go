@@ -12,46 +12,56 @@ func _() {
 	_ = x[RightParen-1]
 	_ = x[LeftBrace-2]
 	_ = x[RightBrace-3]
-	_ = x[Comma-4]
-	_ = x[Dot-5]
-	_ = x[Minus-6]
-	_ = x[Plus-7]
-	_ = x[Semicolon-8]
-	_ = x[Slash-9]
-	_ = x[Star-10]
-	_ = x[Bang-11]
-	_ = x[BangEqual-12]
-	_ = x[Equal-13]
-	_ = x[EqualEqual-14]
-	_ = x[Greater-15]
-	_ = x[GreaterEqual-16]
-	_ = x[Less-17]
-	_ = x[LessEqual-18]
-	_ = x[Identifier-19]
-	_ = x[String-20]
-	_ = x[Number-21]
-	_ = x[Eof-22]
-	_ = x[And-23]
-	_ = x[Class-24]
-	_ = x[Else-25]
-	_ = x[False-26]
-	_ = x[Fun-27]
-	_ = x[For-28]
-	_ = x[If-29]
-	_ = x[Nil-30]
-	_ = x[Or-31]
-	_ = x[Print-32]
-	_ = x[Return-33]
-	_ = x[Super-34]
-	_ = x[This-35]
-	_ = x[True-36]
-	_ = x[Var-37]
-	_ = x[While-38]
+	_ = x[LeftBracket-4]
+	_ = x[RightBracket-5]
+	_ = x[Comma-6]
+	_ = x[Colon-7]
+	_ = x[Dot-8]
+	_ = x[Minus-9]
+	_ = x[Plus-10]
+	_ = x[Semicolon-11]
+	_ = x[Slash-12]
+	_ = x[Star-13]
+	_ = x[Bang-14]
+	_ = x[BangEqual-15]
+	_ = x[Equal-16]
+	_ = x[EqualEqual-17]
+	_ = x[Greater-18]
+	_ = x[GreaterEqual-19]
+	_ = x[Less-20]
+	_ = x[LessEqual-21]
+	_ = x[Identifier-22]
+	_ = x[String-23]
+	_ = x[Number-24]
+	_ = x[Eof-25]
+	_ = x[And-26]
+	_ = x[Break-27]
+	_ = x[Catch-28]
+	_ = x[Class-29]
+	_ = x[Continue-30]
+	_ = x[Else-31]
+	_ = x[False-32]
+	_ = x[Finally-33]
+	_ = x[Fun-34]
+	_ = x[For-35]
+	_ = x[If-36]
+	_ = x[Import-37]
+	_ = x[Nil-38]
+	_ = x[Or-39]
+	_ = x[Print-40]
+	_ = x[Return-41]
+	_ = x[Super-42]
+	_ = x[This-43]
+	_ = x[Throw-44]
+	_ = x[True-45]
+	_ = x[Try-46]
+	_ = x[Var-47]
+	_ = x[While-48]
 }
 
-const _Type_name = "LeftParenRightParenLeftBraceRightBraceCommaDotMinusPlusSemicolonSlashStarBangBangEqualEqualEqualEqualGreaterGreaterEqualLessLessEqualIdentifierStringNumberEofAndClassElseFalseFunForIfNilOrPrintReturnSuperThisTrueVarWhile"
+const _Type_name = "LeftParenRightParenLeftBraceRightBraceLeftBracketRightBracketCommaColonDotMinusPlusSemicolonSlashStarBangBangEqualEqualEqualEqualGreaterGreaterEqualLessLessEqualIdentifierStringNumberEofAndBreakCatchClassContinueElseFalseFinallyFunForIfImportNilOrPrintReturnSuperThisThrowTrueTryVarWhile"
 
-var _Type_index = [...]uint8{0, 9, 19, 28, 38, 43, 46, 51, 55, 64, 69, 73, 77, 86, 91, 101, 108, 120, 124, 133, 143, 149, 155, 158, 161, 166, 170, 175, 178, 181, 183, 186, 188, 193, 199, 204, 208, 212, 215, 220}
+var _Type_index = [...]uint16{0, 9, 19, 28, 38, 49, 61, 66, 71, 74, 79, 83, 92, 97, 101, 105, 114, 119, 129, 136, 148, 152, 161, 171, 177, 183, 186, 189, 194, 199, 204, 212, 216, 221, 228, 231, 234, 236, 242, 245, 247, 252, 258, 263, 267, 272, 276, 279, 282, 287}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
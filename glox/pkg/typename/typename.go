@@ -0,0 +1,41 @@
+// Package typename names the types of Lox values in the words of the language,
+// so that both backends describe the values in their errors alike.
+package typename
+
+// The names of the Lox types.
+const (
+	Nil      = "nil"
+	Boolean  = "boolean"
+	Number   = "number"
+	String   = "string"
+	List     = "list"
+	Map      = "map"
+	Function = "function"
+	Class    = "class"
+	Instance = "instance"
+)
+
+// A Typed value is an object of one of the backends, which knows its Lox
+// type. Nil, booleans, numbers and strings are plain Go values in both.
+type Typed interface {
+	LoxType() string
+}
+
+// Of returns the Lox type of a value of either backend.
+func Of(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return Nil
+	case bool:
+		return Boolean
+	case float64:
+		return Number
+	case string:
+		return String
+	case Typed:
+		return v.LoxType()
+	}
+	// Only values internal to a backend get here, which never reach a
+	// script.
+	return "unknown"
+}
@@ -0,0 +1,31 @@
+package typename
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type object struct{}
+
+func (object) LoxType() string {
+	return Instance
+}
+
+func TestOf(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, Nil},
+		{true, Boolean},
+		{1.5, Number},
+		{"a", String},
+		{object{}, Instance},
+		{struct{}{}, "unknown"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Of(tc.value))
+	}
+}
@@ -0,0 +1,135 @@
+package vm
+
+import (
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// An OpCode is the first byte of every instruction. The operands that follow
+// it, if any, are listed next to each opcode. Constant and jump operands take
+// two bytes, big-endian, every other operand takes one.
+type OpCode byte
+
+const (
+	// OpConstant pushes the constant at index (2 bytes).
+	OpConstant OpCode = iota
+	OpNil
+	OpTrue
+	OpFalse
+	OpPop
+	// Locals live on the stack, at slot (1 byte) from the frame's base.
+	OpGetLocal
+	OpSetLocal
+	// Globals are looked up by name, the string constant at index (2 bytes).
+	OpGetGlobal
+	OpDefineGlobal
+	OpSetGlobal
+	// Upvalues are captured variables, at index (1 byte) in the closure.
+	OpGetUpvalue
+	OpSetUpvalue
+	// Properties are named by the string constant at index (2 bytes).
+	OpGetProperty
+	OpSetProperty
+	OpGetSuper
+	OpEqual
+	OpGreater
+	OpGreaterEqual
+	OpLess
+	OpLessEqual
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpNot
+	OpNegate
+	OpPrint
+	// Jumps move forward by offset (2 bytes). OpLoop moves backward.
+	OpJump
+	OpJumpIfFalse
+	OpLoop
+	// OpCall calls the value below its argument count (1 byte) arguments.
+	OpCall
+	// OpInvoke calls the method named by the constant at index (2 bytes) with
+	// argument count (1 byte) arguments, without creating a bound method.
+	OpInvoke
+	OpSuperInvoke
+	// OpClosure wraps the function constant at index (2 bytes). It is followed
+	// by two bytes per upvalue: whether it captures a local of the enclosing
+	// function, rather than one of its upvalues, and the index of that.
+	OpClosure
+	OpCloseUpvalue
+	OpReturn
+	// OpClass creates a class named by the constant at index (2 bytes).
+	OpClass
+	OpInherit
+	OpMethod
+	// OpList and OpMap collect count (2 bytes) elements, or key and value
+	// pairs, from the stack.
+	OpList
+	OpMap
+	OpGetIndex
+	OpSetIndex
+	OpThrow
+	// OpTry and OpTryFinally install a handler for the errors raised before
+	// the matching OpEndTry, at offset (2 bytes) forward. A catch handler
+	// receives the caught value, while a finally handler receives the error
+	// to raise again with OpRethrow once the finally block is done.
+	OpTry
+	OpTryFinally
+	OpEndTry
+	OpRethrow
+	// OpImport loads the module at the path named by the constant at index
+	// (2 bytes), and pushes it. OpImportName defines the global named by the
+	// constant at index (2 bytes) from the module on top of the stack, while
+	// OpImportAll pops the module and defines all its globals.
+	OpImport
+	OpImportName
+	OpImportAll
+)
+
+// A Chunk is a sequence of instructions along with the constants they refer
+// to. Each byte of code has the span of the source it was compiled from, so
+// that runtime errors can point at it.
+type Chunk struct {
+	Code      []byte
+	Spans     []token.Span
+	Constants []Value
+}
+
+func (c *Chunk) write(b byte, span token.Span) {
+	c.Code = append(c.Code, b)
+	c.Spans = append(c.Spans, span)
+}
+
+// addConstant returns the index of the value in the constant pool, reusing
+// the slot of an equal number or string.
+func (c *Chunk) addConstant(value Value) int {
+	switch value.(type) {
+	case float64, string:
+		for index, constant := range c.Constants {
+			if constant == value {
+				return index
+			}
+		}
+	}
+	c.Constants = append(c.Constants, value)
+	return len(c.Constants) - 1
+}
+
+// A Function is the compiled code of a function declaration, a lambda or a
+// whole script. Closures are created from it at runtime.
+type Function struct {
+	Name         string
+	Arity        int
+	UpvalueCount int
+	// The most locals in scope at once, slot 0 included, which is how many
+	// stack slots its frames need.
+	FrameSize int
+	Chunk     Chunk
+}
+
+func (f *Function) String() string {
+	if f.Name == "" {
+		return "<script>"
+	}
+	return "<fn " + f.Name + ">"
+}
@@ -0,0 +1,876 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/modulitos/glox/pkg/ast"
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// The operands holding slots, upvalue indexes and argument counts are a single
+// byte.
+const maxLocals = math.MaxUint8 + 1
+
+// Compile compiles a program, which the resolver already checked, into the
+// function of its top-level script. Like interpreter.Evaluate, the script
+// returns the value of its last statement if that is an expression statement.
+//
+// The compiler walks the syntax tree once, emitting code as it goes, and
+// resolves local variables to stack slots and upvalues the way clox does.
+func Compile(stmts []ast.Stmt) (*Function, error) {
+	var errors diagnostic.List
+	c := newCompiler(nil, functionTypeScript, "", &errors)
+	for index, stmt := range stmts {
+		if expr, ok := stmt.(*ast.ExpressionStmt); ok && index == len(stmts)-1 {
+			expr.Expression.Accept(c)
+			c.emitOp(OpReturn, expr.Expression.Span())
+			return c.function, errors.Err()
+		}
+		c.compileStmt(stmt)
+	}
+	c.emitReturn(token.Span{})
+	return c.function, errors.Err()
+}
+
+// Tracks what kind of function is being compiled, since methods keep their
+// receiver in slot 0 and initializers always return it.
+type functionType int
+
+const (
+	functionTypeScript = functionType(iota)
+	functionTypeFunction
+	functionTypeMethod
+	functionTypeInitializer
+)
+
+type local struct {
+	name string
+	// The scope depth of the block declaring the local.
+	depth int
+	// Whether a closure captured the local, in which case it has to be moved
+	// off the stack when it goes out of scope.
+	captured bool
+}
+
+type upvalueRef struct {
+	index   byte
+	isLocal bool
+}
+
+// A loop being compiled, so that break and continue know where to jump and
+// what to clean up on the way.
+type loop struct {
+	// The scope depth outside of the loop body.
+	scopeDepth int
+	// How many try blocks were open when the loop started.
+	tries         int
+	breakJumps    []int
+	continueJumps []int
+}
+
+// A try block being compiled, which installed a handler at runtime. Jumping
+// out of it removes the handler, and runs its finally block if it has one.
+type tryBlock struct {
+	finally []ast.Stmt
+}
+
+// A compiler compiles a single function. Compilers of nested functions link to
+// the compilers of the functions enclosing them, to resolve upvalues.
+type compiler struct {
+	enclosing  *compiler
+	function   *Function
+	kind       functionType
+	locals     []local
+	upvalues   []upvalueRef
+	scopeDepth int
+	loops      []loop
+	tries      []tryBlock
+	// Shared by every compiler of the program.
+	errors *diagnostic.List
+}
+
+func newCompiler(enclosing *compiler, kind functionType, name string, errors *diagnostic.List) *compiler {
+	c := &compiler{
+		enclosing: enclosing,
+		function:  &Function{Name: name, FrameSize: 1},
+		kind:      kind,
+		errors:    errors,
+	}
+	// Slot 0 holds the function being called, or the receiver in methods,
+	// where it can be read as `this`.
+	slotZero := ""
+	if kind == functionTypeMethod || kind == functionTypeInitializer {
+		slotZero = "this"
+	}
+	c.locals = append(c.locals, local{name: slotZero})
+	return c
+}
+
+func (c *compiler) error(code diagnostic.Code, span token.Span, format string, args ...interface{}) {
+	c.errors.Add(diagnostic.Errorf(code, span, format, args...))
+}
+
+func (c *compiler) chunk() *Chunk {
+	return &c.function.Chunk
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Emitting code
+////////////////////////////////////////////////////////////////////////////////
+
+func (c *compiler) emitByte(b byte, span token.Span) {
+	c.chunk().write(b, span)
+}
+
+func (c *compiler) emitOp(op OpCode, span token.Span) {
+	c.emitByte(byte(op), span)
+}
+
+func (c *compiler) emitShort(value int, span token.Span) {
+	c.emitByte(byte(value>>8), span)
+	c.emitByte(byte(value), span)
+}
+
+// emitOpShort emits an instruction with a two byte operand, which gets its
+// own span: runtime errors about the operand, like an undefined property,
+// point there rather than at the instruction.
+func (c *compiler) emitOpShort(op OpCode, operand int, opSpan token.Span, operandSpan token.Span) {
+	c.emitOp(op, opSpan)
+	c.emitShort(operand, operandSpan)
+}
+
+func (c *compiler) makeConstant(value Value, span token.Span) int {
+	index := c.chunk().addConstant(value)
+	if index > math.MaxUint16 {
+		c.error(diagnostic.TooManyConstants, span, "Too many constants in one chunk.")
+		return 0
+	}
+	return index
+}
+
+func (c *compiler) emitConstant(value Value, span token.Span) {
+	c.emitOpShort(OpConstant, c.makeConstant(value, span), span, span)
+}
+
+// emitJump emits a jump with a placeholder offset, and returns the position
+// of the offset for patchJump to fill in.
+func (c *compiler) emitJump(op OpCode, span token.Span) int {
+	c.emitOp(op, span)
+	c.emitShort(0xffff, span)
+	return len(c.chunk().Code) - 2
+}
+
+// patchJump makes the jump at offset land on the next instruction emitted.
+func (c *compiler) patchJump(offset int) {
+	code := c.chunk().Code
+	jump := len(code) - offset - 2
+	if jump > math.MaxUint16 {
+		c.error(diagnostic.JumpTooLarge, c.chunk().Spans[offset], "Too much code to jump over.")
+	}
+	code[offset] = byte(jump >> 8)
+	code[offset+1] = byte(jump)
+}
+
+func (c *compiler) emitLoop(start int, span token.Span) {
+	c.emitOp(OpLoop, span)
+	offset := len(c.chunk().Code) - start + 2
+	if offset > math.MaxUint16 {
+		c.error(diagnostic.JumpTooLarge, span, "Loop body too large.")
+	}
+	c.emitShort(offset, span)
+}
+
+func (c *compiler) emitReturn(span token.Span) {
+	if c.kind == functionTypeInitializer {
+		c.emitOp(OpGetLocal, span)
+		c.emitByte(0, span)
+	} else {
+		c.emitOp(OpNil, span)
+	}
+	c.emitOp(OpReturn, span)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Variables and scopes
+////////////////////////////////////////////////////////////////////////////////
+
+func (c *compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *compiler) endScope(span token.Span) {
+	c.discardLocals(c.scopeDepth-1, span)
+	c.scopeDepth--
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+// discardLocals emits the code removing the locals deeper than depth from the
+// stack, but leaves them declared. break and continue use it to leave scopes
+// that the code following them is still in.
+func (c *compiler) discardLocals(depth int, span token.Span) {
+	for index := len(c.locals) - 1; index >= 0 && c.locals[index].depth > depth; index-- {
+		if c.locals[index].captured {
+			c.emitOp(OpCloseUpvalue, span)
+		} else {
+			c.emitOp(OpPop, span)
+		}
+	}
+}
+
+func (c *compiler) addLocal(name string, span token.Span) {
+	if len(c.locals) == maxLocals {
+		c.error(diagnostic.TooManyLocals, span, "Too many local variables in function.")
+		return
+	}
+	c.locals = append(c.locals, local{name: name, depth: c.scopeDepth})
+	if len(c.locals) > c.function.FrameSize {
+		c.function.FrameSize = len(c.locals)
+	}
+}
+
+// defineVariable binds the value on top of the stack to name. Locals simply
+// stay where they are on the stack.
+func (c *compiler) defineVariable(name *token.Token) {
+	if c.scopeDepth > 0 {
+		c.addLocal(name.Lexeme, name.Span())
+		return
+	}
+	c.emitOpShort(OpDefineGlobal, c.makeConstant(name.Lexeme, name.Span()), name.Span(), name.Span())
+}
+
+func (c *compiler) resolveLocal(name string) int {
+	for index := len(c.locals) - 1; index >= 0; index-- {
+		if c.locals[index].name == name {
+			return index
+		}
+	}
+	return -1
+}
+
+// resolveUpvalue looks for the variable in the enclosing functions. Each
+// function between the one declaring it and this one gets an upvalue for it,
+// so that closures only ever capture from the function right around them.
+func (c *compiler) resolveUpvalue(name string, span token.Span) int {
+	if c.enclosing == nil {
+		return -1
+	}
+	if slot := c.enclosing.resolveLocal(name); slot != -1 {
+		c.enclosing.locals[slot].captured = true
+		return c.addUpvalue(byte(slot), true, span)
+	}
+	if index := c.enclosing.resolveUpvalue(name, span); index != -1 {
+		return c.addUpvalue(byte(index), false, span)
+	}
+	return -1
+}
+
+func (c *compiler) addUpvalue(index byte, isLocal bool, span token.Span) int {
+	for i, upvalue := range c.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return i
+		}
+	}
+	if len(c.upvalues) == maxLocals {
+		c.error(diagnostic.TooManyUpvalues, span, "Too many closure variables in function.")
+		return 0
+	}
+	c.upvalues = append(c.upvalues, upvalueRef{index: index, isLocal: isLocal})
+	c.function.UpvalueCount = len(c.upvalues)
+	return len(c.upvalues) - 1
+}
+
+// namedVariable emits the code reading the variable, or assigning the value
+// on top of the stack to it.
+func (c *compiler) namedVariable(name string, span token.Span, assign bool) {
+	getOp, setOp := OpGetLocal, OpSetLocal
+	index := c.resolveLocal(name)
+	if index == -1 {
+		if index = c.resolveUpvalue(name, span); index != -1 {
+			getOp, setOp = OpGetUpvalue, OpSetUpvalue
+		}
+	}
+	op := getOp
+	if assign {
+		op = setOp
+	}
+	if index != -1 {
+		c.emitOp(op, span)
+		c.emitByte(byte(index), span)
+		return
+	}
+
+	op = OpGetGlobal
+	if assign {
+		op = OpSetGlobal
+	}
+	c.emitOpShort(op, c.makeConstant(name, span), span, span)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Functions
+////////////////////////////////////////////////////////////////////////////////
+
+// compileFunction compiles a function body with a compiler of its own, and
+// emits the code creating a closure of it.
+func (c *compiler) compileFunction(name string, kind functionType, params []*token.Token, body []ast.Stmt, span token.Span, end token.Span) {
+	fc := newCompiler(c, kind, name, c.errors)
+	fc.function.Arity = len(params)
+	fc.beginScope()
+	for _, param := range params {
+		fc.addLocal(param.Lexeme, param.Span())
+	}
+	fc.compileStmts(body)
+	fc.emitReturn(end)
+
+	c.emitOpShort(OpClosure, c.makeConstant(fc.function, span), span, span)
+	for _, upvalue := range fc.upvalues {
+		isLocal := byte(0)
+		if upvalue.isLocal {
+			isLocal = 1
+		}
+		c.emitByte(isLocal, span)
+		c.emitByte(upvalue.index, span)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Jumping out of loops, try blocks and functions
+////////////////////////////////////////////////////////////////////////////////
+
+// exitTries emits the code leaving the try blocks opened after the first
+// `outer` ones: it removes their handlers and runs their finally blocks,
+// innermost first. A finally block compiled there only sees the try blocks
+// around it, so that jumping out of it doesn't run it again.
+func (c *compiler) exitTries(outer int, span token.Span) {
+	tries := c.tries
+	defer func() {
+		c.tries = tries
+	}()
+	for index := len(tries) - 1; index >= outer; index-- {
+		c.emitOp(OpEndTry, span)
+		if tries[index].finally != nil {
+			c.tries = tries[:index]
+			c.block(tries[index].finally, span)
+		}
+	}
+}
+
+func (c *compiler) block(stmts []ast.Stmt, end token.Span) {
+	c.beginScope()
+	c.compileStmts(stmts)
+	c.endScope(end)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Statements
+////////////////////////////////////////////////////////////////////////////////
+
+func (c *compiler) compileStmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		c.compileStmt(stmt)
+	}
+}
+
+func (c *compiler) compileStmt(stmt ast.Stmt) {
+	stmt.Accept(c)
+}
+
+func (c *compiler) compileExpr(expr ast.Expr) {
+	expr.Accept(c)
+}
+
+func (c *compiler) VisitExpression(stmt *ast.ExpressionStmt) error {
+	c.compileExpr(stmt.Expression)
+	c.emitOp(OpPop, stmt.Span())
+	return nil
+}
+
+func (c *compiler) VisitPrint(stmt *ast.PrintStmt) error {
+	c.compileExpr(stmt.Expression)
+	c.emitOp(OpPrint, stmt.Keyword.Span())
+	return nil
+}
+
+// The initializer is compiled before the local is added, so it can't refer to
+// the variable it initializes. The resolver already reported that anyway.
+func (c *compiler) VisitVar(stmt *ast.VarStmt) error {
+	if stmt.Initializer != nil {
+		c.compileExpr(stmt.Initializer)
+	} else {
+		c.emitOp(OpNil, stmt.Name.Span())
+	}
+	c.defineVariable(stmt.Name)
+	return nil
+}
+
+// The blocks that desugared for loops are wrapped in have no braces, so the
+// code leaving them points at the whole block.
+func (c *compiler) VisitBlock(stmt *ast.BlockStmt) error {
+	c.block(stmt.Statements, stmt.Span())
+	return nil
+}
+
+// A local function is declared before its body is compiled, so that it can
+// call itself.
+func (c *compiler) VisitFunction(stmt *ast.FunctionStmt) error {
+	if c.scopeDepth > 0 {
+		c.addLocal(stmt.Name.Lexeme, stmt.Name.Span())
+	}
+	c.compileFunction(stmt.Name.Lexeme, functionTypeFunction, stmt.Params, stmt.Body, stmt.Name.Span(), stmt.RightBrace.Span())
+	if c.scopeDepth == 0 {
+		c.defineVariable(stmt.Name)
+	}
+	return nil
+}
+
+func (c *compiler) VisitIf(stmt *ast.IfStmt) error {
+	c.compileExpr(stmt.Condition)
+	thenJump := c.emitJump(OpJumpIfFalse, stmt.Keyword.Span())
+	c.emitOp(OpPop, stmt.Keyword.Span())
+	c.compileStmt(stmt.ThenBranch)
+	elseJump := c.emitJump(OpJump, stmt.Keyword.Span())
+	c.patchJump(thenJump)
+	c.emitOp(OpPop, stmt.Keyword.Span())
+	if stmt.ElseBranch != nil {
+		c.compileStmt(stmt.ElseBranch)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+// Loops are laid out with the increment of desugared for loops between the
+// body and the jump back, so that continue can jump to it:
+//
+//	start: condition
+//	       OpJumpIfFalse exit
+//	       OpPop
+//	       body
+//	       increment, OpPop
+//	       OpLoop start
+//	exit:  OpPop
+func (c *compiler) VisitWhile(stmt *ast.WhileStmt) error {
+	span := stmt.Keyword.Span()
+	start := len(c.chunk().Code)
+	c.compileExpr(stmt.Condition)
+	exitJump := c.emitJump(OpJumpIfFalse, span)
+	c.emitOp(OpPop, span)
+
+	c.loops = append(c.loops, loop{scopeDepth: c.scopeDepth, tries: len(c.tries)})
+	c.compileStmt(stmt.Body)
+	current := c.loops[len(c.loops)-1]
+	c.loops = c.loops[:len(c.loops)-1]
+
+	for _, jump := range current.continueJumps {
+		c.patchJump(jump)
+	}
+	if stmt.Increment != nil {
+		c.compileExpr(stmt.Increment)
+		c.emitOp(OpPop, span)
+	}
+	c.emitLoop(start, span)
+	c.patchJump(exitJump)
+	c.emitOp(OpPop, span)
+	for _, jump := range current.breakJumps {
+		c.patchJump(jump)
+	}
+	return nil
+}
+
+// jumpOutOfLoop leaves the try blocks and scopes opened inside the innermost
+// loop, and emits a jump with an offset to patch once its target is known.
+func (c *compiler) jumpOutOfLoop(keyword *token.Token) int {
+	current := c.loops[len(c.loops)-1]
+	c.exitTries(current.tries, keyword.Span())
+	c.discardLocals(current.scopeDepth, keyword.Span())
+	return c.emitJump(OpJump, keyword.Span())
+}
+
+func (c *compiler) VisitBreak(stmt *ast.BreakStmt) error {
+	jump := c.jumpOutOfLoop(stmt.Keyword)
+	current := &c.loops[len(c.loops)-1]
+	current.breakJumps = append(current.breakJumps, jump)
+	return nil
+}
+
+func (c *compiler) VisitContinue(stmt *ast.ContinueStmt) error {
+	jump := c.jumpOutOfLoop(stmt.Keyword)
+	current := &c.loops[len(c.loops)-1]
+	current.continueJumps = append(current.continueJumps, jump)
+	return nil
+}
+
+// Returning from inside try blocks runs their finally blocks first, while the
+// value to return waits on the stack as a hidden local.
+func (c *compiler) VisitReturn(stmt *ast.ReturnStmt) error {
+	span := stmt.Keyword.Span()
+	if len(c.tries) == 0 {
+		if stmt.Value == nil {
+			c.emitReturn(span)
+			return nil
+		}
+		c.compileExpr(stmt.Value)
+		c.emitOp(OpReturn, span)
+		return nil
+	}
+
+	if stmt.Value == nil {
+		if c.kind == functionTypeInitializer {
+			c.emitOp(OpGetLocal, span)
+			c.emitByte(0, span)
+		} else {
+			c.emitOp(OpNil, span)
+		}
+	} else {
+		c.compileExpr(stmt.Value)
+	}
+	c.beginScope()
+	c.addLocal("", span)
+	c.exitTries(0, span)
+	c.emitOp(OpReturn, span)
+	c.scopeDepth--
+	c.locals = c.locals[:len(c.locals)-1]
+	return nil
+}
+
+func (c *compiler) VisitThrow(stmt *ast.ThrowStmt) error {
+	c.compileExpr(stmt.Value)
+	c.emitOp(OpThrow, stmt.Keyword.Span())
+	return nil
+}
+
+// A try statement installs a handler for its body, which jumps to the catch
+// clause with the caught value on the stack. A finally block gets a handler of
+// its own around both, and is also compiled on every way out of them: falling
+// off the end, and jumping out with break, continue or return.
+//
+//	       OpTryFinally handler
+//	       OpTry catch
+//	       body
+//	       OpEndTry
+//	       OpJump done
+//	catch: catch body
+//	done:  OpEndTry
+//	       finally body
+//	       OpJump end
+//	handler:
+//	       finally body
+//	       OpRethrow
+//	end:
+func (c *compiler) VisitTry(stmt *ast.TryStmt) error {
+	span := stmt.Keyword.Span()
+	end := stmt.RightBrace.Span()
+
+	var finallyHandler int
+	if stmt.FinallyBody != nil {
+		finallyHandler = c.emitJump(OpTryFinally, span)
+		c.tries = append(c.tries, tryBlock{finally: stmt.FinallyBody})
+	}
+
+	if stmt.CatchName != nil {
+		catchHandler := c.emitJump(OpTry, span)
+		c.tries = append(c.tries, tryBlock{})
+		c.block(stmt.Body, end)
+		c.tries = c.tries[:len(c.tries)-1]
+		c.emitOp(OpEndTry, span)
+		done := c.emitJump(OpJump, span)
+
+		// The handler pushed the caught value, which becomes the catch
+		// variable.
+		c.patchJump(catchHandler)
+		c.beginScope()
+		c.addLocal(stmt.CatchName.Lexeme, stmt.CatchName.Span())
+		c.compileStmts(stmt.CatchBody)
+		c.endScope(end)
+		c.patchJump(done)
+	} else {
+		c.block(stmt.Body, end)
+	}
+
+	if stmt.FinallyBody != nil {
+		c.tries = c.tries[:len(c.tries)-1]
+		c.emitOp(OpEndTry, span)
+		c.block(stmt.FinallyBody, end)
+		skip := c.emitJump(OpJump, span)
+
+		// The handler pushed the error to raise again, which sits in a
+		// hidden local while the finally block runs.
+		c.patchJump(finallyHandler)
+		c.beginScope()
+		c.addLocal("", span)
+		c.block(stmt.FinallyBody, end)
+		c.emitOp(OpRethrow, span)
+		c.scopeDepth--
+		c.locals = c.locals[:len(c.locals)-1]
+		c.patchJump(skip)
+	}
+	return nil
+}
+
+// The module is loaded onto the stack, then the names imported from it are
+// defined as globals, which is all imports can define since the resolver only
+// allows them at the top level.
+func (c *compiler) VisitImport(stmt *ast.ImportStmt) error {
+	keyword, path := stmt.Keyword.Span(), stmt.Path.Span()
+	c.emitOpShort(OpImport, c.makeConstant(stmt.Path.Literal.(string), path), keyword, path)
+	if len(stmt.Names) == 0 {
+		c.emitOp(OpImportAll, keyword)
+		return nil
+	}
+	for _, name := range stmt.Names {
+		c.emitOpShort(OpImportName, c.makeConstant(name.Lexeme, name.Span()), keyword, name.Span())
+	}
+	c.emitOp(OpPop, keyword)
+	return nil
+}
+
+// The class is created first, then its methods are added to it one by one.
+// A subclass keeps its superclass in a local named `super` while its methods
+// are compiled, which they capture like any other variable.
+func (c *compiler) VisitClass(stmt *ast.ClassStmt) error {
+	name := stmt.Name.Span()
+	c.emitOpShort(OpClass, c.makeConstant(stmt.Name.Lexeme, name), name, name)
+	c.defineVariable(stmt.Name)
+
+	if stmt.Superclass != nil {
+		c.namedVariable(stmt.Superclass.Name.Lexeme, stmt.Superclass.Span(), false)
+		c.beginScope()
+		c.addLocal("super", stmt.Superclass.Span())
+		c.namedVariable(stmt.Name.Lexeme, name, false)
+		c.emitOp(OpInherit, stmt.Superclass.Span())
+	}
+
+	c.namedVariable(stmt.Name.Lexeme, name, false)
+	for _, method := range stmt.Methods {
+		kind := functionTypeMethod
+		if method.Name.Lexeme == "init" {
+			kind = functionTypeInitializer
+		}
+		methodName := method.Name.Span()
+		c.compileFunction(method.Name.Lexeme, kind, method.Params, method.Body, methodName, method.RightBrace.Span())
+		c.emitOpShort(OpMethod, c.makeConstant(method.Name.Lexeme, methodName), methodName, methodName)
+	}
+	c.emitOp(OpPop, name)
+
+	if stmt.Superclass != nil {
+		c.endScope(stmt.RightBrace.Span())
+	}
+	return nil
+}
+
+func (c *compiler) VisitBadStmt(stmt *ast.BadStmt) error {
+	c.error(diagnostic.RuntimeError, stmt.Span(), "Can't run code with syntax errors.")
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Expressions
+////////////////////////////////////////////////////////////////////////////////
+
+func (c *compiler) VisitLiteral(expr *ast.LiteralExpr) (interface{}, error) {
+	span := expr.Span()
+	switch value := expr.Value.(type) {
+	case nil:
+		c.emitOp(OpNil, span)
+	case bool:
+		if value {
+			c.emitOp(OpTrue, span)
+		} else {
+			c.emitOp(OpFalse, span)
+		}
+	default:
+		c.emitConstant(value, span)
+	}
+	return nil, nil
+}
+
+func (c *compiler) VisitGrouping(expr *ast.GroupingExpr) (interface{}, error) {
+	c.compileExpr(expr.Expression)
+	return nil, nil
+}
+
+func (c *compiler) VisitUnary(expr *ast.UnaryExpr) (interface{}, error) {
+	c.compileExpr(expr.Right)
+	switch expr.Operator.TokenType {
+	case token.Minus:
+		c.emitOp(OpNegate, expr.Operator.Span())
+	case token.Bang:
+		c.emitOp(OpNot, expr.Operator.Span())
+	}
+	return nil, nil
+}
+
+var binaryOps = map[token.Type]OpCode{
+	token.Plus:         OpAdd,
+	token.Minus:        OpSubtract,
+	token.Star:         OpMultiply,
+	token.Slash:        OpDivide,
+	token.Greater:      OpGreater,
+	token.GreaterEqual: OpGreaterEqual,
+	token.Less:         OpLess,
+	token.LessEqual:    OpLessEqual,
+	token.EqualEqual:   OpEqual,
+}
+
+func (c *compiler) VisitBinary(expr *ast.BinaryExpr) (interface{}, error) {
+	c.compileExpr(expr.Left)
+	c.compileExpr(expr.Right)
+	span := expr.Operator.Span()
+	if expr.Operator.TokenType == token.BangEqual {
+		c.emitOp(OpEqual, span)
+		c.emitOp(OpNot, span)
+		return nil, nil
+	}
+	op, ok := binaryOps[expr.Operator.TokenType]
+	if !ok {
+		panic(fmt.Sprintf("Implementation error: no opcode for binary operator %s.", expr.Operator.Lexeme))
+	}
+	c.emitOp(op, span)
+	return nil, nil
+}
+
+// `and` and `or` short-circuit, leaving the left operand as their value when
+// it decides the result.
+func (c *compiler) VisitLogical(expr *ast.LogicalExpr) (interface{}, error) {
+	span := expr.Operator.Span()
+	c.compileExpr(expr.Left)
+	if expr.Operator.TokenType == token.Or {
+		elseJump := c.emitJump(OpJumpIfFalse, span)
+		endJump := c.emitJump(OpJump, span)
+		c.patchJump(elseJump)
+		c.emitOp(OpPop, span)
+		c.compileExpr(expr.Right)
+		c.patchJump(endJump)
+		return nil, nil
+	}
+	endJump := c.emitJump(OpJumpIfFalse, span)
+	c.emitOp(OpPop, span)
+	c.compileExpr(expr.Right)
+	c.patchJump(endJump)
+	return nil, nil
+}
+
+func (c *compiler) VisitVariable(expr *ast.VariableExpr) (interface{}, error) {
+	c.namedVariable(expr.Name.Lexeme, expr.Name.Span(), false)
+	return nil, nil
+}
+
+func (c *compiler) VisitAssign(expr *ast.AssignExpr) (interface{}, error) {
+	c.compileExpr(expr.Value)
+	c.namedVariable(expr.Name.Lexeme, expr.Name.Span(), true)
+	return nil, nil
+}
+
+// Calling a method right away invokes it, without creating a bound method.
+func (c *compiler) VisitCall(expr *ast.CallExpr) (interface{}, error) {
+	paren := expr.Paren.Span()
+	switch callee := expr.Callee.(type) {
+	case *ast.GetExpr:
+		c.compileExpr(callee.Object)
+		c.compileArgs(expr.Args)
+		c.emitOpShort(OpInvoke, c.makeConstant(callee.Name.Lexeme, callee.Name.Span()), paren, callee.Name.Span())
+		c.emitByte(byte(len(expr.Args)), paren)
+		return nil, nil
+	case *ast.SuperExpr:
+		c.namedVariable("this", callee.Keyword.Span(), false)
+		c.compileArgs(expr.Args)
+		c.namedVariable("super", callee.Keyword.Span(), false)
+		c.emitOpShort(OpSuperInvoke, c.makeConstant(callee.Method.Lexeme, callee.Method.Span()), paren, callee.Method.Span())
+		c.emitByte(byte(len(expr.Args)), paren)
+		return nil, nil
+	}
+	c.compileExpr(expr.Callee)
+	c.compileArgs(expr.Args)
+	c.emitOp(OpCall, paren)
+	c.emitByte(byte(len(expr.Args)), paren)
+	return nil, nil
+}
+
+func (c *compiler) compileArgs(args []ast.Expr) {
+	for _, arg := range args {
+		c.compileExpr(arg)
+	}
+}
+
+func (c *compiler) VisitGet(expr *ast.GetExpr) (interface{}, error) {
+	c.compileExpr(expr.Object)
+	name := expr.Name.Span()
+	c.emitOpShort(OpGetProperty, c.makeConstant(expr.Name.Lexeme, name), name, name)
+	return nil, nil
+}
+
+func (c *compiler) VisitSet(expr *ast.SetExpr) (interface{}, error) {
+	c.compileExpr(expr.Object)
+	c.compileExpr(expr.Value)
+	name := expr.Name.Span()
+	c.emitOpShort(OpSetProperty, c.makeConstant(expr.Name.Lexeme, name), name, name)
+	return nil, nil
+}
+
+func (c *compiler) VisitThis(expr *ast.ThisExpr) (interface{}, error) {
+	c.namedVariable("this", expr.Keyword.Span(), false)
+	return nil, nil
+}
+
+func (c *compiler) VisitSuper(expr *ast.SuperExpr) (interface{}, error) {
+	c.namedVariable("this", expr.Keyword.Span(), false)
+	c.namedVariable("super", expr.Keyword.Span(), false)
+	method := expr.Method.Span()
+	c.emitOpShort(OpGetSuper, c.makeConstant(expr.Method.Lexeme, method), method, method)
+	return nil, nil
+}
+
+// Anonymous functions are named after where they were written, like in the
+// tree-walking interpreter.
+func (c *compiler) VisitLambda(expr *ast.LambdaExpr) (interface{}, error) {
+	name := fmt.Sprintf("anonymous@line %d", expr.Keyword.Line)
+	c.compileFunction(name, functionTypeFunction, expr.Params, expr.Body, expr.Keyword.Span(), expr.RightBrace.Span())
+	return nil, nil
+}
+
+func (c *compiler) emitCount(op OpCode, count int, span token.Span) {
+	if count > math.MaxUint16 {
+		c.error(diagnostic.TooManyConstants, span, "Too many elements in one literal.")
+	}
+	c.emitOpShort(op, count, span, span)
+}
+
+func (c *compiler) VisitList(expr *ast.ListExpr) (interface{}, error) {
+	for _, element := range expr.Elements {
+		c.compileExpr(element)
+	}
+	c.emitCount(OpList, len(expr.Elements), expr.RightBracket.Span())
+	return nil, nil
+}
+
+func (c *compiler) VisitMap(expr *ast.MapExpr) (interface{}, error) {
+	for index := range expr.Keys {
+		c.compileExpr(expr.Keys[index])
+		c.compileExpr(expr.Values[index])
+	}
+	c.emitCount(OpMap, len(expr.Keys), expr.RightBrace.Span())
+	return nil, nil
+}
+
+func (c *compiler) VisitIndex(expr *ast.IndexExpr) (interface{}, error) {
+	c.compileExpr(expr.Object)
+	c.compileExpr(expr.Index)
+	c.emitOp(OpGetIndex, expr.Bracket.Span())
+	return nil, nil
+}
+
+func (c *compiler) VisitIndexSet(expr *ast.IndexSetExpr) (interface{}, error) {
+	c.compileExpr(expr.Object)
+	c.compileExpr(expr.Index)
+	c.compileExpr(expr.Value)
+	c.emitOp(OpSetIndex, expr.Bracket.Span())
+	return nil, nil
+}
+
+func (c *compiler) VisitBadExpr(expr *ast.BadExpr) (interface{}, error) {
+	c.error(diagnostic.RuntimeError, expr.Span(), "Can't run code with syntax errors.")
+	return nil, nil
+}
@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Disassemble prints the instructions of the function, followed by the ones of
+// every function nested in it, in the order they are declared.
+//
+// Each instruction is printed on its own line, as its offset in the chunk, the
+// source line it was compiled from, or "|" when it is on the same line as the
+// previous instruction, its opcode and its decoded operands:
+//
+//	== <fn add> ==
+//	0000    1 OpGetLocal           1
+//	0002    | OpGetLocal           2
+//	0004    | OpAdd
+//	0005    | OpReturn
+func Disassemble(w io.Writer, function *Function) {
+	fmt.Fprintf(w, "== %s ==\n", function)
+	chunk := &function.Chunk
+	for offset := 0; offset < len(chunk.Code); {
+		offset = DisassembleInstruction(w, chunk, offset)
+	}
+	for _, constant := range chunk.Constants {
+		if nested, ok := constant.(*Function); ok {
+			fmt.Fprintln(w)
+			Disassemble(w, nested)
+		}
+	}
+}
+
+// DisassembleInstruction prints the instruction at offset, and returns the
+// offset of the next one.
+func DisassembleInstruction(w io.Writer, chunk *Chunk, offset int) int {
+	fmt.Fprintf(w, "%04d ", offset)
+	line := chunk.Spans[offset].Line
+	switch {
+	case offset > 0 && line == chunk.Spans[offset-1].Line:
+		fmt.Fprint(w, "   | ")
+	case line == 0:
+		// Code the compiler added, like the implicit return at the end of a
+		// function, has no source.
+		fmt.Fprint(w, "   - ")
+	default:
+		fmt.Fprintf(w, "%4d ", line)
+	}
+
+	op := OpCode(chunk.Code[offset])
+	switch op {
+	case OpConstant, OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty, OpSetProperty,
+		OpGetSuper, OpClass, OpMethod, OpImport, OpImportName:
+		index := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-20s %4d %s\n", op, index, constantString(chunk, index))
+		return offset + 3
+	case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall:
+		fmt.Fprintf(w, "%-20s %4d\n", op, chunk.Code[offset+1])
+		return offset + 2
+	case OpList, OpMap:
+		fmt.Fprintf(w, "%-20s %4d\n", op, chunk.readShort(offset+1))
+		return offset + 3
+	case OpJump, OpJumpIfFalse, OpTry, OpTryFinally:
+		jump := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-20s %4d -> %04d\n", op, offset, offset+3+jump)
+		return offset + 3
+	case OpLoop:
+		jump := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-20s %4d -> %04d\n", op, offset, offset+3-jump)
+		return offset + 3
+	case OpInvoke, OpSuperInvoke:
+		index := chunk.readShort(offset + 1)
+		argCount := chunk.Code[offset+3]
+		fmt.Fprintf(w, "%-20s %4d %s (%d args)\n", op, index, constantString(chunk, index), argCount)
+		return offset + 4
+	case OpClosure:
+		index := chunk.readShort(offset + 1)
+		fmt.Fprintf(w, "%-20s %4d %s\n", op, index, constantString(chunk, index))
+		offset += 3
+		function := chunk.Constants[index].(*Function)
+		for upvalue := 0; upvalue < function.UpvalueCount; upvalue++ {
+			kind := "upvalue"
+			if chunk.Code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(w, "%04d    |   %-20s %s %d\n", offset, "", kind, chunk.Code[offset+1])
+			offset += 2
+		}
+		return offset
+	}
+	fmt.Fprintln(w, op)
+	return offset + 1
+}
+
+func (c *Chunk) readShort(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
+
+// Strings are quoted, so that they can be told apart from the other constants.
+func constantString(chunk *Chunk, index int) string {
+	return stringifyElement(chunk.Constants[index])
+}
+
+// traceInstruction prints the stack, from the bottom up, then the instruction
+// about to run.
+func (vm *VM) traceInstruction(chunk *Chunk, offset int) {
+	var stack strings.Builder
+	stack.WriteString("          ")
+	for _, value := range vm.stack {
+		stack.WriteString("[ ")
+		stack.WriteString(stringifyElement(value))
+		stack.WriteString(" ]")
+	}
+	fmt.Fprintln(vm.trace, stack.String())
+	DisassembleInstruction(vm.trace, chunk, offset)
+}
@@ -0,0 +1,111 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// RuntimeError is an error raised while running a program. Its code defaults
+// to diagnostic.RuntimeError when left empty. The VM fills in its span, from
+// the instruction that raised it, and its stack trace.
+type RuntimeError struct {
+	code  diagnostic.Code
+	msg   string
+	span  token.Span
+	trace []string
+}
+
+func runtimeErrorf(code diagnostic.Code, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// arityError reports a call passing argCount arguments to a callable taking
+// arity of them.
+func arityError(arity, argCount int) *RuntimeError {
+	if arity == 1 {
+		return runtimeErrorf(diagnostic.ArityMismatch, "Expected 1 argument but got %d.", argCount)
+	}
+	return runtimeErrorf(diagnostic.ArityMismatch, "Expected %d arguments but got %d.", arity, argCount)
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Diagnostic().Error()
+}
+
+func (e *RuntimeError) Diagnostic() *diagnostic.Diagnostic {
+	code := e.code
+	if code == "" {
+		code = diagnostic.RuntimeError
+	}
+	return diagnostic.Errorf(code, e.span, "%s", e.msg).WithStackTrace(e.trace)
+}
+
+// A value thrown by a Lox `throw` statement that no catch clause handled.
+type thrownError struct {
+	value Value
+	span  token.Span
+	trace []string
+}
+
+func (e *thrownError) Error() string {
+	return e.Diagnostic().Error()
+}
+
+func (e *thrownError) Diagnostic() *diagnostic.Diagnostic {
+	d := diagnostic.Errorf(diagnostic.UncaughtException, e.span, "Uncaught exception: %v", e.value)
+	return d.WithStackTrace(e.trace)
+}
+
+// An internalError is a Go panic raised while running the program, such as
+// one in a host function, which is a bug in glox rather than in the program.
+// Lox code can't catch it, though finally blocks still run on its way out.
+type internalError struct {
+	reason interface{}
+	span   token.Span
+	trace  []string
+}
+
+func (e *internalError) Error() string {
+	return e.Diagnostic().Error()
+}
+
+func (e *internalError) Diagnostic() *diagnostic.Diagnostic {
+	return diagnostic.Errorf(diagnostic.InternalError, e.span, "Internal error: %v", e.reason).
+		WithNote("this is a bug in glox, not in the script").
+		WithStackTrace(e.trace)
+}
+
+// Runtime errors are caught as instances of this class, with `message` and
+// `line` fields, like in the tree-walking interpreter.
+var runtimeErrorClass = &Class{
+	name:    "RuntimeError",
+	methods: map[string]*Closure{},
+}
+
+// caughtValue converts an error into the value bound by a catch clause.
+func caughtValue(err error) Value {
+	switch e := err.(type) {
+	case *thrownError:
+		return e.value
+	case *RuntimeError:
+		instance := newInstance(runtimeErrorClass)
+		instance.fields["message"] = e.msg
+		instance.fields["line"] = nil
+		if e.span.IsValid() {
+			instance.fields["line"] = float64(e.span.Line)
+		}
+		return instance
+	}
+	instance := newInstance(runtimeErrorClass)
+	instance.fields["message"] = err.Error()
+	instance.fields["line"] = nil
+	return instance
+}
+
+// A pendingError sits on the stack while a finally block runs on the way out
+// of an error, which OpRethrow then raises again.
+type pendingError struct {
+	err error
+}
@@ -0,0 +1,415 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+
+	"github.com/modulitos/glox/pkg/token"
+)
+
+// A .loxc file holds a compiled script, so that it can run without being
+// scanned, parsed, resolved and compiled again. It is laid out as:
+//
+//	magic     "LOXC"
+//	version   2 bytes, big-endian
+//	checksum  4 bytes, big-endian, the CRC-32 of everything after it
+//	hash      32 bytes, the SHA-256 of the source the script was compiled from
+//	file      the path of that source
+//	script    the function of the script
+//
+// Integers are unsigned varints, and strings are their length followed by
+// their bytes. A function is its name, arity, upvalue count and frame size, its code, its
+// spans and its constants. Spans are run-length encoded, since consecutive
+// bytes mostly come from the same token, and all of them are in the file
+// above, unless they are empty. Each constant is a tag byte followed by a
+// float64, a string or a nested function.
+const (
+	loxcMagic = "LOXC"
+	// LoxcVersion changes whenever the layout or the instruction set does, so
+	// that files compiled by an older glox are rejected rather than misread.
+	LoxcVersion = 3
+)
+
+// The tags of the constants.
+const (
+	tagNumber = byte(iota)
+	tagString
+	tagFunction
+)
+
+var (
+	// ErrInvalidBytecode is wrapped by the errors returned for files that
+	// aren't valid .loxc files for this version of glox.
+	ErrInvalidBytecode = errors.New("invalid bytecode file")
+	// ErrStaleBytecode is wrapped by the errors returned for files compiled
+	// from a source that changed since.
+	ErrStaleBytecode = errors.New("stale bytecode file")
+)
+
+// Bytecode is the content of a .loxc file.
+type Bytecode struct {
+	Script *Function
+	// Where the source was read from, and its SHA-256, to tell whether the
+	// file is stale.
+	File       string
+	SourceHash [sha256.Size]byte
+}
+
+// NewBytecode pairs a compiled script with the source it was compiled from.
+func NewBytecode(script *Function, file string, source []byte) *Bytecode {
+	return &Bytecode{
+		Script:     script,
+		File:       file,
+		SourceHash: sha256.Sum256(source),
+	}
+}
+
+// IsStale reports whether the script was compiled from a different source.
+func (b *Bytecode) IsStale(source []byte) bool {
+	return sha256.Sum256(source) != b.SourceHash
+}
+
+// MarshalBinary encodes the bytecode in the .loxc format.
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	var payload encoder
+	payload.Write(b.SourceHash[:])
+	payload.writeString(b.File)
+	payload.writeFunction(b.Script)
+
+	var out bytes.Buffer
+	out.WriteString(loxcMagic)
+	binary.Write(&out, binary.BigEndian, uint16(LoxcVersion))
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+	out.Write(payload.Bytes())
+	return out.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a .loxc file, checking that it was written by this
+// version of glox, that it wasn't corrupted, and that its code is well formed.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	header := len(loxcMagic) + 2 + 4
+	if len(data) < header || string(data[:len(loxcMagic)]) != loxcMagic {
+		return fmt.Errorf("%w: not a .loxc file", ErrInvalidBytecode)
+	}
+	version := binary.BigEndian.Uint16(data[len(loxcMagic):])
+	if version != LoxcVersion {
+		return fmt.Errorf("%w: compiled for version %d of the format, expected version %d", ErrInvalidBytecode, version, LoxcVersion)
+	}
+	checksum := binary.BigEndian.Uint32(data[len(loxcMagic)+2:])
+	payload := data[header:]
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return fmt.Errorf("%w: checksum mismatch, the file is corrupted", ErrInvalidBytecode)
+	}
+
+	d := decoder{data: payload}
+	copy(b.SourceHash[:], d.read(sha256.Size))
+	b.File = d.readString()
+	b.Script = d.readFunction(b.File)
+	if d.err == nil && d.offset != len(d.data) {
+		d.fail("unexpected data after the script")
+	}
+	if d.err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBytecode, d.err)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Encoding
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) writeInt(n int) {
+	e.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeInt(len(s))
+	e.WriteString(s)
+}
+
+func (e *encoder) writeFunction(f *Function) {
+	e.writeString(f.Name)
+	e.writeInt(f.Arity)
+	e.writeInt(f.UpvalueCount)
+	e.writeInt(f.FrameSize)
+	e.writeInt(len(f.Chunk.Code))
+	e.Write(f.Chunk.Code)
+	e.writeSpans(f.Chunk.Spans)
+
+	e.writeInt(len(f.Chunk.Constants))
+	for _, constant := range f.Chunk.Constants {
+		switch c := constant.(type) {
+		case float64:
+			e.WriteByte(tagNumber)
+			binary.Write(e, binary.BigEndian, math.Float64bits(c))
+		case string:
+			e.WriteByte(tagString)
+			e.writeString(c)
+		case *Function:
+			e.WriteByte(tagFunction)
+			e.writeFunction(c)
+		default:
+			panic(fmt.Sprintf("Implementation error: unexpected constant of type %T.", constant))
+		}
+	}
+}
+
+// Each run of equal spans is written as its length, then the span.
+func (e *encoder) writeSpans(spans []token.Span) {
+	for start := 0; start < len(spans); {
+		end := start + 1
+		for end < len(spans) && spans[end] == spans[start] {
+			end++
+		}
+		span := spans[start]
+		e.writeInt(end - start)
+		e.writeInt(span.Line)
+		e.writeInt(span.Column)
+		e.writeInt(span.Start)
+		e.writeInt(span.End)
+		start = end
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Decoding
+
+// A decoder reads the payload of a .loxc file. After the first error, every
+// read returns zero values, so that it only has to be checked at the end.
+type decoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.offset {
+		d.fail("unexpected end of file")
+		return nil
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b
+}
+
+func (d *decoder) readByte() byte {
+	b := d.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readInt() int {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.data[d.offset:])
+	if size <= 0 || n > math.MaxInt32 {
+		d.fail("malformed integer at offset %d", d.offset)
+		return 0
+	}
+	d.offset += size
+	return int(n)
+}
+
+func (d *decoder) readString() string {
+	return string(d.read(d.readInt()))
+}
+
+func (d *decoder) readFunction(file string) *Function {
+	f := &Function{
+		Name:         d.readString(),
+		Arity:        d.readInt(),
+		UpvalueCount: d.readInt(),
+		FrameSize:    d.readInt(),
+	}
+	f.Chunk.Code = append([]byte(nil), d.read(d.readInt())...)
+	f.Chunk.Spans = d.readSpans(file, len(f.Chunk.Code))
+
+	count := d.readInt()
+	for index := 0; index < count && d.err == nil; index++ {
+		switch tag := d.readByte(); tag {
+		case tagNumber:
+			bits := d.read(8)
+			if bits != nil {
+				f.Chunk.Constants = append(f.Chunk.Constants, math.Float64frombits(binary.BigEndian.Uint64(bits)))
+			}
+		case tagString:
+			f.Chunk.Constants = append(f.Chunk.Constants, d.readString())
+		case tagFunction:
+			f.Chunk.Constants = append(f.Chunk.Constants, d.readFunction(file))
+		default:
+			d.fail("unknown constant tag %d", tag)
+		}
+	}
+	if d.err == nil {
+		d.verify(f)
+	}
+	return f
+}
+
+func (d *decoder) readSpans(file string, count int) []token.Span {
+	spans := make([]token.Span, 0, count)
+	for len(spans) < count && d.err == nil {
+		run := d.readInt()
+		span := token.Span{
+			Line:   d.readInt(),
+			Column: d.readInt(),
+			Start:  d.readInt(),
+			End:    d.readInt(),
+		}
+		if span.IsValid() {
+			span.File = file
+		}
+		if run == 0 || run > count-len(spans) {
+			d.fail("malformed line table")
+			break
+		}
+		for index := 0; index < run; index++ {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// verify checks that the code of the function decodes into whole instructions,
+// whose operands refer to constants of the right type, to locals within its
+// frame and to upvalues it has, and whose jumps land on the start of an
+// instruction, so that a file that passed the checksum can't
+// crash the VM with a nonsensical instruction.
+func (d *decoder) verify(f *Function) {
+	chunk := &f.Chunk
+	constant := func(offset int, want string) bool {
+		index := chunk.readShort(offset)
+		if index >= len(chunk.Constants) {
+			d.fail("%s: constant %d out of range at offset %d", f, index, offset)
+			return false
+		}
+		var ok bool
+		switch want {
+		case "string":
+			_, ok = chunk.Constants[index].(string)
+		case "function":
+			_, ok = chunk.Constants[index].(*Function)
+		default:
+			ok = true
+		}
+		if !ok {
+			d.fail("%s: constant %d isn't a %s at offset %d", f, index, want, offset)
+		}
+		return ok
+	}
+	local := func(offset int, slot int) {
+		if slot >= f.FrameSize {
+			d.fail("%s: local %d out of the frame at offset %d", f, slot, offset)
+		}
+	}
+	upvalue := func(offset int, index int) {
+		if index >= f.UpvalueCount {
+			d.fail("%s: upvalue %d out of range at offset %d", f, index, offset)
+		}
+	}
+	// Jumps are checked once every instruction start is known, since most
+	// of them go forward.
+	starts := make([]bool, len(chunk.Code))
+	var jumps [][2]int
+	jump := func(offset int, target int) {
+		jumps = append(jumps, [2]int{offset, target})
+	}
+
+	// The code has to end with a return, so that the VM never runs past it.
+	last := OpNil
+	for offset := 0; offset < len(chunk.Code) && d.err == nil; {
+		starts[offset] = true
+		op := OpCode(chunk.Code[offset])
+		last = op
+		size := 1
+		switch op {
+		case OpConstant, OpList, OpMap, OpJump, OpJumpIfFalse, OpLoop, OpTry, OpTryFinally,
+			OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty, OpSetProperty, OpGetSuper, OpClass, OpMethod,
+			OpImport, OpImportName:
+			size = 3
+		case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall:
+			size = 2
+		case OpInvoke, OpSuperInvoke:
+			size = 4
+		case OpClosure:
+			size = 3
+		default:
+			if op > OpImportAll {
+				d.fail("%s: unknown opcode %d at offset %d", f, op, offset)
+				return
+			}
+		}
+		if offset+size > len(chunk.Code) {
+			d.fail("%s: truncated instruction at offset %d", f, offset)
+			return
+		}
+
+		switch op {
+		case OpConstant:
+			constant(offset+1, "")
+		case OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty, OpSetProperty, OpGetSuper, OpClass,
+			OpMethod, OpInvoke, OpSuperInvoke, OpImport, OpImportName:
+			constant(offset+1, "string")
+		case OpJump, OpJumpIfFalse, OpTry, OpTryFinally:
+			jump(offset, offset+3+chunk.readShort(offset+1))
+		case OpLoop:
+			jump(offset, offset+3-chunk.readShort(offset+1))
+		case OpGetLocal, OpSetLocal:
+			local(offset, int(chunk.Code[offset+1]))
+		case OpGetUpvalue, OpSetUpvalue:
+			upvalue(offset, int(chunk.Code[offset+1]))
+		case OpClosure:
+			if constant(offset+1, "function") {
+				size += 2 * chunk.Constants[chunk.readShort(offset+1)].(*Function).UpvalueCount
+				if offset+size > len(chunk.Code) {
+					d.fail("%s: truncated instruction at offset %d", f, offset)
+					return
+				}
+				// Each capture is a local of this function, or one of its
+				// own upvalues.
+				for capture := offset + 3; capture < offset+size && d.err == nil; capture += 2 {
+					if chunk.Code[capture] == 1 {
+						local(offset, int(chunk.Code[capture+1]))
+					} else {
+						upvalue(offset, int(chunk.Code[capture+1]))
+					}
+				}
+			}
+		}
+		offset += size
+	}
+	if d.err == nil && last != OpReturn {
+		d.fail("%s: the code doesn't end with a return", f)
+	}
+	for _, j := range jumps {
+		if d.err != nil {
+			return
+		}
+		offset, target := j[0], j[1]
+		if target < 0 || target >= len(chunk.Code) {
+			d.fail("%s: jump out of the code at offset %d", f, offset)
+		} else if !starts[target] {
+			d.fail("%s: jump into the middle of an instruction at offset %d", f, offset)
+		}
+	}
+}
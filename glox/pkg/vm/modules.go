@@ -0,0 +1,158 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+)
+
+// The environment variable holding extra directories to search for imported
+// modules, separated like PATH.
+const modulePathEnv = "GLOX_PATH"
+
+// A module is the globals of an imported script, along with the path it was
+// imported as. It sits on the stack while the names imported from it are
+// defined.
+type module struct {
+	path    string
+	globals map[string]Value
+}
+
+func (m *module) String() string {
+	return "<module " + m.path + ">"
+}
+
+// Every module runs at most once per VM, like in the tree-walking interpreter.
+// Its globals are cached by canonical path and shared by everyone importing it.
+type moduleRegistry struct {
+	loaded map[string]map[string]Value
+	// Canonical paths of the modules being loaded, outermost first, so that
+	// we can report the whole cycle when a module imports itself.
+	loading []string
+}
+
+// SetModuleCompiler sets how the source of an imported module is compiled,
+// which the VM leaves to its host since checking a program takes the
+// resolver. Without a compiler, imports fail.
+func (vm *VM) SetModuleCompiler(compile func(path string, source []byte) (*Function, error)) {
+	vm.compileModule = compile
+}
+
+// SetScriptPath records the file the VM is about to run, so that its imports
+// resolve relative to it and importing it back is reported as a cycle. The
+// returned function puts back the script that was running before, and has to
+// be called once the file ran.
+func (vm *VM) SetScriptPath(path string) (restore func(), err error) {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("Resolving script path: %w", err)
+	}
+	previous := vm.scriptPath
+	vm.scriptPath = canonical
+	vm.modules.loading = append(vm.modules.loading, canonical)
+	return func() {
+		vm.scriptPath = previous
+		vm.modules.loading = vm.modules.loading[:len(vm.modules.loading)-1]
+	}, nil
+}
+
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// resolveModulePath looks for the imported file relative to the importing
+// file first, and then in each of the GLOX_PATH directories.
+func (vm *VM) resolveModulePath(importPath string) (string, error) {
+	var candidates []string
+	if filepath.IsAbs(importPath) {
+		candidates = append(candidates, importPath)
+	} else {
+		dir := "."
+		if vm.scriptPath != "" {
+			dir = filepath.Dir(vm.scriptPath)
+		}
+		candidates = append(candidates, filepath.Join(dir, importPath))
+		for _, searchDir := range filepath.SplitList(os.Getenv(modulePathEnv)) {
+			if searchDir != "" {
+				candidates = append(candidates, filepath.Join(searchDir, importPath))
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return canonicalPath(candidate)
+		}
+	}
+	return "", runtimeErrorf(diagnostic.ImportFailed, "Module %q not found, searched: %s.", importPath, strings.Join(candidates, ", "))
+}
+
+// loadModule returns the globals of the imported module, running it first if
+// nobody has imported it yet.
+func (vm *VM) loadModule(importPath string) (map[string]Value, error) {
+	path, err := vm.resolveModulePath(importPath)
+	if err != nil {
+		return nil, err
+	}
+	if globals, ok := vm.modules.loaded[path]; ok {
+		return globals, nil
+	}
+	for index, loading := range vm.modules.loading {
+		if loading == path {
+			cycle := append(append([]string{}, vm.modules.loading[index:]...), path)
+			return nil, runtimeErrorf(diagnostic.ImportFailed, "Import cycle detected: %s.", strings.Join(cycle, " -> "))
+		}
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, runtimeErrorf(diagnostic.ImportFailed, "Reading module %s: %v", path, err)
+	}
+	if vm.compileModule == nil {
+		return nil, runtimeErrorf(diagnostic.ImportFailed, "Can't compile module %s: the VM has no module compiler.", path)
+	}
+	script, err := vm.compileModule(path, source)
+	if err != nil {
+		return nil, fmt.Errorf("Compiling module %s: %w", path, err)
+	}
+
+	// Modules start with the builtins only, and don't see the globals of the
+	// scripts importing them.
+	globals := make(map[string]Value, len(vm.builtins))
+	for name, native := range vm.builtins {
+		globals[name] = native
+	}
+	previousScriptPath := vm.scriptPath
+	vm.scriptPath = path
+	vm.modules.loading = append(vm.modules.loading, path)
+	defer func() {
+		vm.scriptPath = previousScriptPath
+		vm.modules.loading = vm.modules.loading[:len(vm.modules.loading)-1]
+	}()
+
+	closure := &Closure{Function: script, globals: globals}
+	vm.push(closure)
+	_, err = vm.callAndRun(closure, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Running module %s: %w", path, err)
+	}
+	vm.modules.loaded[path] = globals
+	return globals, nil
+}
+
+// importAll defines every global of the module in globals, leaving out the
+// builtins it didn't redefine.
+func (vm *VM) importAll(m *module, globals map[string]Value) {
+	for name, value := range m.globals {
+		if vm.builtins[name] != value {
+			globals[name] = value
+		}
+	}
+}
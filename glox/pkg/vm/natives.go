@@ -0,0 +1,263 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/typename"
+)
+
+// The builtins every program starts with, the same as the tree-walking
+// interpreter's.
+func builtins() []*Native {
+	return []*Native{
+		{name: "clock", arity: 0, fn: nativeClock},
+		{name: "len", arity: 1, fn: nativeLen},
+		{name: "push", arity: 2, fn: nativePush},
+		{name: "pop", arity: 1, fn: nativePop},
+		{name: "slice", arity: 3, fn: nativeSlice},
+		{name: "keys", arity: 1, fn: nativeKeys},
+		{name: "values", arity: 1, fn: nativeValues},
+		{name: "has", arity: 2, fn: nativeHas},
+		{name: "delete", arity: 2, fn: nativeDelete},
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Lists
+
+// checkIndex converts a Lox value into a position in the list.
+func (l *List) checkIndex(index Value) (int, error) {
+	num, ok := index.(float64)
+	if !ok {
+		return 0, runtimeErrorf("", "List index must be a number, got %s.", typename.Of(index))
+	}
+	if num != math.Trunc(num) {
+		return 0, runtimeErrorf("", "List index must be an integer, got %v.", num)
+	}
+	if num < 0 {
+		return 0, runtimeErrorf(diagnostic.IndexOutOfRange, "List index must not be negative, got %v.", num)
+	}
+	if num >= float64(len(l.elements)) {
+		return 0, runtimeErrorf(diagnostic.IndexOutOfRange, "List index %v out of range for list of length %d.", num, len(l.elements))
+	}
+	return int(num), nil
+}
+
+func (l *List) get(index Value) (Value, error) {
+	i, err := l.checkIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	return l.elements[i], nil
+}
+
+func (l *List) setAt(index Value, value Value) error {
+	i, err := l.checkIndex(index)
+	if err != nil {
+		return err
+	}
+	l.elements[i] = value
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Maps
+
+// All NaNs share this key, since Lox considers NaN equal to itself.
+type nanKey struct{}
+
+// normalizeKey turns a Lox value into a Go map key that is equal to another
+// key exactly when isEqual says the two values are equal.
+func normalizeKey(key Value) (Value, error) {
+	switch value := key.(type) {
+	case string:
+		return value, nil
+	case float64:
+		if math.IsNaN(value) {
+			return nanKey{}, nil
+		}
+		// -0 == 0 in Lox, so they have to land on the same entry.
+		if value == 0 {
+			return float64(0), nil
+		}
+		return value, nil
+	}
+	return nil, runtimeErrorf("", "Map keys must be strings or numbers, got %s.", typename.Of(key))
+}
+
+func (m *Map) get(key Value) (Value, error) {
+	normalized, err := normalizeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	position, ok := m.index[normalized]
+	if !ok {
+		return nil, runtimeErrorf("", "Undefined map key %v.", key)
+	}
+	return m.values[position], nil
+}
+
+func (m *Map) setAt(key Value, value Value) error {
+	normalized, err := normalizeKey(key)
+	if err != nil {
+		return err
+	}
+	m.set(normalized, key, value)
+	return nil
+}
+
+func (m *Map) set(normalized Value, key Value, value Value) {
+	if position, ok := m.index[normalized]; ok {
+		m.values[position] = value
+		return
+	}
+	m.index[normalized] = len(m.keys)
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+}
+
+// ----------------------------------------------------------------------------
+// Natives
+
+func nativeClock(args []Value) (Value, error) {
+	return float64(time.Now().UnixMilli()) / 1000.0, nil
+}
+
+func nativeLen(args []Value) (Value, error) {
+	switch value := args[0].(type) {
+	case *List:
+		return float64(len(value.elements)), nil
+	case *Map:
+		return float64(len(value.keys)), nil
+	case string:
+		return float64(len(value)), nil
+	}
+	return nil, runtimeErrorf("", "len() expects a list, a map or a string, got %s.", typename.Of(args[0]))
+}
+
+func nativePush(args []Value) (Value, error) {
+	list, ok := args[0].(*List)
+	if !ok {
+		return nil, runtimeErrorf("", "push() expects a list, got %s.", typename.Of(args[0]))
+	}
+	list.elements = append(list.elements, args[1])
+	return nil, nil
+}
+
+func nativePop(args []Value) (Value, error) {
+	list, ok := args[0].(*List)
+	if !ok {
+		return nil, runtimeErrorf("", "pop() expects a list, got %s.", typename.Of(args[0]))
+	}
+	if len(list.elements) == 0 {
+		return nil, runtimeErrorf("", "Can't pop from an empty list.")
+	}
+	last := list.elements[len(list.elements)-1]
+	list.elements = list.elements[:len(list.elements)-1]
+	return last, nil
+}
+
+// slice(list, start, end) returns a new list holding the elements from start
+// up to, but not including, end.
+func nativeSlice(args []Value) (Value, error) {
+	list, ok := args[0].(*List)
+	if !ok {
+		return nil, runtimeErrorf("", "slice() expects a list, got %s.", typename.Of(args[0]))
+	}
+	start, startOk := args[1].(float64)
+	end, endOk := args[2].(float64)
+	if !startOk || !endOk || start != math.Trunc(start) || end != math.Trunc(end) {
+		return nil, runtimeErrorf("", "slice() bounds must be integers.")
+	}
+	if start < 0 || end > float64(len(list.elements)) || start > end {
+		return nil, runtimeErrorf(diagnostic.IndexOutOfRange, "slice() bounds [%v, %v) out of range for list of length %d.", start, end, len(list.elements))
+	}
+	elements := make([]Value, int(end)-int(start))
+	copy(elements, list.elements[int(start):int(end)])
+	return &List{elements: elements}, nil
+}
+
+func nativeKeys(args []Value) (Value, error) {
+	m, ok := args[0].(*Map)
+	if !ok {
+		return nil, runtimeErrorf("", "keys() expects a map, got %s.", typename.Of(args[0]))
+	}
+	keys := make([]Value, len(m.keys))
+	copy(keys, m.keys)
+	return &List{elements: keys}, nil
+}
+
+func nativeValues(args []Value) (Value, error) {
+	m, ok := args[0].(*Map)
+	if !ok {
+		return nil, runtimeErrorf("", "values() expects a map, got %s.", typename.Of(args[0]))
+	}
+	values := make([]Value, len(m.values))
+	copy(values, m.values)
+	return &List{elements: values}, nil
+}
+
+func nativeHas(args []Value) (Value, error) {
+	m, ok := args[0].(*Map)
+	if !ok {
+		return nil, runtimeErrorf("", "has() expects a map, got %s.", typename.Of(args[0]))
+	}
+	normalized, err := normalizeKey(args[1])
+	if err != nil {
+		return nil, err
+	}
+	_, ok = m.index[normalized]
+	return ok, nil
+}
+
+// delete(map, key) reports whether there was an entry to remove, keeping the
+// remaining entries in insertion order.
+func nativeDelete(args []Value) (Value, error) {
+	m, ok := args[0].(*Map)
+	if !ok {
+		return nil, runtimeErrorf("", "delete() expects a map, got %s.", typename.Of(args[0]))
+	}
+	normalized, err := normalizeKey(args[1])
+	if err != nil {
+		return nil, err
+	}
+	position, ok := m.index[normalized]
+	if !ok {
+		return false, nil
+	}
+	delete(m.index, normalized)
+	m.keys = append(m.keys[:position], m.keys[position+1:]...)
+	m.values = append(m.values[:position], m.values[position+1:]...)
+	for key, p := range m.index {
+		if p > position {
+			m.index[key] = p - 1
+		}
+	}
+	return true, nil
+}
+
+// NewNative wraps a Go function so that it can be called from Lox, which
+// checks that it is called with exactly arity arguments.
+func NewNative(name string, arity int, fn func(args []Value) (Value, error)) *Native {
+	return &Native{name: name, arity: arity, fn: fn}
+}
+
+func (n *Native) call(args []Value) (result Value, err error) {
+	defer func() {
+		if reason := recover(); reason != nil {
+			result, err = nil, &internalError{reason: reason}
+		}
+	}()
+	result, err = n.fn(args)
+	if err != nil {
+		// Host errors are reported like any other runtime error, so that
+		// they can be caught.
+		if _, ok := err.(*RuntimeError); !ok {
+			err = &RuntimeError{msg: fmt.Sprintf("%v", err)}
+		}
+	}
+	return result, err
+}
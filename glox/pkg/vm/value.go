@@ -0,0 +1,362 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/modulitos/glox/pkg/typename"
+)
+
+// A Value is any Lox value: nil, bool, float64, string, or a pointer to one of
+// the object types below. They are the same Go types the tree-walking
+// interpreter uses for primitives, so both print and compare them alike.
+type Value = interface{}
+
+// A Closure is a function along with the variables it captured.
+type Closure struct {
+	Function *Function
+	Upvalues []*Upvalue
+	// The globals of the script or module the closure was created in.
+	globals map[string]Value
+}
+
+func (c *Closure) String() string {
+	return c.Function.String()
+}
+
+func (c *Closure) LoxType() string {
+	return typename.Function
+}
+
+// An Upvalue refers to a variable captured by a closure. While the variable is
+// still on the stack, the upvalue is open and points at its slot. When the
+// variable goes out of scope, its value moves into the upvalue, which is then
+// closed.
+type Upvalue struct {
+	slot   int
+	closed Value
+	open   bool
+	// The next open upvalue, further down the stack.
+	next *Upvalue
+}
+
+// A Native is a function implemented in Go.
+type Native struct {
+	name  string
+	arity int
+	fn    func(args []Value) (Value, error)
+}
+
+func (n *Native) String() string {
+	return "<native fn>"
+}
+
+func (n *Native) LoxType() string {
+	return typename.Function
+}
+
+// A Class holds its methods, including the ones it inherited: they are copied
+// down from the superclass when the class is created.
+type Class struct {
+	name    string
+	methods map[string]*Closure
+}
+
+func (c *Class) String() string {
+	return c.name
+}
+
+func (c *Class) LoxType() string {
+	return typename.Class
+}
+
+type Instance struct {
+	class  *Class
+	fields map[string]Value
+}
+
+func newInstance(class *Class) *Instance {
+	return &Instance{
+		class:  class,
+		fields: make(map[string]Value),
+	}
+}
+
+func (i *Instance) String() string {
+	return fmt.Sprintf("%s instance", i.class.name)
+}
+
+func (i *Instance) LoxType() string {
+	return typename.Instance
+}
+
+// A BoundMethod is a method accessed on an instance, which keeps the instance
+// around to become `this` when the method is called.
+type BoundMethod struct {
+	receiver Value
+	method   *Closure
+}
+
+func (b *BoundMethod) String() string {
+	return b.method.String()
+}
+
+func (b *BoundMethod) LoxType() string {
+	return typename.Function
+}
+
+// Lists and maps are passed around by reference, like in the tree-walking
+// interpreter, and maps also keep their entries in insertion order.
+type List struct {
+	elements []Value
+}
+
+func (l *List) LoxType() string {
+	return typename.List
+}
+
+type Map struct {
+	keys   []Value
+	values []Value
+	// Maps a normalized key to its position in keys and values.
+	index map[Value]int
+}
+
+func newMap() *Map {
+	return &Map{
+		index: make(map[Value]int),
+	}
+}
+
+func (m *Map) LoxType() string {
+	return typename.Map
+}
+
+// ----------------------------------------------------------------------------
+// Value semantics, matching the tree-walking interpreter's.
+
+// Lox follows Ruby’s simple rule: false and nil are falsey, and everything else
+// is truthy.
+func isTruthy(value Value) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// Values are equal when they have the same type and value, except NaN, which
+// is equal to itself like in jlox. Objects are only equal to themselves.
+func isEqual(a, b Value) bool {
+	if x, ok := a.(float64); ok {
+		y, ok := b.(float64)
+		if !ok {
+			return false
+		}
+		return x == y || math.IsNaN(x) && math.IsNaN(y)
+	}
+	return a == b
+}
+
+func stringify(value Value) string {
+	return stringifyValue(value, false, map[Value]bool{})
+}
+
+// Strings inside collections are quoted, so that ["a, b"] and ["a", "b"] print
+// differently.
+func stringifyElement(value Value) string {
+	return stringifyValue(value, true, map[Value]bool{})
+}
+
+// stringifyValue keeps track of the collections being printed, so that a list
+// or a map that contains itself prints as [...] or {...} there rather than
+// forever.
+func stringifyValue(value Value, quote bool, printing map[Value]bool) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		if quote {
+			return strconv.Quote(v)
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case *List:
+		if printing[v] {
+			return "[...]"
+		}
+		printing[v] = true
+		defer delete(printing, v)
+		var b strings.Builder
+		b.WriteString("[")
+		for index, element := range v.elements {
+			if index > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(stringifyValue(element, true, printing))
+		}
+		b.WriteString("]")
+		return b.String()
+	case *Map:
+		if printing[v] {
+			return "{...}"
+		}
+		printing[v] = true
+		defer delete(printing, v)
+		var b strings.Builder
+		b.WriteString("{")
+		for index, key := range v.keys {
+			if index > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(stringifyValue(key, true, printing))
+			b.WriteString(": ")
+			b.WriteString(stringifyValue(v.values[index], true, printing))
+		}
+		b.WriteString("}")
+		return b.String()
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// ----------------------------------------------------------------------------
+// Conversions from and to Go, for embedding.
+
+// FromGo converts a value from a host Go program into a Lox value, the same
+// way interpreter.FromGo does.
+func FromGo(value interface{}) (Value, error) {
+	switch v := value.(type) {
+	case nil, bool, float64, string, *Closure, *Native, *Class, *Instance, *BoundMethod, *List, *Map:
+		return v, nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]Value, v.Len())
+		for index := range elements {
+			converted, err := FromGo(v.Index(index).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[index] = converted
+		}
+		return &List{elements: elements}, nil
+	case reflect.Map:
+		entries := make([]mapEntry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := FromGo(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case float64, string:
+			default:
+				return nil, fmt.Errorf("Can't convert Go map key of type %T to a Lox map key.", iter.Key().Interface())
+			}
+			entries = append(entries, mapEntry{key: key, value: iter.Value().Interface()})
+		}
+		sortEntries(entries)
+		m := newMap()
+		for _, entry := range entries {
+			converted, err := FromGo(entry.value)
+			if err != nil {
+				return nil, err
+			}
+			normalized, err := normalizeKey(entry.key)
+			if err != nil {
+				return nil, err
+			}
+			m.set(normalized, entry.key, converted)
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("Can't convert Go value of type %T to a Lox value.", value)
+}
+
+// A mapEntry is an entry of a Go map, whose key was converted already.
+type mapEntry struct {
+	key   Value
+	value interface{}
+}
+
+// sortEntries sorts the entries of a Go map by key, numbers first.
+func sortEntries(entries []mapEntry) {
+	sort.Slice(entries, func(a, b int) bool {
+		x, xIsNumber := entries[a].key.(float64)
+		y, yIsNumber := entries[b].key.(float64)
+		if xIsNumber != yIsNumber {
+			return xIsNumber
+		}
+		if xIsNumber {
+			return x < y
+		}
+		return entries[a].key.(string) < entries[b].key.(string)
+	})
+}
+
+// ToGo converts a Lox value for use by a host Go program, the same way
+// interpreter.ToGo does.
+func ToGo(value Value) interface{} {
+	if containsItself(value, map[Value]bool{}) {
+		return value
+	}
+	switch v := value.(type) {
+	case *List:
+		elements := make([]interface{}, len(v.elements))
+		for index, element := range v.elements {
+			elements[index] = ToGo(element)
+		}
+		return elements
+	case *Map:
+		m := make(map[interface{}]interface{}, len(v.keys))
+		for index, key := range v.keys {
+			m[key] = ToGo(v.values[index])
+		}
+		return m
+	}
+	return value
+}
+
+// containsItself tells whether a collection is found again inside itself,
+// at any depth.
+func containsItself(value Value, visiting map[Value]bool) bool {
+	var elements []Value
+	switch v := value.(type) {
+	case *List:
+		elements = v.elements
+	case *Map:
+		// Keys are strings or numbers, only values can be collections.
+		elements = v.values
+	default:
+		return false
+	}
+	if visiting[value] {
+		return true
+	}
+	visiting[value] = true
+	defer delete(visiting, value)
+	for _, element := range elements {
+		if containsItself(element, visiting) {
+			return true
+		}
+	}
+	return false
+}
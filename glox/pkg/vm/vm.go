@@ -0,0 +1,786 @@
+// Package vm runs Lox programs on a stack-based bytecode virtual machine, the
+// way clox does in Part III of Crafting Interpreters. It is an alternative to
+// the tree-walking interpreter, which it matches in behavior.
+package vm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/modulitos/glox/pkg/diagnostic"
+	"github.com/modulitos/glox/pkg/token"
+	"github.com/modulitos/glox/pkg/typename"
+)
+
+// The same limit as the tree-walking interpreter's, counting the calls to
+// Lox functions on top of the script.
+const maxCallDepth = 10000
+
+type VM struct {
+	stdout  io.Writer
+	stack   []Value
+	frames  []frame
+	globals map[string]Value
+	// The natives every program starts with, to tell them apart from the
+	// globals the scripts define.
+	builtins map[string]Value
+	// The open upvalues, sorted by stack slot from the top down, so that
+	// closures capturing the same variable share its upvalue.
+	openUpvalues *Upvalue
+	handlers     []handler
+	// Where to print the stack and each instruction as it runs, if anywhere.
+	trace io.Writer
+	// The file being run, which imports resolve relative to, if any.
+	scriptPath    string
+	modules       moduleRegistry
+	compileModule func(path string, source []byte) (*Function, error)
+}
+
+// A frame is a call in progress. Its locals start at base on the stack, with
+// the function being called, or the receiver of a method, in slot 0.
+type frame struct {
+	closure *Closure
+	ip      int
+	base    int
+	// The name the call shows in stack traces.
+	name string
+}
+
+// A handler catches the errors raised inside a try block.
+type handler struct {
+	// The frame and stack height to unwind to.
+	frame int
+	stack int
+	// Where the catch clause, or the finally block, starts.
+	target  int
+	finally bool
+}
+
+func New(stdout io.Writer) *VM {
+	vm := &VM{
+		stdout:   stdout,
+		globals:  make(map[string]Value),
+		builtins: make(map[string]Value),
+		modules:  moduleRegistry{loaded: make(map[string]map[string]Value)},
+	}
+	for _, native := range builtins() {
+		vm.globals[native.name] = native
+		vm.builtins[native.name] = native
+	}
+	return vm
+}
+
+// Interpret runs the function of a compiled script, and returns the value it
+// returned. Globals stay defined from one script to the next.
+func (vm *VM) Interpret(script *Function) (Value, error) {
+	closure := &Closure{Function: script, globals: vm.globals}
+	vm.push(closure)
+	return vm.callAndRun(closure, 0)
+}
+
+// Call calls a Lox callable, such as a function fetched with GetGlobal, from
+// Go.
+func (vm *VM) Call(callee Value, args []Value) (Value, error) {
+	vm.push(callee)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	return vm.callAndRun(callee, len(args))
+}
+
+// SetTrace makes the VM print the stack, then the instruction, before running
+// each instruction. A nil writer turns tracing off.
+func (vm *VM) SetTrace(w io.Writer) {
+	vm.trace = w
+}
+
+// DefineGlobal defines, or redefines, a global variable.
+func (vm *VM) DefineGlobal(name string, value Value) {
+	vm.globals[name] = value
+}
+
+// GetGlobal looks up a global variable, including the builtin natives.
+func (vm *VM) GetGlobal(name string) (Value, bool) {
+	value, ok := vm.globals[name]
+	return value, ok
+}
+
+// GlobalNames returns the sorted names of the globals the scripts and the host
+// defined, leaving out the builtins they didn't redefine.
+func (vm *VM) GlobalNames() []string {
+	names := make([]string, 0, len(vm.globals))
+	for name, value := range vm.globals {
+		if vm.builtins[name] != value {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuiltinNames returns the sorted names of the builtin natives.
+func (vm *VM) BuiltinNames() []string {
+	names := make([]string, 0, len(vm.builtins))
+	for name := range vm.builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Inspect formats a value the way lists print their elements, with strings
+// quoted, so that it reads as the Lox literal it would be written as.
+func Inspect(value Value) string {
+	return stringifyElement(value)
+}
+
+// callAndRun calls the callee sitting on the stack below its arguments, and
+// runs until the call returns. The VM is left as it was found, even when the
+// call fails, or when the VM itself panics.
+func (vm *VM) callAndRun(callee Value, argCount int) (result Value, err error) {
+	frames, handlers := len(vm.frames), len(vm.handlers)
+	stack := len(vm.stack) - argCount - 1
+	defer func() {
+		if reason := recover(); reason != nil {
+			e := &internalError{reason: reason}
+			if len(vm.frames) > frames {
+				// The instruction that panicked was read already.
+				f := vm.frames[len(vm.frames)-1]
+				if f.ip > 0 {
+					e.span = f.closure.Function.Chunk.Spans[f.ip-1]
+				}
+				e.trace = vm.stackTrace(e.span)
+			}
+			err = e
+		}
+		if err != nil {
+			vm.closeUpvalues(stack)
+			vm.frames = vm.frames[:frames]
+			vm.handlers = vm.handlers[:handlers]
+			vm.stack = vm.stack[:stack]
+			result = nil
+		}
+	}()
+	err = vm.callValue(callee, argCount)
+	if err == nil && len(vm.frames) > frames {
+		result, err = vm.run(frames, handlers)
+	} else if err == nil {
+		// Natives and classes without an initializer are done right away.
+		result = vm.pop()
+	}
+	return result, err
+}
+
+// ----------------------------------------------------------------------------
+// The stack
+
+func (vm *VM) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+func (vm *VM) peek(distance int) Value {
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+// ----------------------------------------------------------------------------
+// Calls
+
+// callValue starts a call to callee, which sits on the stack below its
+// arguments. Lox functions get a frame that the run loop then executes, while
+// natives are called right away and leave their result on the stack.
+func (vm *VM) callValue(callee Value, argCount int) error {
+	switch c := callee.(type) {
+	case *Closure:
+		return vm.call(c, argCount, c.Function.Name)
+	case *BoundMethod:
+		vm.stack[len(vm.stack)-argCount-1] = c.receiver
+		return vm.call(c.method, argCount, c.method.Function.Name)
+	case *Class:
+		vm.stack[len(vm.stack)-argCount-1] = newInstance(c)
+		if initializer, ok := c.methods["init"]; ok {
+			return vm.call(initializer, argCount, c.name)
+		}
+		if argCount != 0 {
+			return arityError(0, argCount)
+		}
+		return nil
+	case *Native:
+		if argCount != c.arity {
+			return arityError(c.arity, argCount)
+		}
+		result, err := c.call(vm.stack[len(vm.stack)-argCount:])
+		if err != nil {
+			return err
+		}
+		vm.stack = vm.stack[:len(vm.stack)-argCount-1]
+		vm.push(result)
+		return nil
+	}
+	return runtimeErrorf(diagnostic.NotCallable, "Can only call functions and classes. Callee is unexpected type: %s.", typename.Of(callee))
+}
+
+func (vm *VM) call(closure *Closure, argCount int, name string) error {
+	if argCount != closure.Function.Arity {
+		return arityError(closure.Function.Arity, argCount)
+	}
+	if len(vm.frames) > maxCallDepth {
+		return runtimeErrorf(diagnostic.StackOverflow, "Stack overflow.")
+	}
+	vm.frames = append(vm.frames, frame{
+		closure: closure,
+		base:    len(vm.stack) - argCount - 1,
+		name:    name,
+	})
+	return nil
+}
+
+func (vm *VM) invoke(name string, argCount int) error {
+	instance, ok := vm.peek(argCount).(*Instance)
+	if !ok {
+		return runtimeErrorf("", "Only instances have properties.")
+	}
+	// Fields shadow methods.
+	if value, ok := instance.fields[name]; ok {
+		vm.stack[len(vm.stack)-argCount-1] = value
+		return vm.callValue(value, argCount)
+	}
+	return vm.invokeFromClass(instance.class, name, argCount)
+}
+
+func (vm *VM) invokeFromClass(class *Class, name string, argCount int) error {
+	method, ok := class.methods[name]
+	if !ok {
+		return runtimeErrorf(diagnostic.UndefinedProperty, "Undefined property '%s'.", name)
+	}
+	return vm.call(method, argCount, name)
+}
+
+// bindMethod replaces the instance on top of the stack with its method bound
+// to it.
+func (vm *VM) bindMethod(class *Class, name string) error {
+	method, ok := class.methods[name]
+	if !ok {
+		return runtimeErrorf(diagnostic.UndefinedProperty, "Undefined property '%s'.", name)
+	}
+	vm.stack[len(vm.stack)-1] = &BoundMethod{receiver: vm.peek(0), method: method}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Upvalues
+
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	var previous *Upvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.slot > slot {
+		previous = upvalue
+		upvalue = upvalue.next
+	}
+	if upvalue != nil && upvalue.slot == slot {
+		return upvalue
+	}
+	created := &Upvalue{slot: slot, open: true, next: upvalue}
+	if previous == nil {
+		vm.openUpvalues = created
+	} else {
+		previous.next = created
+	}
+	return created
+}
+
+// closeUpvalues moves the variables at or above slot off the stack, into the
+// upvalues capturing them.
+func (vm *VM) closeUpvalues(slot int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= slot {
+		upvalue := vm.openUpvalues
+		upvalue.closed = vm.stack[upvalue.slot]
+		upvalue.open = false
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+func (vm *VM) getUpvalue(upvalue *Upvalue) Value {
+	if upvalue.open {
+		return vm.stack[upvalue.slot]
+	}
+	return upvalue.closed
+}
+
+func (vm *VM) setUpvalue(upvalue *Upvalue, value Value) {
+	if upvalue.open {
+		vm.stack[upvalue.slot] = value
+	} else {
+		upvalue.closed = value
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Errors
+
+// stackTrace describes the calls in progress, innermost first, like the
+// tree-walking interpreter does. at is where the innermost one is running.
+func (vm *VM) stackTrace(at token.Span) []string {
+	if len(vm.frames) < 2 {
+		return nil
+	}
+	trace := make([]string, 0, len(vm.frames))
+	for index := len(vm.frames) - 1; index >= 0; index-- {
+		f := vm.frames[index]
+		name := f.name
+		// The scripts of imported modules have no name either.
+		if index == 0 || name == "" {
+			name = "<script>"
+		}
+		if index < len(vm.frames)-1 {
+			// Frames below the top one are stopped at the call they made,
+			// whose operands were read already.
+			at = f.closure.Function.Chunk.Spans[f.ip-1]
+		}
+		trace = append(trace, fmt.Sprintf("at %s (%s)", name, at))
+	}
+	return trace
+}
+
+// raise points the error at span, unless it already points somewhere, and
+// adds the stack trace.
+func (vm *VM) raise(err error, span token.Span) error {
+	switch e := err.(type) {
+	case *RuntimeError:
+		if !e.span.IsValid() {
+			e.span = span
+		}
+		if e.trace == nil {
+			e.trace = vm.stackTrace(e.span)
+		}
+	case *thrownError:
+		e.trace = vm.stackTrace(e.span)
+	case *internalError:
+		if !e.span.IsValid() {
+			e.span = span
+		}
+		if e.trace == nil {
+			e.trace = vm.stackTrace(e.span)
+		}
+	}
+	return err
+}
+
+// catch unwinds to the innermost handler installed by the current run, and
+// reports whether there was one. Internal errors skip the catch clauses, and
+// only stop at finally blocks.
+func (vm *VM) catch(err error, handlers int) bool {
+	_, internal := err.(*internalError)
+	for internal && len(vm.handlers) > handlers && !vm.handlers[len(vm.handlers)-1].finally {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+	if len(vm.handlers) == handlers {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.closeUpvalues(h.stack)
+	vm.frames = vm.frames[:h.frame+1]
+	vm.stack = vm.stack[:h.stack]
+	if h.finally {
+		vm.push(&pendingError{err: err})
+	} else {
+		vm.push(caughtValue(err))
+	}
+	vm.frames[h.frame].ip = h.target
+	return true
+}
+
+// ----------------------------------------------------------------------------
+// The interpreter loop
+
+// run executes instructions until the frame at index frames returns, or an
+// error is raised that no handler above index handlers catches.
+func (vm *VM) run(frames int, handlers int) (Value, error) {
+	f := &vm.frames[len(vm.frames)-1]
+	chunk := &f.closure.Function.Chunk
+
+	readByte := func() byte {
+		b := chunk.Code[f.ip]
+		f.ip++
+		return b
+	}
+	readShort := func() int {
+		value := int(chunk.Code[f.ip])<<8 | int(chunk.Code[f.ip+1])
+		f.ip += 2
+		return value
+	}
+	readString := func() string {
+		return chunk.Constants[readShort()].(string)
+	}
+
+	for {
+		start := f.ip
+		if vm.trace != nil {
+			vm.traceInstruction(chunk, start)
+		}
+		var err error
+		// Errors about an operand, like an undefined property, point at it
+		// rather than at the instruction.
+		errSpan := chunk.Spans[start]
+
+		switch op := OpCode(readByte()); op {
+		case OpConstant:
+			vm.push(chunk.Constants[readShort()])
+		case OpNil:
+			vm.push(nil)
+		case OpTrue:
+			vm.push(true)
+		case OpFalse:
+			vm.push(false)
+		case OpPop:
+			vm.pop()
+
+		case OpGetLocal:
+			vm.push(vm.stack[f.base+int(readByte())])
+		case OpSetLocal:
+			vm.stack[f.base+int(readByte())] = vm.peek(0)
+		case OpGetGlobal:
+			errSpan = chunk.Spans[f.ip]
+			name := readString()
+			value, ok := f.closure.globals[name]
+			if !ok {
+				err = runtimeErrorf(diagnostic.UndefinedVariable, "Undefined variable: %s.", name)
+				break
+			}
+			vm.push(value)
+		case OpDefineGlobal:
+			f.closure.globals[readString()] = vm.pop()
+		case OpSetGlobal:
+			errSpan = chunk.Spans[f.ip]
+			name := readString()
+			if _, ok := f.closure.globals[name]; !ok {
+				err = runtimeErrorf(diagnostic.UndefinedVariable, "Cannot assign undeclared variable: '%s'.", name)
+				break
+			}
+			f.closure.globals[name] = vm.peek(0)
+		case OpGetUpvalue:
+			vm.push(vm.getUpvalue(f.closure.Upvalues[readByte()]))
+		case OpSetUpvalue:
+			vm.setUpvalue(f.closure.Upvalues[readByte()], vm.peek(0))
+
+		case OpGetProperty:
+			errSpan = chunk.Spans[f.ip]
+			name := readString()
+			instance, ok := vm.peek(0).(*Instance)
+			if !ok {
+				err = runtimeErrorf("", "Only instances have properties.")
+				break
+			}
+			if value, ok := instance.fields[name]; ok {
+				vm.stack[len(vm.stack)-1] = value
+				break
+			}
+			err = vm.bindMethod(instance.class, name)
+		case OpSetProperty:
+			errSpan = chunk.Spans[f.ip]
+			name := readString()
+			instance, ok := vm.peek(1).(*Instance)
+			if !ok {
+				err = runtimeErrorf("", "Only instances have fields.")
+				break
+			}
+			value := vm.pop()
+			instance.fields[name] = value
+			vm.stack[len(vm.stack)-1] = value
+		case OpGetSuper:
+			errSpan = chunk.Spans[f.ip]
+			name := readString()
+			superclass := vm.pop().(*Class)
+			err = vm.bindMethod(superclass, name)
+
+		case OpEqual:
+			b := vm.pop()
+			vm.stack[len(vm.stack)-1] = isEqual(vm.peek(0), b)
+		case OpGreater, OpGreaterEqual, OpLess, OpLessEqual, OpSubtract, OpMultiply, OpDivide:
+			a, aOk := vm.peek(1).(float64)
+			b, bOk := vm.peek(0).(float64)
+			if !aOk || !bOk {
+				err = runtimeErrorf(diagnostic.InvalidOperand, "Operand must be a number")
+				break
+			}
+			var result Value
+			switch op {
+			case OpGreater:
+				result = a > b
+			case OpGreaterEqual:
+				result = a >= b
+			case OpLess:
+				result = a < b
+			case OpLessEqual:
+				result = a <= b
+			case OpSubtract:
+				result = a - b
+			case OpMultiply:
+				result = a * b
+			case OpDivide:
+				if b == 0 && a != 0 {
+					err = runtimeErrorf("", "Cannot divide by zero.")
+					break
+				}
+				if b == 0 {
+					result = math.NaN()
+				} else {
+					result = a / b
+				}
+			}
+			if err != nil {
+				break
+			}
+			vm.pop()
+			vm.stack[len(vm.stack)-1] = result
+		case OpAdd:
+			var result Value
+			result, err = add(vm.peek(1), vm.peek(0))
+			if err != nil {
+				break
+			}
+			vm.pop()
+			vm.stack[len(vm.stack)-1] = result
+		case OpNot:
+			vm.stack[len(vm.stack)-1] = !isTruthy(vm.peek(0))
+		case OpNegate:
+			num, ok := vm.peek(0).(float64)
+			if !ok {
+				err = runtimeErrorf(diagnostic.InvalidOperand, "Operand must be a number")
+				break
+			}
+			vm.stack[len(vm.stack)-1] = -num
+
+		case OpPrint:
+			fmt.Fprintln(vm.stdout, stringify(vm.pop()))
+
+		case OpJump:
+			offset := readShort()
+			f.ip += offset
+		case OpJumpIfFalse:
+			offset := readShort()
+			if !isTruthy(vm.peek(0)) {
+				f.ip += offset
+			}
+		case OpLoop:
+			offset := readShort()
+			f.ip -= offset
+
+		case OpCall:
+			argCount := int(readByte())
+			err = vm.callValue(vm.peek(argCount), argCount)
+			if err == nil {
+				f = &vm.frames[len(vm.frames)-1]
+				chunk = &f.closure.Function.Chunk
+			}
+		case OpInvoke:
+			name := readString()
+			argCount := int(readByte())
+			err = vm.invoke(name, argCount)
+			if err != nil {
+				if e, ok := err.(*RuntimeError); ok && e.code != diagnostic.ArityMismatch && e.code != diagnostic.NotCallable {
+					errSpan = chunk.Spans[start+1]
+				}
+				break
+			}
+			f = &vm.frames[len(vm.frames)-1]
+			chunk = &f.closure.Function.Chunk
+		case OpSuperInvoke:
+			name := readString()
+			argCount := int(readByte())
+			superclass := vm.pop().(*Class)
+			err = vm.invokeFromClass(superclass, name, argCount)
+			if err != nil {
+				if e, ok := err.(*RuntimeError); ok && e.code == diagnostic.UndefinedProperty {
+					errSpan = chunk.Spans[start+1]
+				}
+				break
+			}
+			f = &vm.frames[len(vm.frames)-1]
+			chunk = &f.closure.Function.Chunk
+
+		case OpClosure:
+			function := chunk.Constants[readShort()].(*Function)
+			closure := &Closure{
+				Function: function,
+				Upvalues: make([]*Upvalue, function.UpvalueCount),
+				globals:  f.closure.globals,
+			}
+			for index := range closure.Upvalues {
+				isLocal := readByte()
+				slot := int(readByte())
+				if isLocal == 1 {
+					closure.Upvalues[index] = vm.captureUpvalue(f.base + slot)
+				} else {
+					closure.Upvalues[index] = f.closure.Upvalues[slot]
+				}
+			}
+			vm.push(closure)
+		case OpCloseUpvalue:
+			vm.closeUpvalues(len(vm.stack) - 1)
+			vm.pop()
+		case OpReturn:
+			result := vm.pop()
+			vm.closeUpvalues(f.base)
+			vm.stack = vm.stack[:f.base]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == frames {
+				return result, nil
+			}
+			vm.push(result)
+			f = &vm.frames[len(vm.frames)-1]
+			chunk = &f.closure.Function.Chunk
+
+		case OpClass:
+			vm.push(&Class{name: readString(), methods: make(map[string]*Closure)})
+		case OpInherit:
+			superclass, ok := vm.peek(1).(*Class)
+			if !ok {
+				err = runtimeErrorf("", "Superclass must be a class.")
+				break
+			}
+			subclass := vm.pop().(*Class)
+			for name, method := range superclass.methods {
+				subclass.methods[name] = method
+			}
+		case OpMethod:
+			name := readString()
+			method := vm.pop().(*Closure)
+			vm.peek(0).(*Class).methods[name] = method
+
+		case OpList:
+			count := readShort()
+			elements := make([]Value, count)
+			copy(elements, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(&List{elements: elements})
+		case OpMap:
+			count := readShort()
+			entries := vm.stack[len(vm.stack)-2*count:]
+			m := newMap()
+			for index := 0; index < count && err == nil; index++ {
+				err = m.setAt(entries[2*index], entries[2*index+1])
+			}
+			if err != nil {
+				break
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2*count]
+			vm.push(m)
+		case OpGetIndex:
+			var result Value
+			switch collection := vm.peek(1).(type) {
+			case *List:
+				result, err = collection.get(vm.peek(0))
+			case *Map:
+				result, err = collection.get(vm.peek(0))
+			default:
+				err = runtimeErrorf("", "Only lists and maps can be indexed, got %s.", typename.Of(collection))
+			}
+			if err != nil {
+				break
+			}
+			vm.pop()
+			vm.stack[len(vm.stack)-1] = result
+		case OpSetIndex:
+			value := vm.peek(0)
+			switch collection := vm.peek(2).(type) {
+			case *List:
+				err = collection.setAt(vm.peek(1), value)
+			case *Map:
+				err = collection.setAt(vm.peek(1), value)
+			default:
+				err = runtimeErrorf("", "Only lists and maps can be indexed, got %s.", typename.Of(collection))
+			}
+			if err != nil {
+				break
+			}
+			vm.stack = vm.stack[:len(vm.stack)-3]
+			vm.push(value)
+
+		case OpThrow:
+			err = &thrownError{value: vm.pop(), span: errSpan}
+		case OpTry, OpTryFinally:
+			offset := readShort()
+			vm.handlers = append(vm.handlers, handler{
+				frame:   len(vm.frames) - 1,
+				stack:   len(vm.stack),
+				target:  f.ip + offset,
+				finally: op == OpTryFinally,
+			})
+		case OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case OpRethrow:
+			err = vm.pop().(*pendingError).err
+
+		case OpImport:
+			errSpan = chunk.Spans[f.ip]
+			path := readString()
+			var globals map[string]Value
+			globals, err = vm.loadModule(path)
+			// Running the module may have moved the frames.
+			f = &vm.frames[len(vm.frames)-1]
+			chunk = &f.closure.Function.Chunk
+			if err != nil {
+				break
+			}
+			vm.push(&module{path: path, globals: globals})
+		case OpImportName:
+			errSpan = chunk.Spans[f.ip]
+			name := readString()
+			m := vm.peek(0).(*module)
+			value, ok := m.globals[name]
+			if !ok || vm.builtins[name] == value {
+				err = runtimeErrorf(diagnostic.ImportFailed, "Module %q has no export '%s'.", m.path, name)
+				break
+			}
+			f.closure.globals[name] = value
+		case OpImportAll:
+			vm.importAll(vm.pop().(*module), f.closure.globals)
+
+		default:
+			panic(fmt.Sprintf("Implementation error: unknown opcode %d.", op))
+		}
+
+		if err != nil {
+			// A rethrown error was pointed at its origin already.
+			if OpCode(chunk.Code[start]) != OpRethrow {
+				err = vm.raise(err, errSpan)
+			}
+			if !vm.catch(err, handlers) {
+				return nil, err
+			}
+			f = &vm.frames[len(vm.frames)-1]
+			chunk = &f.closure.Function.Chunk
+		}
+	}
+}
+
+// Numbers add up, strings concatenate, and a number added to a string is
+// converted to a string first.
+func add(a, b Value) (Value, error) {
+	switch x := a.(type) {
+	case float64:
+		switch y := b.(type) {
+		case float64:
+			return x + y, nil
+		case string:
+			return stringify(x) + y, nil
+		}
+	case string:
+		switch y := b.(type) {
+		case float64:
+			return x + stringify(y), nil
+		case string:
+			return x + y, nil
+		}
+	}
+	return nil, runtimeErrorf(diagnostic.InvalidOperand, "operands must be both numbers, both strings, or at least one number and a string. Got %s and %s.", typename.Of(a), typename.Of(b))
+}
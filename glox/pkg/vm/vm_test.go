@@ -0,0 +1,355 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/modulitos/glox/pkg/interpreter"
+	"github.com/modulitos/glox/pkg/parser"
+	"github.com/modulitos/glox/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func compile(t *testing.T, source string) *Function {
+	t.Helper()
+	s := scanner.NewScanner([]byte(source))
+	tokens, err := s.ScanTokens()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	p := parser.Parser{Tokens: tokens}
+	stmts, err := p.Parse()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	resolver := interpreter.NewResolver(nil)
+	if !assert.NoError(t, resolver.ResolveStmts(stmts)) {
+		t.FailNow()
+	}
+	script, err := Compile(stmts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return script
+}
+
+func TestCompile(t *testing.T) {
+	t.Run("constants are shared", func(t *testing.T) {
+		script := compile(t, `print "a" + "a"; print 1 + 1;`)
+
+		assert.Equal(t, []Value{"a", 1.0}, script.Chunk.Constants)
+	})
+
+	t.Run("each instruction has a span", func(t *testing.T) {
+		script := compile(t, "var x = 1;\nprint x;")
+
+		assert.Equal(t, len(script.Chunk.Code), len(script.Chunk.Spans))
+		// The script ends with OpPrint, then the implicit OpNil and OpReturn.
+		assert.Equal(t, byte(OpPrint), script.Chunk.Code[len(script.Chunk.Code)-3])
+		assert.Equal(t, 2, script.Chunk.Spans[len(script.Chunk.Code)-3].Line)
+	})
+
+	t.Run("too many locals", func(t *testing.T) {
+		var source bytes.Buffer
+		source.WriteString("{\n")
+		for index := 0; index < 300; index++ {
+			source.WriteString("var _")
+			source.WriteString(string(rune('a' + index%26)))
+			source.WriteString(string(rune('a' + index/26)))
+			source.WriteString(" = nil;\n")
+		}
+		source.WriteString("}\n")
+		s := scanner.NewScanner(source.Bytes())
+		tokens, _ := s.ScanTokens()
+		p := parser.Parser{Tokens: tokens}
+		stmts, _ := p.Parse()
+
+		_, err := Compile(stmts)
+
+		assert.ErrorContains(t, err, "error[E0400]: Too many local variables in function.")
+	})
+}
+
+func TestVM(t *testing.T) {
+	t.Run("closures share the variables they capture", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		vm := New(stdout)
+
+		_, err := vm.Interpret(compile(t, `
+var get;
+var set;
+{
+  var shared = "before";
+  fun g() { return shared; }
+  fun s(value) { shared = value; }
+  get = g;
+  set = s;
+}
+set("after");
+print get();
+`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "after\n", stdout.String())
+	})
+
+	t.Run("globals persist between scripts", func(t *testing.T) {
+		vm := New(new(bytes.Buffer))
+
+		_, err := vm.Interpret(compile(t, `var counter = 1;`))
+		assert.NoError(t, err)
+		result, err := vm.Interpret(compile(t, `counter + 1;`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2.0, result)
+	})
+
+	t.Run("call a function from Go after an error", func(t *testing.T) {
+		vm := New(new(bytes.Buffer))
+		_, err := vm.Interpret(compile(t, `fun half(n) { return n / 2; }`))
+		assert.NoError(t, err)
+		half, _ := vm.GetGlobal("half")
+
+		_, err = vm.Call(half, []Value{"nope"})
+		assert.ErrorContains(t, err, "Operand must be a number")
+		result, err := vm.Call(half, []Value{9.0})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 4.5, result)
+		assert.Empty(t, vm.stack)
+		assert.Empty(t, vm.frames)
+	})
+}
+
+func TestDisassemble(t *testing.T) {
+	t.Run("functions, constants, jumps and upvalues", func(t *testing.T) {
+		out := new(bytes.Buffer)
+
+		Disassemble(out, compile(t, `fun outer(n) {
+  fun inner() { return n; }
+  if (n) return inner;
+}
+outer("x");`))
+
+		assert.Equal(t, `== <script> ==
+0000    1 OpClosure               0 <fn outer>
+0003    | OpDefineGlobal          1 "outer"
+0006    5 OpGetGlobal             1 "outer"
+0009    | OpConstant              2 "x"
+0012    | OpCall                  1
+0014    | OpReturn
+
+== <fn outer> ==
+0000    2 OpClosure               0 <fn inner>
+0003    |                        local 1
+0005    3 OpGetLocal              1
+0007    | OpJumpIfFalse           7 -> 0017
+0010    | OpPop
+0011    | OpGetLocal              2
+0013    | OpReturn
+0014    | OpJump                 14 -> 0018
+0017    | OpPop
+0018    4 OpNil
+0019    | OpReturn
+
+== <fn inner> ==
+0000    2 OpGetUpvalue            0
+0002    | OpReturn
+0003    | OpNil
+0004    | OpReturn
+`, out.String())
+	})
+
+	t.Run("trace the stack before each instruction", func(t *testing.T) {
+		trace := new(bytes.Buffer)
+		vm := New(new(bytes.Buffer))
+		vm.SetTrace(trace)
+
+		_, err := vm.Interpret(compile(t, `-1;`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, `          [ <script> ]
+0000    1 OpConstant              0 1
+          [ <script> ][ 1 ]
+0003    | OpNegate
+          [ <script> ][ -1 ]
+0004    | OpReturn
+`, trace.String())
+	})
+}
+
+func TestBytecode(t *testing.T) {
+	source := `fun greet(name) { return "hi " + name + " " + 1.5; }
+class A { init() { this.list = [greet("you")]; } }
+print A().list;`
+	encode := func(t *testing.T) []byte {
+		data, err := NewBytecode(compile(t, source), "greet.lox", []byte(source)).MarshalBinary()
+		assert.NoError(t, err)
+		return data
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		var bytecode Bytecode
+		err := bytecode.UnmarshalBinary(encode(t))
+		assert.NoError(t, err)
+		stdout := new(bytes.Buffer)
+
+		_, err = New(stdout).Interpret(bytecode.Script)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "[\"hi you 1.5\"]\n", stdout.String())
+		assert.Equal(t, "greet.lox", bytecode.File)
+		assert.Equal(t, "greet.lox", bytecode.Script.Chunk.Spans[0].File)
+		assert.False(t, bytecode.IsStale([]byte(source)))
+		assert.True(t, bytecode.IsStale([]byte(source+"\n")))
+	})
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		wantErr string
+	}{
+		{
+			name:    "not bytecode",
+			corrupt: func(data []byte) []byte { return []byte("print 1;") },
+			wantErr: "invalid bytecode file: not a .loxc file",
+		},
+		{
+			name: "another version",
+			corrupt: func(data []byte) []byte {
+				data[5] = LoxcVersion + 1
+				return data
+			},
+			wantErr: "invalid bytecode file: compiled for version 4 of the format, expected version 3",
+		},
+		{
+			name: "flipped bit",
+			corrupt: func(data []byte) []byte {
+				data[len(data)/2] ^= 1
+				return data
+			},
+			wantErr: "invalid bytecode file: checksum mismatch, the file is corrupted",
+		},
+		{
+			name:    "truncated",
+			corrupt: func(data []byte) []byte { return data[:len(data)-10] },
+			wantErr: "invalid bytecode file: checksum mismatch, the file is corrupted",
+		},
+		{
+			name: "truncated with a matching checksum",
+			corrupt: func(data []byte) []byte {
+				data = data[:len(data)-10]
+				binary.BigEndian.PutUint32(data[6:], crc32.ChecksumIEEE(data[10:]))
+				return data
+			},
+			wantErr: "invalid bytecode file: unexpected end of file",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var bytecode Bytecode
+
+			err := bytecode.UnmarshalBinary(tc.corrupt(encode(t)))
+
+			assert.ErrorIs(t, err, ErrInvalidBytecode)
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+
+	t.Run("jump into the middle of an instruction", func(t *testing.T) {
+		// Given:
+		source := `var x = true; if (x) print "yes";`
+		script := compile(t, source)
+		code := script.Chunk.Code
+		jump := 0
+		for OpCode(code[jump]) != OpJumpIfFalse {
+			jump = DisassembleInstruction(io.Discard, &script.Chunk, jump)
+		}
+		data, err := NewBytecode(script, "jump.lox", []byte(source)).MarshalBinary()
+		assert.NoError(t, err)
+		// The jump skips the OpPop after it, and lands on the operand of the
+		// OpConstant that follows, with a checksum that still matches.
+		data[bytes.Index(data, code)+jump+2] = 2
+		binary.BigEndian.PutUint32(data[6:], crc32.ChecksumIEEE(data[10:]))
+		var bytecode Bytecode
+
+		// When:
+		err = bytecode.UnmarshalBinary(data)
+
+		// Then:
+		assert.ErrorIs(t, err, ErrInvalidBytecode)
+		assert.EqualError(t, err, fmt.Sprintf("invalid bytecode file: <script>: jump into the middle of an instruction at offset %d", jump))
+	})
+
+	operandTests := []struct {
+		name   string
+		source string
+		// Whether the instruction is in the function the script declares,
+		// rather than in the script itself.
+		nested bool
+		op     OpCode
+		// The byte of the instruction to change, and its new value.
+		at, value int
+		wantErr   string
+	}{
+		{
+			name:    "local out of the frame",
+			source:  `{ var a = 1; print a; }`,
+			op:      OpGetLocal,
+			at:      1,
+			value:   7,
+			wantErr: "<script>: local 7 out of the frame at offset %d",
+		},
+		{
+			name:    "captured local out of the enclosing frame",
+			source:  `fun outer() { var x = 1; fun inner() { return x; } return inner; }`,
+			nested:  true,
+			op:      OpClosure,
+			at:      4,
+			value:   5,
+			wantErr: "<fn outer>: local 5 out of the frame at offset %d",
+		},
+		{
+			name:    "captured upvalue the enclosing function doesn't have",
+			source:  `fun outer() { var x = 1; fun inner() { return x; } return inner; }`,
+			nested:  true,
+			op:      OpClosure,
+			at:      3,
+			value:   0,
+			wantErr: "<fn outer>: upvalue 1 out of range at offset %d",
+		},
+	}
+	for _, tc := range operandTests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given:
+			script := compile(t, tc.source)
+			function := script
+			for _, constant := range script.Chunk.Constants {
+				if nested, ok := constant.(*Function); ok && tc.nested {
+					function = nested
+				}
+			}
+			code := function.Chunk.Code
+			offset := 0
+			for OpCode(code[offset]) != tc.op {
+				offset = DisassembleInstruction(io.Discard, &function.Chunk, offset)
+			}
+			data, err := NewBytecode(script, "operand.lox", []byte(tc.source)).MarshalBinary()
+			assert.NoError(t, err)
+			data[bytes.Index(data, code)+offset+tc.at] = byte(tc.value)
+			binary.BigEndian.PutUint32(data[6:], crc32.ChecksumIEEE(data[10:]))
+			var bytecode Bytecode
+
+			// When:
+			err = bytecode.UnmarshalBinary(data)
+
+			// Then:
+			assert.ErrorIs(t, err, ErrInvalidBytecode)
+			assert.EqualError(t, err, "invalid bytecode file: "+fmt.Sprintf(tc.wantErr, offset))
+		})
+	}
+}